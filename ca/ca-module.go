@@ -7,11 +7,12 @@ import (
 	enc "github.com/zjkmxy/go-ndn/pkg/encoding"
 	"github.com/zjkmxy/go-ndn/pkg/ndn"
 	"github.com/zjkmxy/go-ndn/pkg/ndn/spec_2022"
+	"github.com/zjkmxy/go-ndn/pkg/security"
 	"github.com/zjkmxy/go-ndn/pkg/utils"
 	"go.step.sm/crypto/randutil"
+	"log"
 	"ndn/ndncert/challenge/crypto"
 	"ndn/ndncert/challenge/schemaold"
-	"strconv"
 	"strings"
 	"time"
 )
@@ -39,17 +40,18 @@ type RequestState struct {
 	 */
 	cert ndn.Data
 	/**
-	 * @brief The encryption key for the requester.
+	 * @brief The validity period granted to the certificate to be issued.
 	 */
-	encryptionKey [16]byte
+	notBefore time.Time
+	notAfter  time.Time
 	/**
-	 * @brief The last Initialization Vector used by the AES encryption.
+	 * @brief The encrypted channel shared with the requester.
 	 */
-	encryptionIv []byte
+	session *crypto.Session
 	/**
-	 * @brief The last Initialization Vector used by the other side's AES encryption.
+	 * @brief When the NEW request was accepted.
 	 */
-	decryptionIv []byte
+	created time.Time
 	/**
 	 * @brief The challenge type.
 	 */
@@ -77,51 +79,115 @@ const (
 	Email ChallengeType = iota
 )
 
-const caName = "/ndn"
 const minimumCertificateComponentSize = 4
 const negativeKeyComponentOffset = -4
 const keyString = "KEY"
 const negativeRequestIdOffset = -2
+const responseFreshnessPeriod = 4 * time.Second
+const localeParamKey = "locale"
+const codeParamKey = "code"
+const resendParamKey = "resend"
+
+// A request is dropped this long after it was made or its challenge expired, whichever is
+// later, which leaves the requester time to start the challenge or to collect its outcome.
+const requestRetention = 10 * time.Minute
+
+// Requests past their retention are looked for at most this often.
+const requestSweepInterval = time.Minute
 
 var storage = make(map[[8]byte]*RequestState)
-var availableChallenges = []string{"email"}
+var nextRequestSweep time.Time
+var caName = "/ndn"
+var supportedChallenges = []string{"email"}
+var availableChallenges = supportedChallenges
+var caSigner ndn.Signer
+var caSuite = crypto.DefaultSuite
+var caKeySchedule = crypto.KeyScheduleCompat
+
+func OnProbe(i ndn.Interest) enc.Wire {
+	appParamReader := enc.NewWireReader(i.AppParam())
+	probeInt, err := schemaold.ParseProbeInt(appParamReader, true)
+	if err != nil {
+		return makeErrorResponse(i.Name(), newCaError(ErrorBadParameterFormat, "malformed PROBE parameters"))
+	}
+
+	params := paramValues(probeInt.Params)
+	if _, ok := params[emailParamKey]; ok {
+		if caErr := normalizeEmailParam(params); caErr != nil {
+			return makeErrorResponse(i.Name(), caErr)
+		}
+	}
+
+	caPrefixName, err := enc.NameFromStr(caName)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	var probeResData schemaold.ProbeResData
+	for _, suggestion := range suggestNames(caPrefixName, params) {
+		if isNameAllowed(caPrefixName, suggestion, params) {
+			probeResData.Responses = append(probeResData.Responses, &schemaold.ProbeResponse{Response: suggestion})
+		}
+	}
 
-func OnNew(i ndn.Interest) spec_2022.Data {
+	if len(probeResData.Responses) == 0 {
+		return makeErrorResponse(i.Name(), newCaError(ErrorNoAvailableNames, "no name is available for the given parameters"))
+	}
+
+	return makeResponse(i.Name(), probeResData.Encode())
+}
+
+func OnNew(i ndn.Interest) enc.Wire {
 	var requestState RequestState
 
 	appParamReader := enc.NewWireReader(i.AppParam())
 	newInt, err := schemaold.ParseCmdNewInt(appParamReader, true)
 	if err != nil {
-		panic(err.Error())
+		return makeErrorResponse(i.Name(), newCaError(ErrorBadParameterFormat, "malformed NEW parameters"))
 	}
 
 	certReqReader := enc.NewBufferReader(newInt.CertReq)
 	certReqData, _, err := spec_2022.Spec{}.ReadData(certReqReader)
 	if err != nil {
-		panic(err.Error())
+		return makeErrorResponse(i.Name(), newCaError(ErrorBadParameterFormat, "malformed certificate request"))
 	}
 
 	caPrefixName, err := enc.NameFromStr(caName)
-	if !caPrefixName.IsPrefix(certReqData.Name()) {
+	if err != nil {
 		panic(err.Error())
 	}
 
 	nameComponents := strings.Split(certReqData.Name().String(), "/")
 	if len(nameComponents) < minimumCertificateComponentSize {
-		panic(err.Error())
+		return makeErrorResponse(i.Name(), newCaError(ErrorBadParameterFormat,
+			"certificate name %s is too short", certReqData.Name()))
 	}
 
 	if nameComponents[len(nameComponents)+negativeKeyComponentOffset] != keyString {
-		panic(err.Error())
+		return makeErrorResponse(i.Name(), newCaError(ErrorBadParameterFormat,
+			"certificate name %s has no %s component", certReqData.Name(), keyString))
 	}
 
-	ecdhState := crypto.ECDHState{}
-	ecdhState.GenerateKeyPair()
-	ecdhState.SetRemotePublicKey(newInt.EcdhPub)
-	salt := make([]byte, sha256.New().Size())
-	rand.Read(salt)
+	identity := certReqData.Name()[:len(certReqData.Name())+negativeKeyComponentOffset]
+	if !isNameAllowed(caPrefixName, identity, nil) {
+		return makeErrorResponse(i.Name(), newCaError(ErrorNameNotAllowed,
+			"identity %s is not allowed by this CA", identity))
+	}
 
-	symmetricKey := crypto.HKDF(ecdhState.GetSharedSecret(), salt)
+	requestedNotBefore, requestedNotAfter := certReqData.Signature().Validity()
+	notBefore, notAfter, caErr := resolveValidityPeriod(identity, requestedNotBefore, requestedNotAfter, time.Now())
+	if caErr != nil {
+		return makeErrorResponse(i.Name(), caErr)
+	}
+
+	ecdhState := crypto.ECDHState{Curve: caSuite.Curve}
+	if err := ecdhState.GenerateKeyPair(); err != nil {
+		return makeErrorResponse(i.Name(), cryptoError(err))
+	}
+	salt := make([]byte, sha256.New().Size())
+	if _, err := rand.Read(salt); err != nil {
+		return makeErrorResponse(i.Name(), cryptoError(err))
+	}
 
 	requestState.requestType = New
 	requestState.caPrefix = caPrefixName
@@ -132,8 +198,14 @@ func OnNew(i ndn.Interest) spec_2022.Data {
 	requestId := make([]byte, 8)
 	copy(requestId, _requestId)
 
-	contentType := ndn.ContentTypeBlob
-	fourSeconds := 4 * time.Second
+	var requestIdFixed [8]byte
+	copy(requestIdFixed[:], requestId[:])
+
+	session, err := crypto.NewSessionWithSchedule(caSuite, caKeySchedule, crypto.RoleCa, &ecdhState, newInt.EcdhPub, salt,
+		crypto.KeyContext{RequestId: requestIdFixed, CaPrefix: caPrefixName})
+	if err != nil {
+		return makeErrorResponse(i.Name(), cryptoError(err))
+	}
 
 	cmdNewData := schemaold.CmdNewData{
 		EcdhPub: ecdhState.PublicKey.Bytes(),
@@ -143,35 +215,24 @@ func OnNew(i ndn.Interest) spec_2022.Data {
 
 	cmdNewDataWire := cmdNewData.Encode()
 
-	var requestIdFixed [8]byte
-	var symmetricKeyFixed [16]byte
-
-	copy(requestIdFixed[:], requestId[:])
-	copy(symmetricKeyFixed[:], symmetricKey)
-
+	now := time.Now()
+	sweepRequests(now)
 	storage[requestIdFixed] = &RequestState{
-		caPrefix:      caPrefixName,
-		requestId:     requestIdFixed,
-		requestType:   New,
-		status:        CaModuleBeforeChallenge,
-		cert:          certReqData,
-		encryptionKey: symmetricKeyFixed,
-	}
-
-	return spec_2022.Data{
-		NameV: i.Name(),
-		MetaInfo: &spec_2022.MetaInfo{
-			ContentType:     utils.ConvIntPtr[ndn.ContentType, uint64](&contentType),
-			FreshnessPeriod: &fourSeconds,
-			FinalBlockID:    nil,
-		},
-		ContentV:       cmdNewDataWire,
-		SignatureInfo:  nil,
-		SignatureValue: nil,
+		caPrefix:    caPrefixName,
+		requestId:   requestIdFixed,
+		requestType: New,
+		status:      CaModuleBeforeChallenge,
+		cert:        certReqData,
+		notBefore:   notBefore,
+		notAfter:    notAfter,
+		session:     session,
+		created:     now,
 	}
+
+	return makeResponse(i.Name(), cmdNewDataWire)
 }
 
-func OnChallenge(i ndn.Interest) spec_2022.Data {
+func OnChallenge(i ndn.Interest) enc.Wire {
 	var requestIdFixed [8]byte
 
 	nameComponents := strings.Split(i.Name().String(), "/")
@@ -181,117 +242,286 @@ func OnChallenge(i ndn.Interest) spec_2022.Data {
 	cipherMsgReader := enc.NewWireReader(i.AppParam())
 	cipherMsg, err := schemaold.ParseCipherMsg(cipherMsgReader, true)
 	if err != nil {
-		panic(err.Error())
+		return makeErrorResponse(i.Name(), newCaError(ErrorBadParameterFormat, "malformed CHALLENGE parameters"))
 	}
 
-	var initializationVector [crypto.NonceSizeBytes]byte
-	var authenticationTag [crypto.TagSizeBytes]byte
-
-	copy(initializationVector[:], cipherMsg.InitVec)
-	copy(authenticationTag[:], cipherMsg.AuthNTag)
-
-	encryptedMsg := crypto.EncryptedMessage{
-		initializationVector,
-		authenticationTag,
-		cipherMsg.Payload,
+	sweepRequests(time.Now())
+	requestState, ok := storage[requestIdFixed]
+	if !ok {
+		return makeErrorResponse(i.Name(), newCaError(ErrorInvalidParameters, "unknown request ID %s", requestIdFixed))
 	}
 
-	fmt.Printf("requestId: %s\n", requestIdFixed)
-	requestState := storage[requestIdFixed]
-
-	plaintext := crypto.DecryptPayload(requestState.encryptionKey, encryptedMsg, requestIdFixed)
+	plaintext, err := requestState.session.Open(cipherMsg)
+	if err != nil {
+		return makeErrorResponse(i.Name(), cryptoError(err))
+	}
 	plaintextReader := enc.NewBufferReader(plaintext)
 	challengeIntPlaintext, err := schemaold.ParseChallengeIntPlain(plaintextReader, true)
 	if err != nil {
-		println(challengeIntPlaintext)
-		panic(err.Error())
+		return makeErrorResponse(i.Name(), newCaError(ErrorBadParameterFormat, "malformed CHALLENGE plaintext"))
 	}
 
-	if challengeIntPlaintext.SelectedChal != "email" {
-		panic(fmt.Errorf("Only Supports Email Challenge!"))
+	if !isChallengeAvailable(challengeIntPlaintext.SelectedChal) {
+		return makeErrorResponse(i.Name(), newCaError(ErrorInvalidParameters,
+			"unsupported challenge %q, available challenges are %v", challengeIntPlaintext.SelectedChal, availableChallenges))
+	}
+	if requestState.ChallengeType != "" && challengeIntPlaintext.SelectedChal != requestState.ChallengeType {
+		return makeErrorResponse(i.Name(), newCaError(ErrorInvalidParameters,
+			"request %s is answering the %s challenge", requestIdFixed, requestState.ChallengeType))
 	}
 
 	var chalData schemaold.ChallengeDataPlain
+	params := paramValues(challengeIntPlaintext.Params)
 
-	if requestState.status == CaModuleBeforeChallenge {
-		emailAddress := string(challengeIntPlaintext.Params[0].ParamValue)
-		requestState.ChallengeType = challengeIntPlaintext.SelectedChal
-		requestState.ChallengeState = &EmailChallengeState{Email: emailAddress}
-		err := requestState.ChallengeState.InitiateChallenge()
-		if err != nil {
-			//TODO: Prepare Error Data Packet
+	switch requestState.status {
+	case CaModuleBeforeChallenge:
+		if caErr := normalizeEmailParam(params); caErr != nil {
+			return makeErrorResponse(i.Name(), caErr)
 		}
-		requestState.status = CaModuleChallenge
-		challengeStatus := uint64(requestState.ChallengeState.Status)
-		remainTries := uint64(requestState.ChallengeState.RemainingAttempts)
-		expiry := requestState.ChallengeState.Expiry
-		diff := uint64(expiry.Sub(time.Now()).Seconds())
-		chalData = schemaold.ChallengeDataPlain{
-			Status:      uint64(requestState.status),
-			ChalStatus:  &challengeStatus,
-			RemainTries: &remainTries,
-			RemainTime:  &diff,
+		reservation, caErr := checkChallengeLimits(requestState, params[emailParamKey], time.Now())
+		if caErr != nil {
+			return makeErrorResponse(i.Name(), caErr)
 		}
-	} else if requestState.status == CaModuleChallenge {
-		if challengeIntPlaintext.Params[0].ParamKey != "code" {
-			//TODO: Prepare Error Packet
+		challengeState := &EmailChallengeState{
+			Email:    params[emailParamKey],
+			CertName: requestState.cert.Name().String(),
+			Locale:   params[localeParamKey],
+			Config:   challengeConfigFor(requestIdentity(requestState)),
 		}
-		code := string(challengeIntPlaintext.Params[0].ParamValue)
-		status, _ := requestState.ChallengeState.CheckCode(code)
-		if status == ChallengeModuleFailure {
-			delete(storage, requestIdFixed)
-			// TODO: Prepare Error Data Packet
-
-		} else if status == ChallengeModuleWrongCode {
-			challengeStatus := uint64(requestState.ChallengeState.Status)
-			remainTries := uint64(requestState.ChallengeState.RemainingAttempts)
-			expiry := requestState.ChallengeState.Expiry
-			diff := uint64(expiry.Sub(time.Now()).Seconds())
-			chalData = schemaold.ChallengeDataPlain{
-				Status:      uint64(requestState.status),
-				ChalStatus:  &challengeStatus,
-				RemainTries: &remainTries,
-				RemainTime:  &diff,
+		// The request stays before its challenge, so the requester may try another address.
+		if err := challengeState.InitiateChallenge(); err != nil {
+			reservation.refund()
+			return makeErrorResponse(i.Name(), newCaError(ErrorInvalidParameters, "failed to send the challenge email: %s", err))
+		}
+		requestState.ChallengeType = challengeIntPlaintext.SelectedChal
+		requestState.ChallengeState = challengeState
+		requestState.status = CaModuleChallenge
+		chalData = pendingChallengeData(requestState)
+
+	case CaModuleChallenge:
+		// Without a code, the requester is polling for a challenge completed through its magic link.
+		var status ChallengeStatus
+		if _, ok := params[resendParamKey]; ok {
+			if caErr := resendCode(requestState); caErr != nil {
+				return makeErrorResponse(i.Name(), caErr)
 			}
+			status = requestState.ChallengeState.Poll()
+		} else if code, ok := params[codeParamKey]; ok {
+			status, _ = requestState.ChallengeState.CheckCode(code)
 		} else {
+			status = requestState.ChallengeState.Poll()
+		}
+
+		switch status {
+		case ChallengeModuleFailure:
+			return failRequest(i.Name(), requestState, requestState.ChallengeState.failureError())
+		case ChallengeModuleSuccess:
+			if caErr := checkIdentityBinding(requestState); caErr != nil {
+				return failRequest(i.Name(), requestState, caErr)
+			}
 			requestState.status = CaModulePending
-			//TODO: Issue Certificate
+			newCertName, err := issueCertificate(requestState)
+			if err != nil {
+				return failRequest(i.Name(), requestState, newCaError(ErrorInvalidParameters, "failed to issue the certificate: %s", err))
+			}
 			delete(storage, requestIdFixed)
 			requestState.status = Success
-			challengeStatus := uint64(requestState.ChallengeState.Status)
-			millisecondTS := strconv.FormatInt(time.Now().UnixMilli(), 10)
-			certName := requestState.cert.Name()
-			certNamePrefix := requestState.cert.Name()[:len(certName)+negativeKeyComponentOffset+2].String()
-
-			newCertName, _ := enc.NameFromStr(certNamePrefix + "/NDNCERT/" + millisecondTS)
+			challengeStatus := uint64(status)
 
 			chalData = schemaold.ChallengeDataPlain{
 				Status:     uint64(requestState.status),
 				ChalStatus: &challengeStatus,
 				CertName:   newCertName,
 			}
+		default:
+			chalData = pendingChallengeData(requestState)
 		}
+
+	default:
+		return makeErrorResponse(i.Name(), newCaError(ErrorInvalidParameters,
+			"request %s is no longer waiting for a challenge", requestIdFixed))
 	}
 
 	chalDataBuf := chalData.Encode().Join()
-	chalDataEncryptedMessage := crypto.EncryptPayload(requestState.encryptionKey, chalDataBuf, requestIdFixed)
-	chalDataCiphertext := schemaold.CipherMsg{
-		InitVec:  chalDataEncryptedMessage.InitializationVector[:],
-		AuthNTag: chalDataEncryptedMessage.AuthenticationTag[:],
-		Payload:  chalDataEncryptedMessage.EncryptedPayload,
+	chalDataCiphertext, err := requestState.session.Seal(chalDataBuf)
+	if requestState.status == Success {
+		requestState.session.Close()
+	}
+	if err != nil {
+		return makeErrorResponse(i.Name(), cryptoError(err))
 	}
 	chalDataCiphertextBuf := chalDataCiphertext.Encode()
-	contentType := ndn.ContentTypeBlob
-	fourSeconds := 4 * time.Second
-	return spec_2022.Data{
-		NameV: i.Name(),
-		MetaInfo: &spec_2022.MetaInfo{
-			ContentType:     utils.ConvIntPtr[ndn.ContentType, uint64](&contentType),
-			FreshnessPeriod: &fourSeconds,
-			FinalBlockID:    nil,
-		},
-		ContentV:       chalDataCiphertextBuf,
-		SignatureInfo:  nil,
-		SignatureValue: nil,
+	return makeResponse(i.Name(), chalDataCiphertextBuf)
+}
+
+// pendingChallengeData reports a challenge that is waiting for a code.
+func pendingChallengeData(requestState *RequestState) schemaold.ChallengeDataPlain {
+	challengeState := requestState.ChallengeState.Snapshot()
+	challengeStatus := uint64(challengeState.Status)
+	remainTries := uint64(challengeState.RemainingAttempts)
+	var remainTime uint64
+	if left := time.Until(challengeState.Expiry); left > 0 {
+		remainTime = uint64(left.Seconds())
+	}
+	return schemaold.ChallengeDataPlain{
+		Status:      uint64(requestState.status),
+		ChalStatus:  &challengeStatus,
+		RemainTries: &remainTries,
+		RemainTime:  &remainTime,
+		Params:      requestState.ChallengeState.statusParams(),
+	}
+}
+
+// retainedUntil is when requestState is dropped if it has not finished by then.
+func (r *RequestState) retainedUntil() time.Time {
+	until := r.created
+	if r.ChallengeState != nil {
+		if expiry := r.ChallengeState.Snapshot().Expiry; expiry.After(until) {
+			until = expiry
+		}
+	}
+	return until.Add(requestRetention)
+}
+
+// sweepRequests drops the requests past their retention, so abandoned requests neither
+// pile up in storage nor slow down counting the outstanding challenges.
+func sweepRequests(now time.Time) {
+	if now.Before(nextRequestSweep) {
+		return
 	}
+	nextRequestSweep = now.Add(requestSweepInterval)
+	for requestId, requestState := range storage {
+		if now.After(requestState.retainedUntil()) {
+			delete(storage, requestId)
+			requestState.status = Failure
+			if requestState.session != nil {
+				requestState.session.Close()
+			}
+		}
+	}
+}
+
+// failRequest ends a request that can no longer succeed, and reports why to the requester.
+func failRequest(name enc.Name, requestState *RequestState, caErr *CaError) enc.Wire {
+	delete(storage, requestState.requestId)
+	requestState.status = Failure
+	requestState.session.Close()
+	return makeErrorResponse(name, caErr)
+}
+
+func isChallengeAvailable(challengeType string) bool {
+	for _, available := range availableChallenges {
+		if available == challengeType {
+			return true
+		}
+	}
+	return false
+}
+
+// paramValues indexes the parameters of a PROBE or CHALLENGE Interest by key.
+func paramValues(params []*schemaold.Param) map[string]string {
+	values := make(map[string]string, len(params))
+	for _, param := range params {
+		values[param.ParamKey] = string(param.ParamValue)
+	}
+	return values
+}
+
+// SetPrefix changes the name prefix of the CA, under which it answers and issues certificates.
+func SetPrefix(prefix string) error {
+	name, err := enc.NameFromStr(prefix)
+	if err != nil {
+		return fmt.Errorf("invalid CA prefix %q: %w", prefix, err)
+	}
+	if len(name) == 0 {
+		return fmt.Errorf("CA prefix must not be empty")
+	}
+	caName = name.String()
+	return nil
+}
+
+// SetChallenges changes the challenges offered to requesters, which must all be supported.
+func SetChallenges(challenges []string) error {
+	if len(challenges) == 0 {
+		return fmt.Errorf("at least one challenge must be enabled")
+	}
+	for _, challenge := range challenges {
+		if !IsChallengeSupported(challenge) {
+			return fmt.Errorf("unsupported challenge %q, supported challenges are %v", challenge, supportedChallenges)
+		}
+	}
+	availableChallenges = challenges
+	return nil
+}
+
+// IsChallengeSupported reports whether the CA implements challenge.
+func IsChallengeSupported(challenge string) bool {
+	for _, supported := range supportedChallenges {
+		if challenge == supported {
+			return true
+		}
+	}
+	return false
+}
+
+func SetSigner(signer ndn.Signer) {
+	caSigner = signer
+}
+
+// SetCryptoSuite selects the ECDH curve and cipher used for new requests. Requesters learn
+// it from the CA profile, and requests already in progress keep the suite they started with.
+func SetCryptoSuite(suite crypto.Suite) error {
+	if err := suite.Validate(); err != nil {
+		return err
+	}
+	caSuite = suite
+	return nil
+}
+
+// SetKeySchedule selects how the keys of new requests are derived. KeyScheduleDirectional
+// is only understood by requesters that read it from the CA profile.
+func SetKeySchedule(schedule crypto.KeySchedule) error {
+	if err := schedule.Validate(); err != nil {
+		return err
+	}
+	caKeySchedule = schedule
+	return nil
+}
+
+// makeResponse signs content with the CA key. When the CA cannot sign, for instance because
+// its certificate has expired, the failure is logged and the requester gets an ErrorMsg with
+// only a digest signature, which it cannot trust but which tells it why the CA failed.
+func makeResponse(name enc.Name, content enc.Wire) enc.Wire {
+	signer, err := currentSigner()
+	if err == nil {
+		var wire enc.Wire
+		if wire, err = signResponse(name, content, signer); err == nil {
+			return wire
+		}
+	}
+	log.Printf("ndncert: failed to sign the response to %s: %s", name, err)
+
+	errorMsg := schemaold.ErrorMsg{
+		ErrorCode: uint64(ErrorCaUnavailable),
+		ErrorInfo: "the CA cannot sign responses",
+	}
+	wire, err := signResponse(name, errorMsg.Encode(), security.NewSha256Signer())
+	if err != nil {
+		log.Printf("ndncert: failed to encode the error response to %s: %s", name, err)
+		return nil
+	}
+	return wire
+}
+
+func signResponse(name enc.Name, content enc.Wire, signer ndn.Signer) (enc.Wire, error) {
+	wire, _, err := spec_2022.Spec{}.MakeData(
+		name,
+		&ndn.DataConfig{
+			ContentType: utils.IdPtr(ndn.ContentTypeBlob),
+			Freshness:   utils.IdPtr(responseFreshnessPeriod),
+		},
+		content,
+		signer,
+	)
+	return wire, err
 }
@@ -8,17 +8,75 @@ import (
 	"github.com/zjkmxy/go-ndn/pkg/ndn/spec_2022"
 	"github.com/zjkmxy/go-ndn/pkg/security"
 	"github.com/zjkmxy/go-ndn/pkg/utils"
+	"ndn/ndncert/challenge/client"
 	"ndn/ndncert/challenge/crypto"
+	"ndn/ndncert/challenge/email"
+	"ndn/ndncert/challenge/keychain"
 	"ndn/ndncert/challenge/schemaold"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
 )
 
-func TestOnNew(t *testing.T) {
+func setupCaKeychain(t *testing.T) *schemaold.CaProfile {
+	caPrefix, _ := enc.NameFromStr(caName)
+	kc, err := keychain.Open(t.TempDir(), caPrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = kc.ScheduleRollover(keychain.KeyTypeEcdsa, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	SetKeychain(kc)
+	profile, err := Profile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return profile
+}
+
+var sentCodePattern = regexp.MustCompile(`secret code is: ([^\s<]+)`)
+
+// sentCode returns the secret code of the last email delivered through mailer.
+func sentCode(t *testing.T, mailer *email.MemoryMailer) string {
+	messages := mailer.Messages()
+	if len(messages) == 0 {
+		t.Fatal("failed to send the challenge email")
+	}
+	match := sentCodePattern.FindSubmatch(messages[len(messages)-1].Data)
+	if match == nil {
+		t.Fatalf("failed to find the secret code in %q", messages[len(messages)-1].Data)
+	}
+	return string(match[1])
+}
 
-	ecdhState := crypto.ECDHState{}
-	ecdhState.GenerateKeyPair()
+func profileSuite(t *testing.T, profile *schemaold.CaProfile) crypto.Suite {
+	if len(profile.CryptoSuite) == 0 {
+		return crypto.DefaultSuite
+	}
+	suite, err := crypto.ParseSuite(profile.CryptoSuite[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return suite
+}
 
-	name, err := enc.NameFromStr("/ndn/user/name/KEY/1/version/4")
+func TestOnNew(t *testing.T) {
+	profile := setupCaKeychain(t)
+	mailer := &email.MemoryMailer{From: "ca@ndn.example"}
+	SetMailer(mailer)
+	defer SetMailer(nil)
+	suite := profileSuite(t, profile)
+
+	ecdhState := crypto.ECDHState{Curve: suite.Curve}
+	if err := ecdhState.GenerateKeyPair(); err != nil {
+		t.Fatal(err)
+	}
+
+	name, err := enc.NameFromStr("/ndn/edu/ucla/alice/KEY/1/version/4")
 	if err != nil {
 		print(err.Error())
 	}
@@ -53,20 +111,27 @@ func TestOnNew(t *testing.T) {
 		ApplicationParameters: appParamsWire,
 	}
 
-	dp := OnNew(i)
+	dp, err := client.ValidateData(profile, OnNew(i))
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	dataBuff := dp.Content().Join()
 	dataBuffWireReader := enc.NewBufferReader(dataBuff)
 	cmdNewData, err := schemaold.ParseCmdNewData(dataBuffWireReader, true)
 
-	ecdhState.SetRemotePublicKey(cmdNewData.EcdhPub)
-	sharedSecret := ecdhState.GetSharedSecret()
+	var requestIdFixed [8]byte
+	copy(requestIdFixed[:], cmdNewData.ReqId)
 
-	symmetricKey := crypto.HKDF(sharedSecret, cmdNewData.Salt)
+	session, err := crypto.NewSession(suite, &ecdhState, cmdNewData.EcdhPub, cmdNewData.Salt, requestIdFixed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
 
 	challengeParams := []*schemaold.Param{{
 		ParamKey:   "email",
-		ParamValue: []byte("tanmaya2000@hotmail.com"),
+		ParamValue: []byte("alice@ucla.edu"),
 	}}
 
 	challengeIntPlaintext := schemaold.ChallengeIntPlain{
@@ -74,19 +139,11 @@ func TestOnNew(t *testing.T) {
 		Params:       challengeParams,
 	}
 
-	var symmetricKeyFixed [16]byte
-	var requestIdFixed [8]byte
-
-	copy(symmetricKeyFixed[:], symmetricKey)
-	copy(requestIdFixed[:], cmdNewData.ReqId)
-
 	challengeIntPlaintextBytes := challengeIntPlaintext.Encode().Join()
 
-	challengeIntEncryptedMessage := crypto.EncryptPayload(symmetricKeyFixed, challengeIntPlaintextBytes, requestIdFixed)
-	cipherMsgInt := schemaold.CipherMsg{
-		InitVec:  challengeIntEncryptedMessage.InitializationVector[:],
-		AuthNTag: challengeIntEncryptedMessage.AuthenticationTag[:],
-		Payload:  challengeIntEncryptedMessage.EncryptedPayload,
+	cipherMsgInt, err := session.Seal(challengeIntPlaintextBytes)
+	if err != nil {
+		t.Fatal(err)
 	}
 
 	cipherMsgWire := cipherMsgInt.Encode()
@@ -106,22 +163,18 @@ func TestOnNew(t *testing.T) {
 		ApplicationParameters: cipherMsgInt.Encode(),
 	}
 
-	dpchal := OnChallenge(ichal)
+	dpchal, err := client.ValidateData(profile, OnChallenge(ichal))
+	if err != nil {
+		t.Fatal(err)
+	}
 	dataBuff = dpchal.Content().Join()
 	dataBuffWireReader = enc.NewBufferReader(dataBuff)
 
 	cipherMsg, _ := schemaold.ParseCipherMsg(dataBuffWireReader, true)
-	var cipherMsgInitVecFixed [crypto.NonceSizeBytes]byte
-	var cipherMsgAuthNTagFixed [crypto.TagSizeBytes]byte
-
-	copy(cipherMsgInitVecFixed[:], cipherMsg.InitVec)
-	copy(cipherMsgAuthNTagFixed[:], cipherMsg.AuthNTag)
-
-	plainText := crypto.DecryptPayload(symmetricKeyFixed, crypto.EncryptedMessage{
-		InitializationVector: cipherMsgInitVecFixed,
-		AuthenticationTag:    cipherMsgAuthNTagFixed,
-		EncryptedPayload:     cipherMsg.Payload,
-	}, requestIdFixed)
+	plainText, err := session.Open(cipherMsg)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	cmdChalPlainReader := enc.NewBufferReader(plainText)
 	cmdChalPlain, _ := schemaold.ParseChallengeDataPlain(cmdChalPlainReader, true)
@@ -131,13 +184,13 @@ func TestOnNew(t *testing.T) {
 	t.Logf("Remaining Time: %d", *cmdChalPlain.RemainTime)
 	t.Logf("Remaining Tries: %d", *cmdChalPlain.RemainTries)
 
-	//fmt.Println("Please enter the secret code sent to your email: ")
-	//var userCode string
-	//fmt.Scanln(&userCode)
+	if to := mailer.Messages()[0].To; len(to) != 1 || to[0] != "alice@ucla.edu" {
+		t.Errorf("failed to send the challenge email to the requester, got %v", to)
+	}
 
 	codeParams := []*schemaold.Param{{
 		ParamKey:   "code",
-		ParamValue: []byte("123456"),
+		ParamValue: []byte(sentCode(t, mailer)),
 	}}
 
 	codeIntPlaintext := schemaold.ChallengeIntPlain{
@@ -147,11 +200,9 @@ func TestOnNew(t *testing.T) {
 
 	codeIntPlaintextBytes := codeIntPlaintext.Encode().Join()
 
-	codeIntEncryptedMessage := crypto.EncryptPayload(symmetricKeyFixed, codeIntPlaintextBytes, requestIdFixed)
-	codeMsgInt := schemaold.CipherMsg{
-		InitVec:  codeIntEncryptedMessage.InitializationVector[:],
-		AuthNTag: codeIntEncryptedMessage.AuthenticationTag[:],
-		Payload:  codeIntEncryptedMessage.EncryptedPayload,
+	codeMsgInt, err := session.Seal(codeIntPlaintextBytes)
+	if err != nil {
+		t.Fatal(err)
 	}
 
 	codeMsgIntWire := codeMsgInt.Encode()
@@ -172,31 +223,371 @@ func TestOnNew(t *testing.T) {
 		ApplicationParameters: codeMsgInt.Encode(),
 	}
 
-	dpcode := OnChallenge(icode)
+	dpcode, err := client.ValidateData(profile, OnChallenge(icode))
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	dataBuff = dpcode.Content().Join()
 	dataBuffWireReader = enc.NewBufferReader(dataBuff)
 
 	cipherMsg, _ = schemaold.ParseCipherMsg(dataBuffWireReader, true)
-
-	copy(cipherMsgInitVecFixed[:], cipherMsg.InitVec)
-	copy(cipherMsgAuthNTagFixed[:], cipherMsg.AuthNTag)
-
-	plainText = crypto.DecryptPayload(symmetricKeyFixed, crypto.EncryptedMessage{
-		InitializationVector: cipherMsgInitVecFixed,
-		AuthenticationTag:    cipherMsgAuthNTagFixed,
-		EncryptedPayload:     cipherMsg.Payload,
-	}, requestIdFixed)
+	plainText, err = session.Open(cipherMsg)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	cmdCodePlainReader := enc.NewBufferReader(plainText)
 	cmdCodePlain, _ := schemaold.ParseChallengeDataPlain(cmdCodePlainReader, true)
 
-	t.Logf("Request Status: %d", cmdCodePlain.Status)
-	t.Logf("Challenge Status: %d", *cmdCodePlain.ChalStatus)
-	if uint64(Success) == cmdCodePlain.Status {
-		t.Logf("Issued CertName: %s", cmdCodePlain.CertName.String())
-	} else {
-		t.Logf("Remaining Time: %d", *cmdCodePlain.RemainTime)
-		t.Logf("Remaining Tries: %d", *cmdCodePlain.RemainTries)
+	if uint64(Success) != cmdCodePlain.Status {
+		t.Fatalf("failed to issue a certificate for the emailed code, got status %d", cmdCodePlain.Status)
+	}
+	if GetIssuedCertificate(cmdCodePlain.CertName) == nil {
+		t.Errorf("failed to store the issued certificate %s", cmdCodePlain.CertName)
+	}
+
+	dpreplay, err := client.ValidateData(profile, OnChallenge(icode))
+	if err != nil {
+		t.Fatal(err)
+	}
+	errorMsg, err := schemaold.ParseErrorMsg(enc.NewWireReader(dpreplay.Content()), true)
+	if err != nil || errorMsg.ErrorCode != uint64(ErrorInvalidParameters) {
+		t.Error("failed to reject a replayed CHALLENGE Interest")
+	}
+}
+
+func TestOnNewInvalidEcdhPoint(t *testing.T) {
+	profile := setupCaKeychain(t)
+
+	appParams := schemaold.CmdNewInt{
+		EcdhPub: []byte("not a point on P-256"),
+		CertReq: makeCertRequestWire(t, "/ndn/user/name/KEY/1/self/1").Join(),
+	}
+	appParamsWire := appParams.Encode()
+	digest := sha256.Sum256(appParamsWire.Join())
+
+	i := &spec_2022.Interest{
+		NameV:                 mustName(t, fmt.Sprintf("/ndn/CA/NEW/params-sha256=%x", digest)),
+		MustBeFreshV:          true,
+		ApplicationParameters: appParamsWire,
+	}
+
+	data, err := client.ValidateData(profile, OnNew(i))
+	if err != nil {
+		t.Fatal(err)
+	}
+	errorMsg, err := schemaold.ParseErrorMsg(enc.NewWireReader(data.Content()), true)
+	if err != nil || errorMsg.ErrorCode != uint64(ErrorBadParameterFormat) {
+		t.Error("failed to answer an invalid ECDH public key with BadParameterFormat")
+	}
+}
+
+func TestOnNewCryptoSuite(t *testing.T) {
+	if err := SetCryptoSuite(crypto.Suite{Curve: "P-521", Cipher: crypto.CipherAes128Gcm}); err == nil {
+		t.Error("failed to refuse an unsupported curve")
+	}
+	if err := SetCryptoSuite(crypto.Suite{Curve: crypto.CurveX25519, Cipher: crypto.CipherChaCha20Poly1305}); err != nil {
+		t.Fatal(err)
+	}
+	defer SetCryptoSuite(crypto.DefaultSuite)
+	if err := SetKeySchedule(crypto.KeyScheduleDirectional); err != nil {
+		t.Fatal(err)
+	}
+	defer SetKeySchedule(crypto.KeyScheduleCompat)
+
+	profile := setupCaKeychain(t)
+	suite := profileSuite(t, profile)
+	if suite.Curve != crypto.CurveX25519 || suite.Cipher != crypto.CipherChaCha20Poly1305 {
+		t.Fatalf("failed to advertise the configured suite, got %v", profile.CryptoSuite)
+	}
+	if profile.KeySchedule == nil || crypto.KeySchedule(*profile.KeySchedule) != crypto.KeyScheduleDirectional {
+		t.Fatal("failed to advertise the configured key schedule")
+	}
+
+	ecdhState := crypto.ECDHState{Curve: suite.Curve}
+	if err := ecdhState.GenerateKeyPair(); err != nil {
+		t.Fatal(err)
+	}
+	appParams := schemaold.CmdNewInt{
+		EcdhPub: ecdhState.PublicKey.Bytes(),
+		CertReq: makeCertRequestWire(t, "/ndn/user/name/KEY/1/self/1").Join(),
+	}
+	appParamsWire := appParams.Encode()
+	digest := sha256.Sum256(appParamsWire.Join())
+
+	data, err := client.ValidateData(profile, OnNew(&spec_2022.Interest{
+		NameV:                 mustName(t, fmt.Sprintf("/ndn/CA/NEW/params-sha256=%x", digest)),
+		MustBeFreshV:          true,
+		ApplicationParameters: appParamsWire,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmdNewData, err := schemaold.ParseCmdNewData(enc.NewWireReader(data.Content()), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var requestId [8]byte
+	copy(requestId[:], cmdNewData.ReqId)
+	caPrefix := mustName(t, caName)
+	session, err := crypto.NewSessionWithSchedule(suite, crypto.KeyScheduleDirectional, crypto.RoleRequester,
+		&ecdhState, cmdNewData.EcdhPub, cmdNewData.Salt, crypto.KeyContext{RequestId: requestId, CaPrefix: caPrefix})
+	if err != nil {
+		t.Fatalf("failed to agree on a key with the CA: %s", err)
+	}
+	defer session.Close()
+
+	caSession := storage[requestId].session
+	if caSession.Suite() != suite {
+		t.Errorf("failed to use the configured suite for the request, got %s", caSession.Suite())
+	}
+	cipherMsg, err := caSession.Seal([]byte("ping"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plaintext, err := session.Open(cipherMsg); err != nil || string(plaintext) != "ping" {
+		t.Errorf("failed to share the session key with the CA, got %q, %v", plaintext, err)
+	}
+	cipherMsg, err = session.Seal([]byte("pong"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plaintext, err := caSession.Open(cipherMsg); err != nil || string(plaintext) != "pong" {
+		t.Errorf("failed to open a message sealed for the CA, got %q, %v", plaintext, err)
+	}
+}
+
+// newRequest sends a NEW Interest for certName and returns the request ID and the requester's session.
+func newRequest(t *testing.T, profile *schemaold.CaProfile, certName string) ([8]byte, *crypto.Session) {
+	suite := profileSuite(t, profile)
+	ecdhState := crypto.ECDHState{Curve: suite.Curve}
+	if err := ecdhState.GenerateKeyPair(); err != nil {
+		t.Fatal(err)
+	}
+	appParams := schemaold.CmdNewInt{
+		EcdhPub: ecdhState.PublicKey.Bytes(),
+		CertReq: makeCertRequestWire(t, certName).Join(),
+	}
+	appParamsWire := appParams.Encode()
+	digest := sha256.Sum256(appParamsWire.Join())
+
+	data, err := client.ValidateData(profile, OnNew(&spec_2022.Interest{
+		NameV:                 mustName(t, fmt.Sprintf("/ndn/CA/NEW/params-sha256=%x", digest)),
+		MustBeFreshV:          true,
+		ApplicationParameters: appParamsWire,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmdNewData, err := schemaold.ParseCmdNewData(enc.NewWireReader(data.Content()), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var requestId [8]byte
+	copy(requestId[:], cmdNewData.ReqId)
+	session, err := crypto.NewSession(suite, &ecdhState, cmdNewData.EcdhPub, cmdNewData.Salt, requestId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(session.Close)
+	t.Cleanup(func() { delete(storage, requestId) })
+	return requestId, session
+}
+
+// sendChallenge sends a CHALLENGE Interest for the email challenge with params, and returns
+// either the decrypted response or the error the CA answered with.
+func sendChallenge(t *testing.T, profile *schemaold.CaProfile, requestId [8]byte, session *crypto.Session,
+	params map[string]string) (*schemaold.ChallengeDataPlain, *schemaold.ErrorMsg) {
+	return sendSelectedChallenge(t, profile, requestId, session, "email", params)
+}
+
+func sendSelectedChallenge(t *testing.T, profile *schemaold.CaProfile, requestId [8]byte, session *crypto.Session,
+	selected string, params map[string]string) (*schemaold.ChallengeDataPlain, *schemaold.ErrorMsg) {
+	plaintext := schemaold.ChallengeIntPlain{SelectedChal: selected}
+	for key, value := range params {
+		plaintext.Params = append(plaintext.Params, &schemaold.Param{ParamKey: key, ParamValue: []byte(value)})
+	}
+	cipherMsg, err := session.Seal(plaintext.Encode().Join())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cipherMsgWire := cipherMsg.Encode()
+	digest := sha256.Sum256(cipherMsgWire.Join())
+
+	data, err := client.ValidateData(profile, OnChallenge(&spec_2022.Interest{
+		NameV:                 mustName(t, fmt.Sprintf("/ndn/CA/CHALLENGE/%s/params-sha256=%x", requestId[:], digest)),
+		MustBeFreshV:          true,
+		ApplicationParameters: cipherMsgWire,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if errorMsg, err := schemaold.ParseErrorMsg(enc.NewWireReader(data.Content()), true); err == nil && errorMsg.ErrorCode != 0 {
+		return nil, errorMsg
+	}
+	response, err := schemaold.ParseCipherMsg(enc.NewWireReader(data.Content()), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err := session.Open(response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chalData, err := schemaold.ParseChallengeDataPlain(enc.NewBufferReader(decrypted), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return chalData, nil
+}
+
+func TestOnChallengeTerminalStates(t *testing.T) {
+	profile := setupCaKeychain(t)
+	mailer := &email.MemoryMailer{From: "ca@ndn.example"}
+	SetMailer(mailer)
+	defer SetMailer(nil)
+
+	for _, test := range []struct {
+		name     string
+		address  string
+		codes    []string
+		expire   bool
+		expected ErrorCode
+	}{
+		{"tries exhausted", "tries@ucla.edu", []string{"wrong", "wrong", "wrong"}, false, ErrorRunOutOfTries},
+		{"expired", "expired@ucla.edu", []string{""}, true, ErrorRunOutOfTime},
+	} {
+		requestId, session := newRequest(t, profile, "/ndn/user/"+strings.Split(test.address, "@")[0]+"/KEY/1/self/1")
+		if _, errorMsg := sendChallenge(t, profile, requestId, session, map[string]string{emailParamKey: test.address}); errorMsg != nil {
+			t.Fatal(errorMsg.ErrorInfo)
+		}
+		code := sentCode(t, mailer)
+		if test.expire {
+			storage[requestId].ChallengeState.Expiry = time.Now().Add(-time.Second)
+		}
+
+		var errorMsg *schemaold.ErrorMsg
+		for _, attempt := range test.codes {
+			if attempt == "" {
+				attempt = code
+			}
+			_, errorMsg = sendChallenge(t, profile, requestId, session, map[string]string{codeParamKey: attempt})
+		}
+		if errorMsg == nil || errorMsg.ErrorCode != uint64(test.expected) {
+			t.Errorf("%s: failed to fail the request with error %d, got %+v", test.name, test.expected, errorMsg)
+		}
+		if _, ok := storage[requestId]; ok {
+			t.Errorf("%s: failed to forget the failed request", test.name)
+		}
+	}
+
+	requestId, session := newRequest(t, profile, "/ndn/user/selected/KEY/1/self/1")
+	_, errorMsg := sendSelectedChallenge(t, profile, requestId, session, "pin", map[string]string{emailParamKey: "selected@ucla.edu"})
+	if errorMsg == nil || errorMsg.ErrorCode != uint64(ErrorInvalidParameters) {
+		t.Errorf("failed to refuse an unsupported challenge, got %+v", errorMsg)
+	}
+	if storage[requestId].status != CaModuleBeforeChallenge {
+		t.Error("failed to leave the request waiting for a supported challenge")
+	}
+	if _, errorMsg := sendChallenge(t, profile, requestId, session, map[string]string{emailParamKey: "selected@ucla.edu"}); errorMsg != nil {
+		t.Fatal(errorMsg.ErrorInfo)
+	}
+	_, errorMsg = sendSelectedChallenge(t, profile, requestId, session, "pin", map[string]string{codeParamKey: sentCode(t, mailer)})
+	if errorMsg == nil || errorMsg.ErrorCode != uint64(ErrorInvalidParameters) {
+		t.Errorf("failed to refuse switching challenges mid-way, got %+v", errorMsg)
+	}
+}
+
+func TestSetPrefixAndChallenges(t *testing.T) {
+	for _, prefix := range []string{"", "/"} {
+		if err := SetPrefix(prefix); err == nil {
+			t.Errorf("failed to reject the CA prefix %q", prefix)
+		}
+	}
+	if caName != "/ndn" {
+		t.Errorf("failed to keep the CA prefix after an invalid one, got %s", caName)
+	}
+
+	for _, challenges := range [][]string{nil, {"email", "pin"}} {
+		if err := SetChallenges(challenges); err == nil {
+			t.Errorf("failed to reject the challenges %v", challenges)
+		}
+	}
+	if err := SetChallenges([]string{"email"}); err != nil || !isChallengeAvailable("email") {
+		t.Errorf("failed to enable the email challenge, got %v", err)
+	}
+}
+
+func TestResponseWithoutCaKey(t *testing.T) {
+	kc, err := keychain.Open(t.TempDir(), mustName(t, caName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kc.ScheduleRollover(keychain.KeyTypeEcdsa, time.Now().Add(-2*time.Hour), time.Now().Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	SetKeychain(kc)
+	defer SetKeychain(nil)
+
+	probeInt := schemaold.ProbeInt{Params: []*schemaold.Param{{ParamKey: emailParamKey, ParamValue: []byte("alice@ucla.edu")}}}
+	wire := OnProbe(&spec_2022.Interest{
+		NameV:                 mustName(t, "/ndn/CA/PROBE"),
+		MustBeFreshV:          true,
+		ApplicationParameters: probeInt.Encode(),
+	})
+	if wire == nil {
+		t.Fatal("failed to answer the requester when the CA certificate has expired")
+	}
+	data, _, err := spec_2022.Spec{}.ReadData(enc.NewWireReader(wire))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.Signature().SigType() != ndn.SignatureDigestSha256 {
+		t.Errorf("failed to leave the response without a CA signature, got %d", data.Signature().SigType())
+	}
+	errorMsg, err := schemaold.ParseErrorMsg(enc.NewWireReader(data.Content()), true)
+	if err != nil || errorMsg.ErrorCode != uint64(ErrorCaUnavailable) {
+		t.Errorf("failed to report that the CA cannot sign, got %+v, %v", errorMsg, err)
+	}
+}
+
+func TestSweepRequests(t *testing.T) {
+	profile := setupCaKeychain(t)
+	mailer := &email.MemoryMailer{From: "ca@ndn.example"}
+	SetMailer(mailer)
+	defer SetMailer(nil)
+	SetRateLimits(RateLimits{})
+	defer SetRateLimits(DefaultRateLimits)
+
+	abandoned, _ := newRequest(t, profile, "/ndn/edu/ucla/alice/KEY/1/self/1")
+	challenged, session := newRequest(t, profile, "/ndn/edu/ucla/bob/KEY/1/self/1")
+	if _, errorMsg := sendChallenge(t, profile, challenged, session, map[string]string{emailParamKey: "bob@ucla.edu"}); errorMsg != nil {
+		t.Fatal(errorMsg.ErrorInfo)
+	}
+	storage[challenged].ChallengeState.Expiry = time.Now().Add(time.Hour)
+
+	now := time.Now().Add(requestRetention + time.Second)
+	nextRequestSweep = now.Add(time.Second)
+	sweepRequests(now)
+	if _, ok := storage[abandoned]; !ok {
+		t.Fatal("failed to wait for the sweep interval before sweeping again")
+	}
+
+	nextRequestSweep = time.Time{}
+	sweepRequests(now)
+	if _, ok := storage[abandoned]; ok {
+		t.Error("failed to drop a request that never started its challenge")
+	}
+	if _, ok := storage[challenged]; !ok {
+		t.Error("failed to keep a request whose challenge has not expired")
+	}
+
+	nextRequestSweep = time.Time{}
+	sweepRequests(time.Now().Add(time.Hour + requestRetention + time.Second))
+	if _, ok := storage[challenged]; ok {
+		t.Error("failed to drop a request after its challenge expired")
 	}
 }
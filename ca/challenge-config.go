@@ -0,0 +1,105 @@
+package ca
+
+import (
+	"fmt"
+	enc "github.com/zjkmxy/go-ndn/pkg/encoding"
+	"time"
+)
+
+// ChallengeConfig sets how an email challenge tests the requester.
+type ChallengeConfig struct {
+	// MaxAttempts is the number of codes a requester may try.
+	MaxAttempts uint
+	// Lifetime is how long a code can be used after it is sent.
+	Lifetime time.Duration
+	// Code draws the secret codes, and its pattern is the only form of code an email may carry.
+	Code SecretCodeGenerator
+}
+
+// ChallengeOverride changes the challenge for identities under Prefix; the longest matching
+// prefix wins. Zero fields keep the CA-wide value, and a Code without an Alphabet only
+// changes the length of the codes.
+type ChallengeOverride struct {
+	Prefix      enc.Name
+	MaxAttempts uint
+	Lifetime    time.Duration
+	Code        SecretCodeGenerator
+}
+
+var DefaultChallengeConfig = ChallengeConfig{
+	MaxAttempts: 3,
+	Lifetime:    5 * time.Minute,
+	Code:        defaultSecretCodeGenerator,
+}
+
+var challengeConfig = DefaultChallengeConfig
+var challengeOverrides []ChallengeOverride
+
+// SetChallengeConfig changes the challenge of new requests, after checking the CA-wide
+// config and the config every override results in. Challenges already started keep theirs.
+func SetChallengeConfig(config ChallengeConfig, overrides []ChallengeOverride) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	seen := make(map[string]bool, len(overrides))
+	for _, override := range overrides {
+		prefix := override.Prefix.String()
+		if seen[prefix] {
+			return fmt.Errorf("duplicate challenge override for %s", prefix)
+		}
+		seen[prefix] = true
+		if err := config.WithOverride(override).Validate(); err != nil {
+			return fmt.Errorf("challenge override for %s: %w", prefix, err)
+		}
+	}
+	challengeConfig = config
+	challengeOverrides = overrides
+	return nil
+}
+
+// Validate checks that the challenge can be passed, and that RemainTime can report its lifetime.
+func (c ChallengeConfig) Validate() error {
+	if c.MaxAttempts < 1 {
+		return fmt.Errorf("challenge must allow at least one attempt")
+	}
+	if c.Lifetime < time.Second {
+		return fmt.Errorf("challenge lifetime must be at least a second, got %s", c.Lifetime)
+	}
+	if err := c.Code.Validate(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// WithOverride returns c as changed by override for the identities under its prefix.
+func (c ChallengeConfig) WithOverride(override ChallengeOverride) ChallengeConfig {
+	if override.MaxAttempts != 0 {
+		c.MaxAttempts = override.MaxAttempts
+	}
+	if override.Lifetime != 0 {
+		c.Lifetime = override.Lifetime
+	}
+	if override.Code.Alphabet != nil {
+		c.Code.Alphabet = override.Code.Alphabet
+		c.Code.Separator = override.Code.Separator
+	}
+	if override.Code.Length != 0 {
+		c.Code.Length = override.Code.Length
+	}
+	return c
+}
+
+// challengeConfigFor returns the challenge config of identity.
+func challengeConfigFor(identity enc.Name) ChallengeConfig {
+	var match *ChallengeOverride
+	for i := range challengeOverrides {
+		override := &challengeOverrides[i]
+		if override.Prefix.IsPrefix(identity) && (match == nil || len(override.Prefix) > len(match.Prefix)) {
+			match = override
+		}
+	}
+	if match == nil {
+		return challengeConfig
+	}
+	return challengeConfig.WithOverride(*match)
+}
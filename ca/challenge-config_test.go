@@ -0,0 +1,83 @@
+package ca
+
+import (
+	"ndn/ndncert/challenge/email"
+	"testing"
+	"time"
+)
+
+func TestSetChallengeConfig(t *testing.T) {
+	for _, config := range []ChallengeConfig{
+		{MaxAttempts: 0, Lifetime: time.Minute, Code: defaultSecretCodeGenerator},
+		{MaxAttempts: 3, Lifetime: time.Millisecond, Code: defaultSecretCodeGenerator},
+		{MaxAttempts: 3, Lifetime: time.Minute, Code: SecretCodeGenerator{Alphabet: DigitsAlphabet}},
+	} {
+		if err := SetChallengeConfig(config, nil); err == nil {
+			t.Errorf("failed to reject the challenge config %+v", config)
+		}
+	}
+	for _, overrides := range [][]ChallengeOverride{
+		{{Prefix: mustName(t, "/ndn/guest"), Code: SecretCodeGenerator{Alphabet: []string{"a"}}}},
+		{{Prefix: mustName(t, "/ndn/guest"), MaxAttempts: 1}, {Prefix: mustName(t, "/ndn/guest"), MaxAttempts: 2}},
+	} {
+		if err := SetChallengeConfig(DefaultChallengeConfig, overrides); err == nil {
+			t.Errorf("failed to reject the challenge overrides %+v", overrides)
+		}
+	}
+	if challengeConfig.MaxAttempts != DefaultChallengeConfig.MaxAttempts || challengeOverrides != nil {
+		t.Error("failed to keep the previous challenge config after an invalid one")
+	}
+
+	if err := SetChallengeConfig(DefaultChallengeConfig, []ChallengeOverride{
+		{Prefix: mustName(t, "/ndn/guest"), MaxAttempts: 1, Lifetime: time.Minute},
+		{Prefix: mustName(t, "/ndn/guest/lab"), Code: SecretCodeGenerator{Alphabet: AlphanumericAlphabet, Length: 10}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	defer SetChallengeConfig(DefaultChallengeConfig, nil)
+
+	if config := challengeConfigFor(mustName(t, "/ndn/user/alice")); config.MaxAttempts != 3 || config.Code.Length != 6 {
+		t.Errorf("failed to use the CA-wide config outside the overrides, got %+v", config)
+	}
+	if config := challengeConfigFor(mustName(t, "/ndn/guest/alice")); config.MaxAttempts != 1 || config.Lifetime != time.Minute {
+		t.Errorf("failed to apply the override, got %+v", config)
+	}
+	config := challengeConfigFor(mustName(t, "/ndn/guest/lab/alice"))
+	if config.MaxAttempts != 3 || config.Code.Length != 10 || config.Code.Pattern().MatchString("123456") {
+		t.Errorf("failed to apply the longest override alone, got %+v", config)
+	}
+}
+
+func TestChallengeConfigOverride(t *testing.T) {
+	profile := setupCaKeychain(t)
+	mailer := &email.MemoryMailer{From: "ca@ndn.example"}
+	SetMailer(mailer)
+	defer SetMailer(nil)
+	if err := SetChallengeConfig(DefaultChallengeConfig, []ChallengeOverride{{
+		Prefix:      mustName(t, "/ndn/guest"),
+		MaxAttempts: 1,
+		Lifetime:    time.Minute,
+		Code:        SecretCodeGenerator{Length: 8},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	defer SetChallengeConfig(DefaultChallengeConfig, nil)
+
+	requestId, session := newRequest(t, profile, "/ndn/guest/config/KEY/1/self/1")
+	chalData, errorMsg := sendChallenge(t, profile, requestId, session, map[string]string{emailParamKey: "config@ucla.edu"})
+	if errorMsg != nil {
+		t.Fatal(errorMsg.ErrorInfo)
+	}
+	if *chalData.RemainTries != 1 || *chalData.RemainTime > 60 || *chalData.RemainTime < 55 {
+		t.Errorf("failed to report the overridden tries and lifetime, got %d tries and %d seconds",
+			*chalData.RemainTries, *chalData.RemainTime)
+	}
+	if code := sentCode(t, mailer); len(code) != 8 {
+		t.Errorf("failed to send a code of the overridden length, got %q", code)
+	}
+
+	_, errorMsg = sendChallenge(t, profile, requestId, session, map[string]string{codeParamKey: "00000000"})
+	if errorMsg == nil || errorMsg.ErrorCode != uint64(ErrorRunOutOfTries) {
+		t.Errorf("failed to end the challenge after the only attempt, got %+v", errorMsg)
+	}
+}
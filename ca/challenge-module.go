@@ -1,19 +1,36 @@
 package ca
 
 import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
 	"fmt"
-	"math/rand"
 	"ndn/ndncert/challenge/email"
+	"ndn/ndncert/challenge/schemaold"
+	"strconv"
+	"sync"
 	"time"
 )
 
-var maxAttempts uint = 3
+var challengeMailer email.Mailer
+var emailTemplates = email.DefaultTemplates()
+var addressPolicy = email.DefaultAddressPolicy
+var resendPolicy = DefaultResendPolicy
 
 const (
-	secretLifetime int64 = 300 // in seconds
-	secretLength   int   = 6
+	deliveryStatusParamKey   = "delivery-status"
+	remainingResendsParamKey = "remaining-resends"
 )
 
+var (
+	ErrNoResendsLeft = errors.New("no resends left")
+	ErrResendTooSoon = errors.New("code was resent too recently")
+)
+
+const secretSaltLength int = 32
+
 type ChallengeStatus int
 
 const (
@@ -24,85 +41,433 @@ const (
 	ChallengeModuleSuccess
 )
 
+var (
+	ErrChallengeNotPending = errors.New("challenge is not waiting for a code")
+	ErrChallengeExpired    = errors.New("challenge expired")
+	ErrWrongCode           = errors.New("incorrect secret code")
+	ErrNoTriesLeft         = errors.New("incorrect secret code, no tries left")
+	ErrInvalidTransition   = errors.New("invalid challenge transition")
+	ErrNoMailer            = errors.New("no mailer is configured for challenge emails")
+)
+
+// challengeTransitions lists the statuses each status may move to. Failure and Success have
+// none: once a challenge reaches them, it never changes again.
+var challengeTransitions = map[ChallengeStatus][]ChallengeStatus{
+	ChallengeModuleBeforeEmail: {ChallengeModuleNeedCode, ChallengeModuleFailure},
+	ChallengeModuleNeedCode:    {ChallengeModuleNeedCode, ChallengeModuleWrongCode, ChallengeModuleFailure, ChallengeModuleSuccess},
+	ChallengeModuleWrongCode:   {ChallengeModuleNeedCode, ChallengeModuleWrongCode, ChallengeModuleFailure, ChallengeModuleSuccess},
+}
+
+func (s ChallengeStatus) String() string {
+	switch s {
+	case ChallengeModuleBeforeEmail:
+		return "before-email"
+	case ChallengeModuleNeedCode:
+		return "need-code"
+	case ChallengeModuleWrongCode:
+		return "wrong-code"
+	case ChallengeModuleFailure:
+		return "failure"
+	case ChallengeModuleSuccess:
+		return "success"
+	default:
+		return fmt.Sprintf("ChallengeStatus(%d)", int(s))
+	}
+}
+
+// IsTerminal reports whether the challenge is over, successfully or not.
+func (s ChallengeStatus) IsTerminal() bool {
+	return s == ChallengeModuleFailure || s == ChallengeModuleSuccess
+}
+
+// isPending reports whether the challenge is waiting for a code.
+func (s ChallengeStatus) isPending() bool {
+	return s == ChallengeModuleNeedCode || s == ChallengeModuleWrongCode
+}
+
+// ResendPolicy bounds how often a requester may ask for a new code within one challenge.
+type ResendPolicy struct {
+	// MaxResends is the resend budget of a challenge.
+	MaxResends uint
+	// MinInterval is the time to wait after an email before another can be requested.
+	MinInterval time.Duration
+	// ExtendExpiry gives the new code a full lifetime, rather than the time left to the first.
+	ExtendExpiry bool
+}
+
+var DefaultResendPolicy = ResendPolicy{MaxResends: 3, MinInterval: 30 * time.Second, ExtendExpiry: true}
+
 type ChallengeState struct {
 	RemainingAttempts uint
 	Expiry            time.Time
 	Status            ChallengeStatus
+	// FailureReason is why the challenge moved to ChallengeModuleFailure.
+	FailureReason error
 }
 
+// EmailChallengeState keeps only a salted HMAC-SHA256 of the secret code, so neither memory
+// nor a persisted copy of the state reveals the code that was emailed.
 type EmailChallengeState struct {
-	EmailChallenge
 	ChallengeState
 	Email      string
-	SecretCode string
+	CertName   string
+	Locale     string
+	DeliveryId string
+	SecretHash []byte
+	SecretSalt []byte
+	LinkHash   []byte
+	// Config is the challenge config of the requested identity, fixed when the challenge starts.
+	Config ChallengeConfig
+	// RemainingResends and LastSent limit the codes sent after the first one.
+	RemainingResends uint
+	LastSent         time.Time
+	mutex            sync.Mutex
+	delivery         email.TrackedMailer
 }
 
+// EmailChallenge is the part of EmailChallengeState that OnChallenge drives.
 type EmailChallenge interface {
-	generateSecretCode() string
-	InitiateChallenge(email string) error
-	CheckCode(secret uint) (bool, error)
-	//HandleChallengeRequest() (string, )
-	GetChallengeStatus() int
-	sendEmail()
+	InitiateChallenge() error
+	CheckCode(secret string) (ChallengeStatus, error)
+	Resend() (ChallengeStatus, error)
+	ConfirmLink(token string) (ChallengeStatus, error)
+	Poll() ChallengeStatus
+	VerifiedIdentity() map[string]string
 }
 
+var _ EmailChallenge = (*EmailChallengeState)(nil)
+
+// InitiateChallenge emails the first code. A challenge whose email cannot be sent fails.
+// A challenge without a Config uses the CA-wide one.
 func (e *EmailChallengeState) InitiateChallenge() error {
-	if e.Status != 0 {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if e.Status != ChallengeModuleBeforeEmail {
 		return fmt.Errorf("Challenge Already Initiated")
 	}
 
-	e.Status = ChallengeModuleNeedCode
-	e.SecretCode = e.generateSecretCode()
-	e.RemainingAttempts = maxAttempts
-	e.Expiry = time.Now().Add(time.Second * time.Duration(secretLifetime))
-	err := e.sendEmail()
-	if err != nil {
+	if e.Config.MaxAttempts == 0 {
+		e.Config = challengeConfig
+	}
+	e.RemainingAttempts = e.Config.MaxAttempts
+	e.RemainingResends = resendPolicy.MaxResends
+	if err := e.sendSecret(time.Now(), true); err != nil {
+		e.fail(err)
 		return err
 	}
+	return e.transition(ChallengeModuleNeedCode)
+}
+
+// CanResend reports why a new code cannot be sent now, if it cannot.
+func (e *EmailChallengeState) CanResend(now time.Time) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.canResendLocked(now)
+}
+
+func (e *EmailChallengeState) canResendLocked(now time.Time) error {
+	if !e.Status.isPending() {
+		return ErrChallengeNotPending
+	} else if now.After(e.Expiry) {
+		return ErrChallengeExpired
+	} else if e.RemainingResends == 0 {
+		return ErrNoResendsLeft
+	} else if wait := e.LastSent.Add(resendPolicy.MinInterval).Sub(now); wait > 0 {
+		return fmt.Errorf("%w, retry in %s", ErrResendTooSoon, wait.Round(time.Second))
+	}
 	return nil
 }
 
+// Resend replaces the secret code and its magic link with new ones and emails them, using
+// up one resend. The remaining attempts are kept, so resending cannot buy more guesses.
+// The challenge fails if the new code cannot be sent, since the old one is gone.
+func (e *EmailChallengeState) Resend() (ChallengeStatus, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	now := time.Now()
+	if err := e.canResendLocked(now); err != nil {
+		return e.Status, err
+	}
+	e.RemainingResends -= 1
+	e.eraseSecret()
+	if err := e.sendSecret(now, resendPolicy.ExtendExpiry); err != nil {
+		e.fail(err)
+		return e.Status, err
+	}
+	err := e.transition(ChallengeModuleNeedCode)
+	return e.Status, err
+}
+
+// sendSecret generates a secret code, and a magic link when they are enabled, and emails
+// them. e.mutex must be held.
+func (e *EmailChallengeState) sendSecret(now time.Time, renewExpiry bool) error {
+	secretCode, err := e.generateSecretCode()
+	if err != nil {
+		return err
+	}
+
+	e.SecretSalt = make([]byte, secretSaltLength)
+	if _, err := rand.Read(e.SecretSalt); err != nil {
+		return err
+	}
+	e.SecretHash = hashSecretCode(e.SecretSalt, secretCode)
+
+	if renewExpiry {
+		e.Expiry = now.Add(e.Config.Lifetime)
+	}
+	link, err := e.newMagicLink()
+	if err != nil {
+		return err
+	}
+	e.LastSent = now
+	return e.sendEmail(secretCode, link)
+}
+
+// CheckCode verifies secret as one atomic operation: the state, expiry and remaining
+// attempts are checked and updated under the same lock as the comparison, so concurrent
+// CHALLENGE Interests cannot try more codes than allowed. The wrong code on the last
+// attempt fails the challenge, and a challenge that is over is left as it is.
 func (e *EmailChallengeState) CheckCode(secret string) (ChallengeStatus, error) {
-	if e.Status != ChallengeModuleNeedCode && e.Status != ChallengeModuleWrongCode {
-		e.Status = ChallengeModuleFailure
-		return e.Status, fmt.Errorf("Invalid state for challenge")
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if !e.Status.isPending() {
+		return e.Status, ErrChallengeNotPending
 	} else if time.Now().After(e.Expiry) {
-		e.Status = ChallengeModuleFailure
-		return e.Status, fmt.Errorf("Challenge Expired")
-	} else if secret != e.SecretCode {
-		if e.RemainingAttempts > 1 {
-			e.Status = ChallengeModuleWrongCode
-			e.RemainingAttempts -= 1
-			return e.Status, fmt.Errorf("Incorrect Secret Code")
-		} else {
-			e.RemainingAttempts -= 1
-			return e.Status, fmt.Errorf("Incorrect Secret Code: No Tries Left")
+		e.fail(ErrChallengeExpired)
+		return e.Status, ErrChallengeExpired
+	} else if e.RemainingAttempts == 0 {
+		e.fail(ErrNoTriesLeft)
+		return e.Status, ErrNoTriesLeft
+	}
+
+	computed := hashSecretCode(e.SecretSalt, secret)
+	if subtle.ConstantTimeCompare(computed, e.SecretHash) != 1 {
+		e.RemainingAttempts -= 1
+		if e.RemainingAttempts == 0 {
+			e.fail(ErrNoTriesLeft)
+			return e.Status, ErrNoTriesLeft
 		}
-	} else {
-		e.Status = ChallengeModuleSuccess
-		return e.Status, nil
+		if err := e.transition(ChallengeModuleWrongCode); err != nil {
+			return e.Status, err
+		}
+		return e.Status, ErrWrongCode
+	}
+
+	err := e.transition(ChallengeModuleSuccess)
+	return e.Status, err
+}
+
+// Poll returns the status of the challenge to a CHALLENGE Interest without a code, which
+// may have been completed through its magic link. The challenge fails once it has expired.
+func (e *EmailChallengeState) Poll() ChallengeStatus {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.Status.isPending() && time.Now().After(e.Expiry) {
+		e.fail(ErrChallengeExpired)
+	}
+	return e.Status
+}
+
+// Snapshot returns a copy of the status, attempts and expiry of the challenge, taken under
+// its lock, for readers that do not drive the challenge.
+func (e *EmailChallengeState) Snapshot() ChallengeState {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.ChallengeState
+}
+
+// transition moves the challenge to next, if the state machine allows it. Reaching a
+// terminal status erases the secret. e.mutex must be held.
+func (e *EmailChallengeState) transition(next ChallengeStatus) error {
+	for _, allowed := range challengeTransitions[e.Status] {
+		if allowed == next {
+			e.Status = next
+			if next.IsTerminal() {
+				e.eraseSecret()
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("%w from %s to %s", ErrInvalidTransition, e.Status, next)
+}
+
+// fail ends the challenge for reason, unless it is already over. e.mutex must be held.
+func (e *EmailChallengeState) fail(reason error) {
+	if e.transition(ChallengeModuleFailure) == nil {
+		e.FailureReason = reason
+	}
+}
+
+func (e *EmailChallengeState) eraseSecret() {
+	for i := range e.SecretHash {
+		e.SecretHash[i] = 0
+	}
+	e.SecretHash = nil
+	e.SecretSalt = nil
+	e.forgetMagicLink()
+}
+
+// failureError is the error reported to the requester of a failed challenge.
+func (e *EmailChallengeState) failureError() *CaError {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	switch {
+	case errors.Is(e.FailureReason, ErrNoTriesLeft):
+		return newCaError(ErrorRunOutOfTries, "%s", e.FailureReason)
+	case errors.Is(e.FailureReason, ErrChallengeExpired):
+		return newCaError(ErrorRunOutOfTime, "%s", e.FailureReason)
+	default:
+		return newCaError(ErrorInvalidParameters, "challenge failed: %s", e.FailureReason)
+	}
+}
+
+func hashSecretCode(salt []byte, secretCode string) []byte {
+	mac := hmac.New(sha256.New, salt)
+	mac.Write([]byte(secretCode))
+	return mac.Sum(nil)
+}
+
+// VerifiedIdentity returns the identity proven by the challenge, keyed like the challenge parameters.
+func (e *EmailChallengeState) VerifiedIdentity() map[string]string {
+	return map[string]string{emailParamKey: e.Email}
+}
+
+func (e *EmailChallengeState) generateSecretCode() (string, error) {
+	return e.Config.Code.Generate()
+}
+
+// SetMailer changes how challenge emails are delivered. Applying a CA configuration sets
+// it; until then, challenges fail with ErrNoMailer.
+func SetMailer(mailer email.Mailer) {
+	challengeMailer = mailer
+}
+
+// SetEmailTemplates changes the templates challenge emails are rendered with.
+func SetEmailTemplates(templates *email.TemplateSet) {
+	emailTemplates = templates
+}
+
+// SetAddressPolicy changes which email addresses the CA challenges, and how they are
+// normalized before they are bound to an identity.
+func SetAddressPolicy(policy email.AddressPolicy) error {
+	if err := policy.Validate(); err != nil {
+		return err
 	}
+	addressPolicy = policy
+	return nil
+}
+
+// SetResendPolicy changes how often requesters may ask for a new code. Challenges already
+// started keep their resend budget.
+func SetResendPolicy(policy ResendPolicy) {
+	resendPolicy = policy
 }
 
-func (e *EmailChallengeState) generateSecretCode() string {
+// resendCode emails a new code for requestState, counting it against the resend budget of
+// the challenge and the rate limits of the mailbox. A challenge that is no longer pending
+// is left to report its own status.
+func resendCode(requestState *RequestState) *CaError {
+	challenge := requestState.ChallengeState
+	now := time.Now()
+	if err := challenge.CanResend(now); err != nil {
+		if errors.Is(err, ErrNoResendsLeft) || errors.Is(err, ErrResendTooSoon) {
+			return newCaError(ErrorTooManyRequests, "%s", err)
+		}
+		return nil
+	}
+	reservation, caErr := checkChallengeLimits(requestState, challenge.Email, now)
+	if caErr != nil {
+		return caErr
+	}
+	if _, err := challenge.Resend(); err != nil {
+		reservation.refund()
+		if errors.Is(err, ErrNoResendsLeft) || errors.Is(err, ErrResendTooSoon) {
+			return newCaError(ErrorTooManyRequests, "%s", err)
+		}
+		return newCaError(ErrorInvalidParameters, "failed to resend the code: %s", err)
+	}
+	return nil
+}
 
-	var digits = []rune("0123456789")
-	b := make([]rune, secretLength)
-	for i := range b {
-		b[i] = digits[rand.Intn(len(digits))]
+// normalizeEmailParam replaces the email parameter with its canonical form, so one mailbox
+// is always rate-limited and named the same way however the requester spells it.
+func normalizeEmailParam(params map[string]string) *CaError {
+	normalized, err := addressPolicy.Normalize(params[emailParamKey])
+	if err != nil {
+		return newCaError(ErrorInvalidParameters, "%s", err)
 	}
-	return string(b)
+	params[emailParamKey] = normalized
+	return nil
 }
 
-func (e *EmailChallengeState) sendEmail() error {
-	secretEmail, status, err := email.NewCodeEmail(e.Email, e.SecretCode)
+func currentMailer() (email.Mailer, error) {
+	if challengeMailer == nil {
+		return nil, ErrNoMailer
+	}
+	return challengeMailer, nil
+}
+
+func (e *EmailChallengeState) sendEmail(secretCode string, link string) error {
+	secretEmail, status, err := email.NewCodeEmailWithPattern(e.Email, secretCode, e.Config.Code.Pattern())
 	if status != email.Success {
 		return err
 	} else {
-		sendStatus, sendErr := secretEmail.SendCodeEmail()
+		mailer, err := currentMailer()
+		if err != nil {
+			return err
+		}
+		details := email.MessageDetails{
+			CaName:   caName,
+			CertName: e.CertName,
+			Expiry:   e.Expiry,
+			Locale:   e.Locale,
+			Link:     link,
+		}
+		if tracked, ok := mailer.(email.TrackedMailer); ok {
+			e.DeliveryId, err = secretEmail.Enqueue(tracked, details, emailTemplates)
+			if err != nil {
+				return err
+			}
+			e.delivery = tracked
+			return nil
+		}
+		sendStatus, sendErr := secretEmail.SendWithDetails(mailer, details, emailTemplates)
 		if sendStatus != email.Success {
 			return sendErr
 		}
 	}
 	return nil
 }
+
+// DeliveryStatus reports the delivery of the challenge email, when its mailer tracks it.
+func (e *EmailChallengeState) DeliveryStatus() (email.DeliveryStatus, bool) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.deliveryStatusLocked()
+}
+
+func (e *EmailChallengeState) deliveryStatusLocked() (email.DeliveryStatus, bool) {
+	if e.delivery == nil {
+		return 0, false
+	}
+	status, _ := e.delivery.Status(e.DeliveryId)
+	return status, true
+}
+
+// statusParams report the delivery status of the email to the requester, who may
+// otherwise wait for an email that cannot be delivered, and how many resends are left.
+func (e *EmailChallengeState) statusParams() []*schemaold.Param {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	params := []*schemaold.Param{{
+		ParamKey:   remainingResendsParamKey,
+		ParamValue: []byte(strconv.FormatUint(uint64(e.RemainingResends), 10)),
+	}}
+	if status, ok := e.deliveryStatusLocked(); ok {
+		params = append(params, &schemaold.Param{ParamKey: deliveryStatusParamKey, ParamValue: []byte(status.String())})
+	}
+	return params
+}
@@ -0,0 +1,296 @@
+package ca
+
+import (
+	"bytes"
+	"errors"
+	"ndn/ndncert/challenge/email"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newCodeChallengeState(code string, expiry time.Time) *EmailChallengeState {
+	salt := []byte("0123456789abcdef0123456789abcdef")
+	return &EmailChallengeState{
+		ChallengeState: ChallengeState{
+			RemainingAttempts: DefaultChallengeConfig.MaxAttempts,
+			Expiry:            expiry,
+			Status:            ChallengeModuleNeedCode,
+		},
+		Email:      "alice@ucla.edu",
+		SecretSalt: salt,
+		SecretHash: hashSecretCode(salt, code),
+	}
+}
+
+func TestCheckCodeHashedSecret(t *testing.T) {
+	state := newCodeChallengeState("123456", time.Now().Add(time.Minute))
+	if bytes.Contains(state.SecretHash, []byte("123456")) {
+		t.Fatal("failed to hide the secret code in the stored hash")
+	}
+
+	if status, err := state.CheckCode("654321"); status != ChallengeModuleWrongCode || err == nil {
+		t.Errorf("failed to reject a wrong code, got status %d", status)
+	}
+	if status, err := state.CheckCode("123456"); status != ChallengeModuleSuccess || err != nil {
+		t.Errorf("failed to accept the right code, got status %d, %v", status, err)
+	}
+	if state.SecretHash != nil || state.SecretSalt != nil {
+		t.Error("failed to erase the secret hash once the challenge succeeded")
+	}
+}
+
+func TestCheckCodeExpired(t *testing.T) {
+	state := newCodeChallengeState("123456", time.Now().Add(-time.Second))
+	if status, err := state.CheckCode("123456"); status != ChallengeModuleFailure || err == nil {
+		t.Errorf("failed to reject the right code after expiry, got status %d", status)
+	}
+}
+
+func TestCheckCodeConcurrentAttempts(t *testing.T) {
+	state := newCodeChallengeState("123456", time.Now().Add(time.Minute))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			state.CheckCode("000000")
+		}()
+	}
+	wg.Wait()
+
+	if state.RemainingAttempts != 0 {
+		t.Errorf("failed to count every attempt exactly once, %d attempts remain", state.RemainingAttempts)
+	}
+	if status, err := state.CheckCode("123456"); status != ChallengeModuleFailure || err == nil {
+		t.Errorf("failed to reject the right code once the attempts are exhausted, got status %d", status)
+	}
+}
+
+func TestChallengeDeliveryStatus(t *testing.T) {
+	queue, err := email.NewQueue(&email.MemoryMailer{From: "ca@ndn.example"}, email.QueueConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer queue.Close()
+	SetMailer(queue)
+	defer SetMailer(nil)
+
+	state := &EmailChallengeState{Email: "alice@ucla.edu"}
+	if err := state.InitiateChallenge(); err != nil {
+		t.Fatal(err)
+	}
+	if state.DeliveryId == "" {
+		t.Fatal("failed to record the delivery of the challenge email")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for status, _ := state.DeliveryStatus(); status != email.DeliveryDelivered; status, _ = state.DeliveryStatus() {
+		if time.Now().After(deadline) {
+			t.Fatalf("failed to deliver the challenge email, still %s", status)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	params := state.statusParams()
+	if len(params) != 2 || params[1].ParamKey != deliveryStatusParamKey || string(params[1].ParamValue) != "delivered" {
+		t.Errorf("failed to report the delivery status to the requester, got %+v", params)
+	}
+}
+
+func TestChallengeWithoutMailer(t *testing.T) {
+	SetMailer(nil)
+	state := &EmailChallengeState{Email: "alice@ucla.edu"}
+	if err := state.InitiateChallenge(); !errors.Is(err, ErrNoMailer) {
+		t.Errorf("failed to refuse a challenge without a configured mailer, got %v", err)
+	}
+}
+
+func TestNormalizeEmailParam(t *testing.T) {
+	if err := SetAddressPolicy(email.AddressPolicy{DeniedDomains: []string{"*."}}); err == nil {
+		t.Error("failed to reject a malformed domain rule")
+	}
+	if err := SetAddressPolicy(email.AddressPolicy{
+		AllowedDomains: []string{"*.ucla.edu"},
+		Subaddress:     email.SubaddressStrip,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	defer SetAddressPolicy(email.DefaultAddressPolicy)
+
+	params := map[string]string{emailParamKey: "Alice+NDN@CS.UCLA.edu"}
+	if caErr := normalizeEmailParam(params); caErr != nil {
+		t.Fatal(caErr)
+	}
+	if identity := emailIdentity(mustName(t, caName), params[emailParamKey]); !identity.Equal(mustName(t, "/ndn/edu/ucla/cs/alice")) {
+		t.Errorf("failed to bind the normalized address to its identity, got %s", identity)
+	}
+
+	for _, address := range []string{"alice@ucla.edu", `"Eve" <eve@cs.ucla.edu>`, ""} {
+		if caErr := normalizeEmailParam(map[string]string{emailParamKey: address}); caErr == nil || caErr.Code != ErrorInvalidParameters {
+			t.Errorf("failed to refuse the address %q, got %v", address, caErr)
+		}
+	}
+}
+
+func TestResendCode(t *testing.T) {
+	profile := setupCaKeychain(t)
+	mailer := &email.MemoryMailer{From: "ca@ndn.example"}
+	SetMailer(mailer)
+	defer SetMailer(nil)
+	SetResendPolicy(ResendPolicy{MaxResends: 2, ExtendExpiry: true})
+	defer SetResendPolicy(DefaultResendPolicy)
+	if err := SetRateLimits(RateLimits{PerAddress: RateLimit{Count: 2, Window: time.Hour}}); err != nil {
+		t.Fatal(err)
+	}
+	defer SetRateLimits(DefaultRateLimits)
+
+	requestId, session := newRequest(t, profile, "/ndn/edu/ucla/resend/KEY/1/self/1")
+	if _, errorMsg := sendChallenge(t, profile, requestId, session, map[string]string{emailParamKey: "resend@ucla.edu"}); errorMsg != nil {
+		t.Fatal(errorMsg.ErrorInfo)
+	}
+	firstCode := sentCode(t, mailer)
+	if chalData, _ := sendChallenge(t, profile, requestId, session, map[string]string{codeParamKey: "not the code"}); *chalData.RemainTries != 2 {
+		t.Fatalf("failed to count the wrong code, %d tries remain", *chalData.RemainTries)
+	}
+
+	chalData, errorMsg := sendChallenge(t, profile, requestId, session, map[string]string{resendParamKey: ""})
+	if errorMsg != nil {
+		t.Fatal(errorMsg.ErrorInfo)
+	}
+	if len(mailer.Messages()) != 2 || *chalData.ChalStatus != uint64(ChallengeModuleNeedCode) || *chalData.RemainTries != 2 {
+		t.Errorf("failed to resend a code without restoring tries, got status %d with %d tries", *chalData.ChalStatus, *chalData.RemainTries)
+	}
+	if chalData.Params[0].ParamKey != remainingResendsParamKey || string(chalData.Params[0].ParamValue) != "1" {
+		t.Errorf("failed to report the remaining resends, got %+v", chalData.Params[0])
+	}
+	secondCode := sentCode(t, mailer)
+
+	_, errorMsg = sendChallenge(t, profile, requestId, session, map[string]string{resendParamKey: ""})
+	if errorMsg == nil || errorMsg.ErrorCode != uint64(ErrorTooManyRequests) {
+		t.Error("failed to rate-limit resends to the same address")
+	}
+	if secondCode != firstCode {
+		if chalData, _ := sendChallenge(t, profile, requestId, session, map[string]string{codeParamKey: firstCode}); chalData.Status == uint64(Success) {
+			t.Fatal("failed to retire the first code when resending")
+		}
+	}
+	chalData, _ = sendChallenge(t, profile, requestId, session, map[string]string{codeParamKey: secondCode})
+	if chalData == nil || chalData.Status != uint64(Success) {
+		t.Error("failed to accept the resent code")
+	}
+}
+
+func TestCanResend(t *testing.T) {
+	SetResendPolicy(ResendPolicy{MaxResends: 1, MinInterval: 30 * time.Second})
+	defer SetResendPolicy(DefaultResendPolicy)
+	now := time.Now()
+
+	state := newCodeChallengeState("123456", now.Add(time.Minute))
+	state.RemainingResends = 1
+	state.LastSent = now
+	if err := state.CanResend(now.Add(10 * time.Second)); !errors.Is(err, ErrResendTooSoon) {
+		t.Errorf("failed to refuse a resend within the minimum interval, got %v", err)
+	}
+	if err := state.CanResend(now.Add(30 * time.Second)); err != nil {
+		t.Errorf("failed to allow a resend after the minimum interval, got %v", err)
+	}
+	state.RemainingResends = 0
+	if err := state.CanResend(now.Add(30 * time.Second)); !errors.Is(err, ErrNoResendsLeft) {
+		t.Errorf("failed to refuse a resend beyond the budget, got %v", err)
+	}
+	state.RemainingResends = 1
+	if err := state.CanResend(now.Add(2 * time.Minute)); err == nil {
+		t.Error("failed to refuse a resend for an expired challenge")
+	}
+}
+
+func TestChallengeStateMachine(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		status    ChallengeStatus
+		attempts  uint
+		expired   bool
+		code      string // "" polls instead of checking a code
+		expected  ChallengeStatus
+		err       error
+		remaining uint
+	}{
+		{"right code", ChallengeModuleNeedCode, 3, false, "123456", ChallengeModuleSuccess, nil, 3},
+		{"wrong code", ChallengeModuleNeedCode, 3, false, "000000", ChallengeModuleWrongCode, ErrWrongCode, 2},
+		{"right code after a wrong one", ChallengeModuleWrongCode, 2, false, "123456", ChallengeModuleSuccess, nil, 2},
+		{"wrong code on the last try", ChallengeModuleWrongCode, 1, false, "000000", ChallengeModuleFailure, ErrNoTriesLeft, 0},
+		{"right code after expiry", ChallengeModuleNeedCode, 3, true, "123456", ChallengeModuleFailure, ErrChallengeExpired, 3},
+		{"code after success", ChallengeModuleSuccess, 3, false, "000000", ChallengeModuleSuccess, ErrChallengeNotPending, 3},
+		{"code after failure", ChallengeModuleFailure, 0, false, "123456", ChallengeModuleFailure, ErrChallengeNotPending, 0},
+		{"code before the email", ChallengeModuleBeforeEmail, 3, false, "123456", ChallengeModuleBeforeEmail, ErrChallengeNotPending, 3},
+		{"poll", ChallengeModuleNeedCode, 3, false, "", ChallengeModuleNeedCode, nil, 3},
+		{"poll after expiry", ChallengeModuleWrongCode, 2, true, "", ChallengeModuleFailure, nil, 2},
+		{"poll after success", ChallengeModuleSuccess, 3, true, "", ChallengeModuleSuccess, nil, 3},
+	} {
+		expiry := time.Now().Add(time.Minute)
+		if test.expired {
+			expiry = time.Now().Add(-time.Second)
+		}
+		state := newCodeChallengeState("123456", expiry)
+		state.Status = test.status
+		state.RemainingAttempts = test.attempts
+
+		var status ChallengeStatus
+		var err error
+		if test.code == "" {
+			status = state.Poll()
+		} else {
+			status, err = state.CheckCode(test.code)
+		}
+		if status != test.expected || !errors.Is(err, test.err) || state.RemainingAttempts != test.remaining {
+			t.Errorf("%s: got %s, %v with %d tries, want %s, %v with %d tries", test.name,
+				status, err, state.RemainingAttempts, test.expected, test.err, test.remaining)
+		}
+		if status.IsTerminal() && !test.status.IsTerminal() && state.SecretHash != nil {
+			t.Errorf("%s: failed to erase the secret once the challenge ended", test.name)
+		}
+	}
+}
+
+func TestChallengeTransitions(t *testing.T) {
+	statuses := []ChallengeStatus{ChallengeModuleBeforeEmail, ChallengeModuleNeedCode,
+		ChallengeModuleWrongCode, ChallengeModuleFailure, ChallengeModuleSuccess}
+	for _, from := range statuses {
+		for _, to := range statuses {
+			state := &EmailChallengeState{ChallengeState: ChallengeState{Status: from}}
+			err := state.transition(to)
+			if from.IsTerminal() && !errors.Is(err, ErrInvalidTransition) {
+				t.Errorf("failed to refuse leaving %s for %s", from, to)
+			}
+			if from == ChallengeModuleBeforeEmail && (to == ChallengeModuleSuccess || to == ChallengeModuleWrongCode) && err == nil {
+				t.Errorf("failed to refuse moving from %s to %s without a code", from, to)
+			}
+			if (err == nil && state.Status != to) || (err != nil && state.Status != from) {
+				t.Errorf("transition from %s to %s left the status at %s, %v", from, to, state.Status, err)
+			}
+		}
+	}
+
+	state := newCodeChallengeState("123456", time.Now().Add(time.Minute))
+	state.mutex.Lock()
+	state.fail(ErrChallengeExpired)
+	state.fail(ErrNoTriesLeft)
+	state.mutex.Unlock()
+	if !errors.Is(state.FailureReason, ErrChallengeExpired) {
+		t.Errorf("failed to keep the first failure reason, got %v", state.FailureReason)
+	}
+}
+
+func TestChallengeFailureError(t *testing.T) {
+	for reason, code := range map[error]ErrorCode{
+		ErrNoTriesLeft:           ErrorRunOutOfTries,
+		ErrChallengeExpired:      ErrorRunOutOfTime,
+		errors.New("send error"): ErrorInvalidParameters,
+	} {
+		state := &EmailChallengeState{ChallengeState: ChallengeState{Status: ChallengeModuleFailure, FailureReason: reason}}
+		if caErr := state.failureError(); caErr.Code != code {
+			t.Errorf("failed to report %v as error %d, got %d", reason, code, caErr.Code)
+		}
+	}
+}
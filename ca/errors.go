@@ -0,0 +1,61 @@
+package ca
+
+import (
+	"errors"
+	"fmt"
+	enc "github.com/zjkmxy/go-ndn/pkg/encoding"
+	"ndn/ndncert/challenge/crypto"
+	"ndn/ndncert/challenge/schemaold"
+)
+
+type ErrorCode uint64
+
+const (
+	ErrorBadInterestFormat ErrorCode = iota + 1
+	ErrorBadParameterFormat
+	ErrorBadSignature
+	ErrorInvalidParameters
+	ErrorNameNotAllowed
+	ErrorBadValidityPeriod
+	ErrorRunOutOfTries
+	ErrorRunOutOfTime
+	ErrorNoAvailableNames
+	// ErrorTooManyRequests is not in the NDNCERT specification; the requester should retry later.
+	ErrorTooManyRequests
+	// ErrorCaUnavailable is not in the NDNCERT specification; the CA cannot sign its responses.
+	ErrorCaUnavailable
+)
+
+// CaError is a request failure that is reported to the requester as an NDNCERT error Data.
+type CaError struct {
+	Code ErrorCode
+	Info string
+}
+
+func (e *CaError) Error() string {
+	return fmt.Sprintf("NDNCERT error %d: %s", e.Code, e.Info)
+}
+
+func newCaError(code ErrorCode, format string, args ...any) *CaError {
+	return &CaError{Code: code, Info: fmt.Sprintf(format, args...)}
+}
+
+// cryptoError maps a failure of the secure channel to the error reported to the requester.
+func cryptoError(err error) *CaError {
+	switch {
+	case errors.Is(err, crypto.ErrInvalidPoint):
+		return newCaError(ErrorBadParameterFormat, "%s", err)
+	case errors.Is(err, crypto.ErrAuthentication), errors.Is(err, crypto.ErrReplay):
+		return newCaError(ErrorInvalidParameters, "parameters cannot be decrypted: %s", err)
+	default:
+		return newCaError(ErrorInvalidParameters, "secure channel failure: %s", err)
+	}
+}
+
+func makeErrorResponse(name enc.Name, caErr *CaError) enc.Wire {
+	errorMsg := schemaold.ErrorMsg{
+		ErrorCode: uint64(caErr.Code),
+		ErrorInfo: caErr.Info,
+	}
+	return makeResponse(name, errorMsg.Encode())
+}
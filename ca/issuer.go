@@ -0,0 +1,130 @@
+package ca
+
+import (
+	"encoding/json"
+	"fmt"
+	enc "github.com/zjkmxy/go-ndn/pkg/encoding"
+	"github.com/zjkmxy/go-ndn/pkg/ndn"
+	"github.com/zjkmxy/go-ndn/pkg/ndn/spec_2022"
+	"github.com/zjkmxy/go-ndn/pkg/utils"
+	"io"
+	"ndn/ndncert/challenge/crypto"
+	"ndn/ndncert/challenge/keychain"
+	"strconv"
+	"time"
+)
+
+const issuerComponent = "NDNCERT"
+
+// IssuanceRecord binds an issued certificate to the identity the requester proved through the challenge.
+type IssuanceRecord struct {
+	CertName         string            `json:"cert_name"`
+	Identity         string            `json:"identity"`
+	ChallengeType    string            `json:"challenge_type"`
+	VerifiedIdentity map[string]string `json:"verified_identity"`
+	NotBefore        time.Time         `json:"not_before"`
+	NotAfter         time.Time         `json:"not_after"`
+	IssuedAt         time.Time         `json:"issued_at"`
+}
+
+var caKeychain *keychain.Keychain
+var issuedCertificates = make(map[string]enc.Wire)
+var issuanceRecords = make(map[string]IssuanceRecord)
+var issuanceLog io.Writer
+
+// SetKeychain makes the CA sign responses and issued certificates with the active key of kc.
+func SetKeychain(kc *keychain.Keychain) {
+	caKeychain = kc
+}
+
+// GetIssuedCertificate returns the encoded certificate issued under name, or nil if there is none.
+func GetIssuedCertificate(name enc.Name) enc.Wire {
+	return issuedCertificates[name.String()]
+}
+
+// SetIssuanceLog makes the CA append every IssuanceRecord to w as a line of JSON.
+func SetIssuanceLog(w io.Writer) {
+	issuanceLog = w
+}
+
+// GetIssuanceRecord returns the binding recorded for the certificate issued under name.
+func GetIssuanceRecord(name enc.Name) (IssuanceRecord, bool) {
+	record, ok := issuanceRecords[name.String()]
+	return record, ok
+}
+
+func currentSigner() (ndn.Signer, error) {
+	if caKeychain != nil {
+		return caKeychain.Signer()
+	}
+	if caSigner == nil {
+		return nil, fmt.Errorf("CA signer is not configured")
+	}
+	return caSigner, nil
+}
+
+func requestIdentity(requestState *RequestState) enc.Name {
+	certReqName := requestState.cert.Name()
+	return certReqName[:len(certReqName)+negativeKeyComponentOffset]
+}
+
+// checkIdentityBinding makes sure the identity verified by the challenge is entitled to the requested name.
+func checkIdentityBinding(requestState *RequestState) *CaError {
+	identity := requestIdentity(requestState)
+	verified := requestState.ChallengeState.VerifiedIdentity()
+	if !isNameAllowed(requestState.caPrefix, identity, verified) {
+		return newCaError(ErrorNameNotAllowed, "identity %s is not allowed for the verified %s identity",
+			identity, requestState.ChallengeType)
+	}
+	return nil
+}
+
+func issueCertificate(requestState *RequestState) (enc.Name, error) {
+	signer, err := currentSigner()
+	if err != nil {
+		return nil, err
+	}
+
+	certReqName := requestState.cert.Name()
+	keyName := certReqName[:len(certReqName)+negativeKeyComponentOffset+2]
+	millisecondTS := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	certName, err := enc.NameFromStr(keyName.String() + "/" + issuerComponent + "/" + millisecondTS)
+	if err != nil {
+		return nil, err
+	}
+
+	certWire, _, err := spec_2022.Spec{}.MakeData(
+		certName,
+		&ndn.DataConfig{
+			ContentType: utils.IdPtr(ndn.ContentTypeKey),
+		},
+		requestState.cert.Content(),
+		crypto.WithValidity(signer, requestState.notBefore, requestState.notAfter),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	record := IssuanceRecord{
+		CertName:         certName.String(),
+		Identity:         requestIdentity(requestState).String(),
+		ChallengeType:    requestState.ChallengeType,
+		VerifiedIdentity: requestState.ChallengeState.VerifiedIdentity(),
+		NotBefore:        requestState.notBefore,
+		NotAfter:         requestState.notAfter,
+		IssuedAt:         time.Now(),
+	}
+	if issuanceLog != nil {
+		line, err := json.Marshal(record)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := issuanceLog.Write(append(line, '\n')); err != nil {
+			return nil, fmt.Errorf("failed to record issuance of %s: %w", certName, err)
+		}
+	}
+
+	issuedCertificates[certName.String()] = certWire
+	issuanceRecords[certName.String()] = record
+	return certName, nil
+}
@@ -0,0 +1,83 @@
+package ca
+
+import (
+	"bytes"
+	"encoding/json"
+	enc "github.com/zjkmxy/go-ndn/pkg/encoding"
+	"github.com/zjkmxy/go-ndn/pkg/ndn"
+	"github.com/zjkmxy/go-ndn/pkg/ndn/spec_2022"
+	"github.com/zjkmxy/go-ndn/pkg/security"
+	"github.com/zjkmxy/go-ndn/pkg/utils"
+	"testing"
+	"time"
+)
+
+func makeCertRequestWire(t *testing.T, name string) enc.Wire {
+	wire, _, err := spec_2022.Spec{}.MakeData(
+		mustName(t, name),
+		&ndn.DataConfig{
+			ContentType: utils.IdPtr(ndn.ContentTypeKey),
+		},
+		enc.Wire{[]byte("public key")},
+		security.NewSha256Signer(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return wire
+}
+
+func makeCertRequest(t *testing.T, name string) ndn.Data {
+	data, _, err := spec_2022.Spec{}.ReadData(enc.NewWireReader(makeCertRequestWire(t, name)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestIssuanceBinding(t *testing.T) {
+	setupCaKeychain(t)
+	SetNamePolicies([]NamePolicy{&EmailNamePolicy{Prefix: mustName(t, caName)}})
+	defer SetNamePolicies(nil)
+	var log bytes.Buffer
+	SetIssuanceLog(&log)
+	defer SetIssuanceLog(nil)
+
+	newRequestState := func(certReqName string) *RequestState {
+		return &RequestState{
+			caPrefix:       mustName(t, caName),
+			cert:           makeCertRequest(t, certReqName),
+			notBefore:      time.Now(),
+			notAfter:       time.Now().Add(time.Minute),
+			ChallengeType:  "email",
+			ChallengeState: &EmailChallengeState{Email: "alice@ucla.edu"},
+		}
+	}
+
+	if caErr := checkIdentityBinding(newRequestState("/ndn/edu/ucla/bob/KEY/1/self/1")); caErr == nil || caErr.Code != ErrorNameNotAllowed {
+		t.Error("failed to reject a name that does not belong to the verified email address")
+	}
+
+	requestState := newRequestState("/ndn/edu/ucla/alice/KEY/1/self/1")
+	if caErr := checkIdentityBinding(requestState); caErr != nil {
+		t.Fatalf("failed to accept the name of the verified email address: %s", caErr)
+	}
+
+	certName, err := issueCertificate(requestState)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if GetIssuedCertificate(certName) == nil {
+		t.Error("failed to store the issued certificate")
+	}
+
+	record, ok := GetIssuanceRecord(certName)
+	if !ok || record.VerifiedIdentity["email"] != "alice@ucla.edu" || record.Identity != "/ndn/edu/ucla/alice" {
+		t.Errorf("failed to record the identity binding, got %+v", record)
+	}
+
+	var logged IssuanceRecord
+	if err := json.Unmarshal(log.Bytes(), &logged); err != nil || logged.CertName != certName.String() {
+		t.Errorf("failed to write the issuance record to the log, got %q", log.String())
+	}
+}
@@ -0,0 +1,241 @@
+package ca
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	magicLinkTokenLength = 32
+	magicLinkTokenParam  = "token"
+)
+
+var magicLinkURL *url.URL
+
+// magicLinkServer serves MagicLinkHandler once ServeMagicLinks is given an address.
+var magicLinkServer struct {
+	sync.Mutex
+	server   *http.Server
+	listener net.Listener
+}
+
+// magicLinks finds the challenge of a link by the SHA-256 of its token, so the tokens
+// themselves are never kept.
+var magicLinks = struct {
+	sync.Mutex
+	links map[string]magicLink
+}{links: make(map[string]magicLink)}
+
+// magicLink keeps the expiry of the challenge when the link was sent, so expired links are
+// pruned without taking the lock of every other challenge.
+type magicLink struct {
+	state  *EmailChallengeState
+	expiry time.Time
+}
+
+var magicLinkPage = template.Must(template.New("magic-link").Parse(`<!DOCTYPE html>
+<html>
+<head><title>NDN certificate request</title></head>
+<body>
+{{if .Confirm}}<p>Confirm the certificate request{{if .CertName}} for <code>{{.CertName}}</code>{{end}} made with this email address?</p>
+<form method="post"><input type="hidden" name="token" value="{{.Token}}"><button type="submit">Confirm</button></form>
+{{else}}<p>{{.Message}}</p>
+{{end}}</body>
+</html>
+`))
+
+type magicLinkPageData struct {
+	Confirm  bool
+	CertName string
+	Token    string
+	Message  string
+}
+
+// SetMagicLinkURL makes challenge emails carry a one-time link to base, served by
+// MagicLinkHandler, that completes the challenge without the code. An empty base disables links.
+func SetMagicLinkURL(base string) error {
+	if base == "" {
+		magicLinkURL = nil
+		return nil
+	}
+	parsed, err := url.Parse(base)
+	if err != nil {
+		return err
+	}
+	if (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" {
+		return fmt.Errorf("magic link URL %s is not an absolute HTTP URL", base)
+	}
+	magicLinkURL = parsed
+	return nil
+}
+
+// ServeMagicLinks serves MagicLinkHandler over HTTP on address, in place of the server of an
+// earlier call. An empty address stops serving. The links must reach the address, directly or
+// through a proxy terminating TLS, under the URL given to SetMagicLinkURL.
+func ServeMagicLinks(address string) error {
+	magicLinkServer.Lock()
+	defer magicLinkServer.Unlock()
+
+	var listener net.Listener
+	if address != "" {
+		var err error
+		if listener, err = net.Listen("tcp", address); err != nil {
+			return err
+		}
+	}
+	if magicLinkServer.server != nil {
+		magicLinkServer.server.Close()
+	}
+	magicLinkServer.server, magicLinkServer.listener = nil, listener
+	if listener == nil {
+		return nil
+	}
+	server := &http.Server{
+		Handler:           MagicLinkHandler(),
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       30 * time.Second,
+		WriteTimeout:      30 * time.Second,
+		MaxHeaderBytes:    1 << 16,
+	}
+	magicLinkServer.server = server
+	go server.Serve(listener)
+	return nil
+}
+
+// MagicLinkAddr returns the address the links are served on, or nil when they are not.
+func MagicLinkAddr() net.Addr {
+	magicLinkServer.Lock()
+	defer magicLinkServer.Unlock()
+	if magicLinkServer.listener == nil {
+		return nil
+	}
+	return magicLinkServer.listener.Addr()
+}
+
+// MagicLinkHandler serves the links of challenge emails. Opening a link only shows a
+// confirmation form, since mail scanners fetch links on their own; the challenge is
+// completed when the form is submitted, and the requester learns it on its next CHALLENGE.
+func MagicLinkHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Referrer-Policy", "no-referrer")
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+		switch r.Method {
+		case http.MethodGet:
+			token := r.URL.Query().Get(magicLinkTokenParam)
+			state := lookupMagicLink(token)
+			if state == nil || !state.pending(time.Now()) {
+				w.WriteHeader(http.StatusNotFound)
+				magicLinkPage.Execute(w, magicLinkPageData{Message: "This link is invalid or has expired."})
+				return
+			}
+			magicLinkPage.Execute(w, magicLinkPageData{Confirm: true, CertName: state.CertName, Token: token})
+		case http.MethodPost:
+			token := r.PostFormValue(magicLinkTokenParam)
+			state := lookupMagicLink(token)
+			if state == nil {
+				w.WriteHeader(http.StatusNotFound)
+				magicLinkPage.Execute(w, magicLinkPageData{Message: "This link is invalid or has expired."})
+				return
+			}
+			if _, err := state.ConfirmLink(token); err != nil {
+				w.WriteHeader(http.StatusGone)
+				magicLinkPage.Execute(w, magicLinkPageData{Message: "This request can no longer be confirmed."})
+				return
+			}
+			magicLinkPage.Execute(w, magicLinkPageData{Message: "The request is confirmed. You can return to your NDN client."})
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+// newMagicLink registers a link to e and returns it, or returns "" when links are disabled.
+// e.mutex must be held.
+func (e *EmailChallengeState) newMagicLink() (string, error) {
+	if magicLinkURL == nil {
+		return "", nil
+	}
+	token := make([]byte, magicLinkTokenLength)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(token)
+	hash := sha256.Sum256([]byte(encoded))
+	e.LinkHash = hash[:]
+
+	now := time.Now()
+	magicLinks.Lock()
+	for key, link := range magicLinks.links {
+		if now.After(link.expiry) {
+			delete(magicLinks.links, key)
+		}
+	}
+	magicLinks.links[hex.EncodeToString(e.LinkHash)] = magicLink{state: e, expiry: e.Expiry}
+	magicLinks.Unlock()
+
+	link := *magicLinkURL
+	query := link.Query()
+	query.Set(magicLinkTokenParam, encoded)
+	link.RawQuery = query.Encode()
+	return link.String(), nil
+}
+
+// ConfirmLink completes the challenge with the token of its link, once.
+func (e *EmailChallengeState) ConfirmLink(token string) (ChallengeStatus, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if !e.pendingLocked(time.Now()) || e.LinkHash == nil {
+		return e.Status, ErrChallengeNotPending
+	}
+	hash := sha256.Sum256([]byte(token))
+	if subtle.ConstantTimeCompare(hash[:], e.LinkHash) != 1 {
+		return e.Status, fmt.Errorf("Incorrect Link Token")
+	}
+	err := e.transition(ChallengeModuleSuccess)
+	return e.Status, err
+}
+
+func (e *EmailChallengeState) pending(now time.Time) bool {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.pendingLocked(now)
+}
+
+func (e *EmailChallengeState) pendingLocked(now time.Time) bool {
+	return e.Status.isPending() && !now.After(e.Expiry)
+}
+
+func lookupMagicLink(token string) *EmailChallengeState {
+	if token == "" {
+		return nil
+	}
+	hash := sha256.Sum256([]byte(token))
+	magicLinks.Lock()
+	defer magicLinks.Unlock()
+	return magicLinks.links[hex.EncodeToString(hash[:])].state
+}
+
+// forgetMagicLink unregisters the link of e. e.mutex must be held.
+func (e *EmailChallengeState) forgetMagicLink() {
+	if e.LinkHash == nil {
+		return
+	}
+	magicLinks.Lock()
+	delete(magicLinks.links, hex.EncodeToString(e.LinkHash))
+	magicLinks.Unlock()
+	e.LinkHash = nil
+}
@@ -0,0 +1,257 @@
+package ca
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"mime"
+	"mime/multipart"
+	"ndn/ndncert/challenge/email"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var sentLinkPattern = regexp.MustCompile(`https://\S+`)
+
+// sentLink returns the magic link in the plain text part of the last email delivered through mailer.
+func sentLink(t *testing.T, mailer *email.MemoryMailer) *url.URL {
+	messages := mailer.Messages()
+	if len(messages) == 0 {
+		t.Fatal("failed to send the challenge email")
+	}
+	message, err := mail.ReadMessage(bytes.NewReader(messages[len(messages)-1].Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, params, _ := mime.ParseMediaType(message.Header.Get("Content-Type"))
+	part, err := multipart.NewReader(message.Body, params["boundary"]).NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	text, _ := io.ReadAll(part)
+	link := sentLinkPattern.Find(text)
+	if link == nil {
+		t.Fatalf("failed to find a magic link in %q", text)
+	}
+	parsed, err := url.Parse(string(link))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed
+}
+
+func TestMagicLink(t *testing.T) {
+	profile := setupCaKeychain(t)
+	mailer := &email.MemoryMailer{From: "ca@ndn.example"}
+	SetMailer(mailer)
+	defer SetMailer(nil)
+	if err := SetMagicLinkURL("https://ca.ndn.example/confirm"); err != nil {
+		t.Fatal(err)
+	}
+	defer SetMagicLinkURL("")
+
+	requestId, session := newRequest(t, profile, "/ndn/edu/ucla/link/KEY/1/self/1")
+	if _, errorMsg := sendChallenge(t, profile, requestId, session, map[string]string{emailParamKey: "link@ucla.edu"}); errorMsg != nil {
+		t.Fatal(errorMsg.ErrorInfo)
+	}
+	link := sentLink(t, mailer)
+	if link.Host != "ca.ndn.example" || link.Path != "/confirm" {
+		t.Errorf("failed to link to the configured URL, got %s", link)
+	}
+
+	server := httptest.NewServer(MagicLinkHandler())
+	defer server.Close()
+	confirmURL := server.URL + link.Path + "?" + link.RawQuery
+
+	response, err := http.Get(confirmURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, _ := io.ReadAll(response.Body)
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK || !strings.Contains(string(page), `<form method="post">`) {
+		t.Errorf("failed to ask for confirmation when the link is opened, got %d %q", response.StatusCode, page)
+	}
+	chalData, _ := sendChallenge(t, profile, requestId, session, nil)
+	if chalData == nil || chalData.Status != uint64(CaModuleChallenge) || *chalData.ChalStatus != uint64(ChallengeModuleNeedCode) {
+		t.Fatal("failed to keep the challenge pending when the link is only opened")
+	}
+
+	token := link.Query().Get(magicLinkTokenParam)
+	response, err = http.PostForm(server.URL+link.Path, url.Values{magicLinkTokenParam: {token}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		t.Errorf("failed to confirm the request through the link, got %d", response.StatusCode)
+	}
+
+	chalData, _ = sendChallenge(t, profile, requestId, session, nil)
+	if chalData == nil || chalData.Status != uint64(Success) || GetIssuedCertificate(chalData.CertName) == nil {
+		t.Fatal("failed to issue the certificate on the poll after the link was confirmed")
+	}
+
+	for _, test := range []struct {
+		method string
+		token  string
+		want   int
+	}{
+		{http.MethodPost, token, http.StatusNotFound},
+		{http.MethodGet, token, http.StatusNotFound},
+		{http.MethodGet, "forged", http.StatusNotFound},
+		{http.MethodPut, token, http.StatusMethodNotAllowed},
+	} {
+		request, _ := http.NewRequest(test.method, confirmURL, nil)
+		if test.method == http.MethodPost {
+			request, _ = http.NewRequest(test.method, server.URL, strings.NewReader(url.Values{magicLinkTokenParam: {test.token}}.Encode()))
+			request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		} else if test.token != token {
+			request, _ = http.NewRequest(test.method, server.URL+"?"+magicLinkTokenParam+"="+test.token, nil)
+		}
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+		if response.StatusCode != test.want {
+			t.Errorf("%s with token %q: got %d, want %d", test.method, test.token, response.StatusCode, test.want)
+		}
+	}
+}
+
+func TestMagicLinkConfirmWhilePolling(t *testing.T) {
+	profile := setupCaKeychain(t)
+	mailer := &email.MemoryMailer{From: "ca@ndn.example"}
+	SetMailer(mailer)
+	defer SetMailer(nil)
+	if err := SetMagicLinkURL("https://ca.ndn.example/confirm"); err != nil {
+		t.Fatal(err)
+	}
+	defer SetMagicLinkURL("")
+	if err := SetRateLimits(RateLimits{}); err != nil {
+		t.Fatal(err)
+	}
+	defer SetRateLimits(DefaultRateLimits)
+
+	requestId, session := newRequest(t, profile, "/ndn/edu/ucla/poll/KEY/1/self/1")
+	if _, errorMsg := sendChallenge(t, profile, requestId, session, map[string]string{emailParamKey: "poll@ucla.edu"}); errorMsg != nil {
+		t.Fatal(errorMsg.ErrorInfo)
+	}
+	link := sentLink(t, mailer)
+	server := httptest.NewServer(MagicLinkHandler())
+	defer server.Close()
+
+	// The status of the challenge is reported to polling requesters while the link is
+	// confirmed from the browser.
+	requestState := storage[requestId]
+	polled := make(chan uint64)
+	go func() {
+		for {
+			chalData := pendingChallengeData(requestState)
+			if ChallengeStatus(*chalData.ChalStatus).IsTerminal() {
+				polled <- *chalData.ChalStatus
+				return
+			}
+		}
+	}()
+	response, err := http.PostForm(server.URL+link.Path, url.Values{magicLinkTokenParam: {link.Query().Get(magicLinkTokenParam)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		t.Errorf("failed to confirm the request through the link, got %d", response.StatusCode)
+	}
+	if status := <-polled; status != uint64(ChallengeModuleSuccess) {
+		t.Errorf("failed to report the confirmed challenge, got %s", ChallengeStatus(status))
+	}
+
+	chalData, _ := sendChallenge(t, profile, requestId, session, nil)
+	if chalData == nil || chalData.Status != uint64(Success) || GetIssuedCertificate(chalData.CertName) == nil {
+		t.Fatal("failed to issue the certificate on the poll after the link was confirmed")
+	}
+}
+
+func TestMagicLinkConcurrentChallenges(t *testing.T) {
+	SetMailer(&email.MemoryMailer{From: "ca@ndn.example"})
+	defer SetMailer(nil)
+	if err := SetMagicLinkURL("https://ca.ndn.example/confirm"); err != nil {
+		t.Fatal(err)
+	}
+	defer SetMagicLinkURL("")
+	SetResendPolicy(ResendPolicy{MaxResends: 100, ExtendExpiry: true})
+	defer SetResendPolicy(DefaultResendPolicy)
+
+	// Resending renews the expiry of a challenge while new challenges prune the links.
+	resent := &EmailChallengeState{Email: "alice@ucla.edu"}
+	if err := resent.InitiateChallenge(); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 50; i++ {
+			if _, err := resent.Resend(); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	for i := 0; i < 50; i++ {
+		state := &EmailChallengeState{Email: "bob@ucla.edu"}
+		if err := state.InitiateChallenge(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-done
+
+	hash := resent.LinkHash
+	if hash == nil || magicLinks.links[hex.EncodeToString(hash)].state != resent {
+		t.Error("failed to keep the link of the last resent email")
+	}
+}
+
+func TestServeMagicLinks(t *testing.T) {
+	if err := ServeMagicLinks("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	addr := MagicLinkAddr()
+	if addr == nil {
+		t.Fatal("failed to report the address the links are served on")
+	}
+	response, err := http.Get("http://" + addr.String() + "/confirm?token=unknown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusNotFound {
+		t.Errorf("failed to serve the magic link handler, got %d", response.StatusCode)
+	}
+
+	if err := ServeMagicLinks(""); err != nil {
+		t.Fatal(err)
+	}
+	if MagicLinkAddr() != nil {
+		t.Error("failed to stop serving the links")
+	}
+	if _, err := http.Get("http://" + addr.String() + "/confirm"); err == nil {
+		t.Error("failed to close the server of the links")
+	}
+}
+
+func TestSetMagicLinkURL(t *testing.T) {
+	for _, base := range []string{"ftp://ca.ndn.example/", "/confirm", "https://"} {
+		if err := SetMagicLinkURL(base); err == nil {
+			t.Errorf("failed to reject the magic link URL %q", base)
+		}
+	}
+	if magicLinkURL != nil {
+		t.Error("failed to leave magic links disabled after an invalid URL")
+	}
+}
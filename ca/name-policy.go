@@ -0,0 +1,188 @@
+package ca
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	enc "github.com/zjkmxy/go-ndn/pkg/encoding"
+	"strings"
+	"sync"
+	"time"
+)
+
+const emailParamKey = "email"
+
+// NamePolicy decides which identity names a requester may obtain a certificate for.
+type NamePolicy interface {
+	// Suggest proposes identity names for the parameters of a PROBE request.
+	Suggest(params map[string]string) []enc.Name
+	// Allowed reports whether identity is permitted for the identity proven by params.
+	// Params are nil before any challenge has been completed, in which case only names that the
+	// policy could still grant are allowed; the name is checked again with the verified identity.
+	Allowed(identity enc.Name, params map[string]string) bool
+}
+
+// EmailNamePolicy assigns /<prefix>/edu/ucla/alice to alice@ucla.edu.
+type EmailNamePolicy struct {
+	Prefix enc.Name
+}
+
+// RandomSuffixPolicy assigns /<prefix>/<random hex> to anyone with an email address. A
+// suggested name is reserved for that address, so no one else can claim it.
+type RandomSuffixPolicy struct {
+	Prefix       enc.Name
+	SuffixLength int
+	mutex        sync.Mutex
+	reservations map[string]suffixReservation
+}
+
+type suffixReservation struct {
+	email  string
+	expiry time.Time
+}
+
+// A suggested random name stays reserved for this long, enough to request it and pass the challenge.
+const suffixReservationLifetime = time.Hour
+
+// AllowlistPolicy permits each listed identity to the email addresses of its entry.
+type AllowlistPolicy struct {
+	Entries []AllowlistEntry
+}
+
+type AllowlistEntry struct {
+	Name   enc.Name
+	Emails []string
+}
+
+var namePolicies []NamePolicy
+
+// SetNamePolicies changes the policies deciding which names requesters may obtain. Without
+// policies, identities are bound to verified email addresses under the CA prefix.
+func SetNamePolicies(policies []NamePolicy) {
+	namePolicies = policies
+}
+
+func activeNamePolicies(caPrefix enc.Name) []NamePolicy {
+	if len(namePolicies) == 0 {
+		return []NamePolicy{&EmailNamePolicy{Prefix: caPrefix}}
+	}
+	return namePolicies
+}
+
+func suggestNames(caPrefix enc.Name, params map[string]string) []enc.Name {
+	var suggestions []enc.Name
+	for _, policy := range activeNamePolicies(caPrefix) {
+		suggestions = append(suggestions, policy.Suggest(params)...)
+	}
+	return suggestions
+}
+
+// isNameAllowed checks that identity is under the CA prefix and allowed by one of the active policies.
+func isNameAllowed(caPrefix enc.Name, identity enc.Name, params map[string]string) bool {
+	if !caPrefix.IsPrefix(identity) || len(identity) == len(caPrefix) {
+		return false
+	}
+	for _, policy := range activeNamePolicies(caPrefix) {
+		if policy.Allowed(identity, params) {
+			return true
+		}
+	}
+	return false
+}
+
+func appendComponents(prefix enc.Name, values ...string) enc.Name {
+	name := make(enc.Name, 0, len(prefix)+len(values))
+	name = append(name, prefix...)
+	for _, value := range values {
+		name = append(name, enc.NewStringComponent(enc.TypeGenericNameComponent, value))
+	}
+	return name
+}
+
+func emailIdentity(prefix enc.Name, address string) enc.Name {
+	at := strings.LastIndex(address, "@")
+	if at <= 0 || at == len(address)-1 {
+		return nil
+	}
+	labels := strings.Split(strings.ToLower(address[at+1:]), ".")
+	for i, j := 0, len(labels)-1; i < j; i, j = i+1, j-1 {
+		labels[i], labels[j] = labels[j], labels[i]
+	}
+	return appendComponents(prefix, append(labels, address[:at])...)
+}
+
+func (p *EmailNamePolicy) Suggest(params map[string]string) []enc.Name {
+	identity := emailIdentity(p.Prefix, params[emailParamKey])
+	if identity == nil {
+		return nil
+	}
+	return []enc.Name{identity}
+}
+
+func (p *EmailNamePolicy) Allowed(identity enc.Name, params map[string]string) bool {
+	if params == nil {
+		// The shortest derived name is /<prefix>/<tld>/<user>.
+		return p.Prefix.IsPrefix(identity) && len(identity) >= len(p.Prefix)+2
+	}
+	expected := emailIdentity(p.Prefix, params[emailParamKey])
+	return expected != nil && expected.Equal(identity)
+}
+
+func (p *RandomSuffixPolicy) Suggest(params map[string]string) []enc.Name {
+	address := params[emailParamKey]
+	if address == "" {
+		return nil
+	}
+	suffix := make([]byte, p.SuffixLength)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil
+	}
+	name := appendComponents(p.Prefix, hex.EncodeToString(suffix))
+
+	now := time.Now()
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.reservations == nil {
+		p.reservations = make(map[string]suffixReservation)
+	}
+	for key, reservation := range p.reservations {
+		if now.After(reservation.expiry) {
+			delete(p.reservations, key)
+		}
+	}
+	p.reservations[name.String()] = suffixReservation{email: address, expiry: now.Add(suffixReservationLifetime)}
+	return []enc.Name{name}
+}
+
+func (p *RandomSuffixPolicy) Allowed(identity enc.Name, params map[string]string) bool {
+	if !p.Prefix.IsPrefix(identity) || len(identity) != len(p.Prefix)+1 {
+		return false
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	reservation, ok := p.reservations[identity.String()]
+	if !ok || time.Now().After(reservation.expiry) {
+		return false
+	}
+	return params == nil || strings.EqualFold(reservation.email, params[emailParamKey])
+}
+
+func (p *AllowlistPolicy) Suggest(params map[string]string) []enc.Name {
+	return nil
+}
+
+func (p *AllowlistPolicy) Allowed(identity enc.Name, params map[string]string) bool {
+	for _, entry := range p.Entries {
+		if !entry.Name.Equal(identity) {
+			continue
+		}
+		if params == nil {
+			return true
+		}
+		for _, address := range entry.Emails {
+			if strings.EqualFold(address, params[emailParamKey]) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,148 @@
+package ca
+
+import (
+	"crypto/sha256"
+	"fmt"
+	enc "github.com/zjkmxy/go-ndn/pkg/encoding"
+	"github.com/zjkmxy/go-ndn/pkg/ndn/spec_2022"
+	"ndn/ndncert/challenge/client"
+	"ndn/ndncert/challenge/email"
+	"ndn/ndncert/challenge/schemaold"
+	"testing"
+)
+
+func mustName(t *testing.T, s string) enc.Name {
+	name, err := enc.NameFromStr(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestNamePolicies(t *testing.T) {
+	prefix := mustName(t, "/ndn")
+	email := &EmailNamePolicy{Prefix: prefix}
+	random := &RandomSuffixPolicy{Prefix: mustName(t, "/ndn/guest"), SuffixLength: 4}
+	allowlist := &AllowlistPolicy{Entries: []AllowlistEntry{{Name: mustName(t, "/ndn/admin"), Emails: []string{"alice@ucla.edu"}}}}
+	aliceParams := map[string]string{"email": "alice@ucla.edu"}
+	bobParams := map[string]string{"email": "bob@ucla.edu"}
+
+	suggestion := email.Suggest(aliceParams)
+	if len(suggestion) != 1 || !suggestion[0].Equal(mustName(t, "/ndn/edu/ucla/alice")) {
+		t.Errorf("failed to derive /ndn/edu/ucla/alice from alice@ucla.edu, got %v", suggestion)
+	}
+
+	if random.Suggest(nil) != nil {
+		t.Error("failed to refuse a random name to a requester without an email address")
+	}
+	suggestion = random.Suggest(aliceParams)
+	if len(suggestion) != 1 || !random.Allowed(suggestion[0], nil) || !random.Allowed(suggestion[0], aliceParams) {
+		t.Error("failed to allow the name suggested by the random suffix policy")
+	}
+	if random.Allowed(suggestion[0], bobParams) {
+		t.Error("failed to reserve the random name for the address it was suggested to")
+	}
+
+	tests := []struct {
+		name     string
+		policy   NamePolicy
+		identity string
+		params   map[string]string
+		want     bool
+	}{
+		{"email shape before challenge", email, "/ndn/edu/ucla/alice", nil, true},
+		{"email match", email, "/ndn/edu/ucla/alice", aliceParams, true},
+		{"email of someone else", email, "/ndn/edu/ucla/bob", aliceParams, false},
+		{"email name too short", email, "/ndn/alice", nil, false},
+		{"random suffix never suggested", random, "/ndn/guest/abcd", nil, false},
+		{"random suffix too deep", random, "/ndn/guest/a/b", nil, false},
+		{"random suffix outside prefix", random, "/ndn/other/a", nil, false},
+		{"allowlisted before challenge", allowlist, "/ndn/admin", nil, true},
+		{"allowlisted to the verified email", allowlist, "/ndn/admin", map[string]string{"email": "Alice@UCLA.edu"}, true},
+		{"allowlisted to another email", allowlist, "/ndn/admin", bobParams, false},
+		{"not allowlisted", allowlist, "/ndn/root", aliceParams, false},
+	}
+
+	for _, test := range tests {
+		if got := test.policy.Allowed(mustName(t, test.identity), test.params); got != test.want {
+			t.Errorf("%s: Allowed(%s) = %v, want %v", test.name, test.identity, got, test.want)
+		}
+	}
+}
+
+func TestOnProbe(t *testing.T) {
+	profile := setupCaKeychain(t)
+	SetNamePolicies([]NamePolicy{&EmailNamePolicy{Prefix: mustName(t, caName)}})
+	defer SetNamePolicies(nil)
+
+	probeInt := schemaold.ProbeInt{Params: []*schemaold.Param{{
+		ParamKey:   "email",
+		ParamValue: []byte("alice@ucla.edu"),
+	}}}
+	appParamsWire := probeInt.Encode()
+	digest := sha256.Sum256(appParamsWire.Join())
+
+	i := &spec_2022.Interest{
+		NameV:                 mustName(t, fmt.Sprintf("/ndn/CA/PROBE/params-sha256=%x", digest)),
+		MustBeFreshV:          true,
+		ApplicationParameters: appParamsWire,
+	}
+
+	data, err := client.ValidateData(profile, OnProbe(i))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	probeResData, err := schemaold.ParseProbeResData(enc.NewWireReader(data.Content()), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(probeResData.Responses) != 1 || !probeResData.Responses[0].Response.Equal(mustName(t, "/ndn/edu/ucla/alice")) {
+		t.Error("failed to suggest the email-derived name in the PROBE response")
+	}
+}
+
+func TestDefaultNamePolicy(t *testing.T) {
+	profile := setupCaKeychain(t)
+	mailer := &email.MemoryMailer{From: "ca@ndn.example"}
+	SetMailer(mailer)
+	defer SetMailer(nil)
+	SetNamePolicies(nil)
+
+	if isNameAllowed(mustName(t, caName), mustName(t, "/ndn/admin"), nil) {
+		t.Error("failed to refuse a name no email address maps to")
+	}
+
+	requestId, session := newRequest(t, profile, "/ndn/edu/ucla/bob/KEY/1/self/1")
+	if _, errorMsg := sendChallenge(t, profile, requestId, session, map[string]string{emailParamKey: "mallory@ucla.edu"}); errorMsg != nil {
+		t.Fatal(errorMsg.ErrorInfo)
+	}
+	_, errorMsg := sendChallenge(t, profile, requestId, session, map[string]string{codeParamKey: sentCode(t, mailer)})
+	if errorMsg == nil || errorMsg.ErrorCode != uint64(ErrorNameNotAllowed) {
+		t.Errorf("failed to refuse the name of bob@ucla.edu to mallory@ucla.edu, got %+v", errorMsg)
+	}
+}
+
+func TestAllowlistBinding(t *testing.T) {
+	profile := setupCaKeychain(t)
+	mailer := &email.MemoryMailer{From: "ca@ndn.example"}
+	SetMailer(mailer)
+	defer SetMailer(nil)
+	SetNamePolicies([]NamePolicy{&AllowlistPolicy{Entries: []AllowlistEntry{
+		{Name: mustName(t, "/ndn/admin"), Emails: []string{"admin@ucla.edu"}},
+	}}})
+	defer SetNamePolicies(nil)
+
+	for address, expected := range map[string]ErrorCode{"eve@ucla.edu": ErrorNameNotAllowed, "admin@ucla.edu": 0} {
+		requestId, session := newRequest(t, profile, "/ndn/admin/KEY/"+address+"/self/1")
+		if _, errorMsg := sendChallenge(t, profile, requestId, session, map[string]string{emailParamKey: address}); errorMsg != nil {
+			t.Fatal(errorMsg.ErrorInfo)
+		}
+		chalData, errorMsg := sendChallenge(t, profile, requestId, session, map[string]string{codeParamKey: sentCode(t, mailer)})
+		if expected == 0 && (chalData == nil || chalData.Status != uint64(Success)) {
+			t.Errorf("failed to issue the allowlisted name to %s, got %+v", address, errorMsg)
+		} else if expected != 0 && (errorMsg == nil || errorMsg.ErrorCode != uint64(expected)) {
+			t.Errorf("failed to refuse the allowlisted name to %s, got %+v", address, errorMsg)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package ca
+
+import (
+	enc "github.com/zjkmxy/go-ndn/pkg/encoding"
+	"github.com/zjkmxy/go-ndn/pkg/utils"
+	"ndn/ndncert/challenge/schemaold"
+)
+
+// Profile describes the CA to requesters, including the certificate its responses are signed with.
+func Profile() (*schemaold.CaProfile, error) {
+	caPrefix, err := enc.NameFromStr(caName)
+	if err != nil {
+		return nil, err
+	}
+
+	profile := &schemaold.CaProfile{
+		CaPrefix:       caPrefix,
+		MaxValidPeriod: uint64(maxValidPeriod.Seconds()),
+		CryptoSuite:    []string{caSuite.String()},
+		KeySchedule:    utils.IdPtr(uint64(caKeySchedule)),
+	}
+
+	if caKeychain != nil {
+		profile.CaCert, err = caKeychain.Certificate()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return profile, nil
+}
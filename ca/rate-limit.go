@@ -0,0 +1,205 @@
+package ca
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Windows that saw no challenge for this long are forgotten on the next check.
+const rateLimitSweepInterval = time.Minute
+
+// RateLimit allows Count email challenges per sliding Window for each key. A zero Count disables it.
+type RateLimit struct {
+	Count  int
+	Window time.Duration
+}
+
+// RateLimits bound how often the CA emails one mailbox, one mail domain, or on behalf of one
+// requester key, so NEW and CHALLENGE cannot be used to flood someone's inbox.
+type RateLimits struct {
+	PerAddress RateLimit
+	PerDomain  RateLimit
+	PerKeyName RateLimit
+	// MaxOutstanding caps the email challenges waiting for a code across all requesters. Zero disables it.
+	MaxOutstanding int
+}
+
+var DefaultRateLimits = RateLimits{
+	PerAddress:     RateLimit{Count: 5, Window: time.Hour},
+	PerDomain:      RateLimit{Count: 200, Window: time.Hour},
+	PerKeyName:     RateLimit{Count: 5, Window: time.Hour},
+	MaxOutstanding: 1000,
+}
+
+type rateKey struct {
+	key   string
+	limit RateLimit
+}
+
+type rateWindow struct {
+	window time.Duration
+	times  []time.Time
+}
+
+// challengeReservation is a challenge counted against the rate limits before its email is
+// sent, and refunded if the email cannot be sent.
+type challengeReservation struct {
+	limiter *rateLimiter
+	keys    []rateKey
+	at      time.Time
+}
+
+type rateLimiter struct {
+	limits    RateLimits
+	mutex     sync.Mutex
+	windows   map[string]*rateWindow
+	nextSweep time.Time
+}
+
+var challengeLimiter = newRateLimiter(DefaultRateLimits)
+
+// SetRateLimits replaces the limits on email challenges and forgets the challenges counted so far.
+func SetRateLimits(limits RateLimits) error {
+	if err := limits.Validate(); err != nil {
+		return err
+	}
+	challengeLimiter = newRateLimiter(limits)
+	return nil
+}
+
+func (l RateLimits) Validate() error {
+	for name, limit := range map[string]RateLimit{"address": l.PerAddress, "domain": l.PerDomain, "key name": l.PerKeyName} {
+		if limit.Count < 0 || (limit.Count > 0 && limit.Window <= 0) {
+			return fmt.Errorf("invalid per %s rate limit of %d challenges per %s", name, limit.Count, limit.Window)
+		}
+	}
+	if l.MaxOutstanding < 0 {
+		return fmt.Errorf("invalid maximum of %d outstanding challenges", l.MaxOutstanding)
+	}
+	return nil
+}
+
+func newRateLimiter(limits RateLimits) *rateLimiter {
+	return &rateLimiter{limits: limits, windows: make(map[string]*rateWindow)}
+}
+
+// allow counts a challenge against every key, unless one of them is over its limit. Then
+// nothing is counted, and the key is returned with the time until it allows a challenge again.
+func (l *rateLimiter) allow(keys []rateKey, now time.Time) (string, time.Duration, bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.sweep(now)
+
+	for _, key := range keys {
+		if key.limit.Count == 0 {
+			continue
+		}
+		window := l.window(key, now)
+		if len(window.times) >= key.limit.Count {
+			return key.key, window.times[0].Add(key.limit.Window).Sub(now), false
+		}
+	}
+	for _, key := range keys {
+		if key.limit.Count == 0 {
+			continue
+		}
+		window := l.window(key, now)
+		window.times = append(window.times, now)
+	}
+	return "", 0, true
+}
+
+// refund uncounts a challenge counted by allow at the same time for keys.
+func (l *rateLimiter) refund(keys []rateKey, at time.Time) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for _, key := range keys {
+		window, ok := l.windows[key.key]
+		if key.limit.Count == 0 || !ok {
+			continue
+		}
+		for i := len(window.times) - 1; i >= 0; i-- {
+			if window.times[i].Equal(at) {
+				window.times = append(window.times[:i], window.times[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// refund gives the challenge back to the limits it was counted against.
+func (r challengeReservation) refund() {
+	if r.limiter != nil {
+		r.limiter.refund(r.keys, r.at)
+	}
+}
+
+// window returns the challenges counted against key within its window before now.
+func (l *rateLimiter) window(key rateKey, now time.Time) *rateWindow {
+	window, ok := l.windows[key.key]
+	if !ok {
+		window = &rateWindow{window: key.limit.Window}
+		l.windows[key.key] = window
+	}
+	expired := 0
+	for expired < len(window.times) && !window.times[expired].After(now.Add(-window.window)) {
+		expired++
+	}
+	window.times = window.times[expired:]
+	return window
+}
+
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Before(l.nextSweep) {
+		return
+	}
+	l.nextSweep = now.Add(rateLimitSweepInterval)
+	for key, window := range l.windows {
+		if len(window.times) == 0 || !window.times[len(window.times)-1].After(now.Add(-window.window)) {
+			delete(l.windows, key)
+		}
+	}
+}
+
+// outstandingChallenges counts the email challenges still waiting for a code, other than
+// the one of exclude.
+func outstandingChallenges(now time.Time, exclude *RequestState) int {
+	count := 0
+	for _, requestState := range storage {
+		if requestState != exclude && requestState.status == CaModuleChallenge && requestState.ChallengeState != nil &&
+			now.Before(requestState.ChallengeState.Snapshot().Expiry) {
+			count++
+		}
+	}
+	return count
+}
+
+// checkChallengeLimits counts an email challenge to address for requestState, or explains
+// why the CA will not send it now. The reservation must be refunded if the email is not sent.
+func checkChallengeLimits(requestState *RequestState, address string, now time.Time) (challengeReservation, *CaError) {
+	limiter := challengeLimiter
+	if limiter.limits.MaxOutstanding > 0 && outstandingChallenges(now, requestState) >= limiter.limits.MaxOutstanding {
+		return challengeReservation{}, newCaError(ErrorTooManyRequests, "too many email challenges are outstanding, retry later")
+	}
+
+	address = strings.ToLower(address)
+	domain := address[strings.LastIndex(address, "@")+1:]
+	certName := requestState.cert.Name()
+	keyName := certName
+	if len(certName) > 2 {
+		keyName = certName[:len(certName)-2]
+	}
+
+	keys := []rateKey{
+		{key: "address " + address, limit: limiter.limits.PerAddress},
+		{key: "domain " + domain, limit: limiter.limits.PerDomain},
+		{key: "key " + keyName.String(), limit: limiter.limits.PerKeyName},
+	}
+	key, wait, ok := limiter.allow(keys, now)
+	if !ok {
+		return challengeReservation{}, newCaError(ErrorTooManyRequests, "too many email challenges for %s, retry in %s", key, wait.Round(time.Second))
+	}
+	return challengeReservation{limiter: limiter, keys: keys, at: now}, nil
+}
@@ -0,0 +1,126 @@
+package ca
+
+import (
+	"ndn/ndncert/challenge/email"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterSlidingWindow(t *testing.T) {
+	limiter := newRateLimiter(RateLimits{})
+	start := time.Now()
+	keys := []rateKey{
+		{key: "address alice@ucla.edu", limit: RateLimit{Count: 2, Window: time.Hour}},
+		{key: "domain ucla.edu", limit: RateLimit{Count: 2, Window: time.Hour}},
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, _, ok := limiter.allow(keys, start.Add(time.Duration(i)*time.Minute)); !ok {
+			t.Fatalf("failed to allow challenge %d within the limit", i+1)
+		}
+	}
+	key, wait, ok := limiter.allow(keys, start.Add(2*time.Minute))
+	if ok || key != "address alice@ucla.edu" || wait != 58*time.Minute {
+		t.Errorf("failed to refuse a third challenge to the address until the first leaves the window, got %q %s", key, wait)
+	}
+	if len(limiter.windows["domain ucla.edu"].times) != 2 {
+		t.Error("failed to leave the other keys uncounted when refusing a challenge")
+	}
+
+	if _, _, ok := limiter.allow(keys, start.Add(time.Hour+time.Second)); !ok {
+		t.Error("failed to allow a challenge once the first left the window")
+	}
+	other := []rateKey{
+		{key: "address bob@ucla.edu", limit: RateLimit{Count: 2, Window: time.Hour}},
+		{key: "domain ucla.edu", limit: RateLimit{Count: 2, Window: time.Hour}},
+	}
+	if key, _, ok := limiter.allow(other, start.Add(time.Hour+2*time.Second)); ok || key != "domain ucla.edu" {
+		t.Errorf("failed to refuse a challenge over the domain limit, got %q", key)
+	}
+
+	limiter.allow(nil, start.Add(3*time.Hour))
+	if len(limiter.windows) != 0 {
+		t.Errorf("failed to forget windows without recent challenges, %d remain", len(limiter.windows))
+	}
+}
+
+func TestChallengeLimits(t *testing.T) {
+	if err := SetRateLimits(RateLimits{PerAddress: RateLimit{Count: 1}}); err == nil {
+		t.Error("failed to reject a rate limit without a window")
+	}
+	if err := SetRateLimits(RateLimits{
+		PerAddress:     RateLimit{Count: 1, Window: time.Hour},
+		PerKeyName:     RateLimit{Count: 2, Window: time.Hour},
+		MaxOutstanding: 1,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	defer SetRateLimits(DefaultRateLimits)
+
+	now := time.Now()
+	requestState := &RequestState{cert: makeCertRequest(t, "/ndn/user/name/KEY/1/self/1")}
+	if _, caErr := checkChallengeLimits(requestState, "Alice@UCLA.edu", now); caErr != nil {
+		t.Fatal(caErr)
+	}
+	_, caErr := checkChallengeLimits(requestState, "alice@ucla.edu", now)
+	if caErr == nil || caErr.Code != ErrorTooManyRequests || !strings.Contains(caErr.Info, "retry in 1h0m0s") {
+		t.Errorf("failed to refuse a second challenge to the same address, got %v", caErr)
+	}
+	if _, caErr := checkChallengeLimits(requestState, "bob@ucla.edu", now); caErr != nil {
+		t.Fatal(caErr)
+	}
+	if _, caErr := checkChallengeLimits(requestState, "carol@ucla.edu", now); caErr == nil || !strings.Contains(caErr.Info, "/ndn/user/name/KEY/1") {
+		t.Errorf("failed to refuse a third challenge for the same key, got %v", caErr)
+	}
+
+	var requestId [8]byte
+	copy(requestId[:], "outstand")
+	storage[requestId] = &RequestState{
+		cert:           makeCertRequest(t, "/ndn/user/outstanding/KEY/1/self/1"),
+		status:         CaModuleChallenge,
+		ChallengeState: &EmailChallengeState{ChallengeState: ChallengeState{Expiry: now.Add(time.Minute)}},
+	}
+	defer delete(storage, requestId)
+	otherRequest := &RequestState{cert: makeCertRequest(t, "/ndn/user/other/KEY/1/self/1")}
+	if _, caErr := checkChallengeLimits(otherRequest, "dave@ucla.edu", now); caErr == nil || caErr.Code != ErrorTooManyRequests {
+		t.Errorf("failed to refuse a challenge over the outstanding cap, got %v", caErr)
+	}
+	if _, caErr := checkChallengeLimits(otherRequest, "dave@ucla.edu", now.Add(2*time.Minute)); caErr != nil {
+		t.Errorf("failed to stop counting an expired challenge as outstanding, got %v", caErr)
+	}
+	if _, caErr := checkChallengeLimits(storage[requestId], "erin@ucla.edu", now); caErr != nil {
+		t.Errorf("failed to leave the request itself out of the outstanding challenges, got %v", caErr)
+	}
+}
+
+func TestChallengeLimitsRefund(t *testing.T) {
+	if err := SetRateLimits(RateLimits{PerAddress: RateLimit{Count: 1, Window: time.Hour}}); err != nil {
+		t.Fatal(err)
+	}
+	defer SetRateLimits(DefaultRateLimits)
+
+	now := time.Now()
+	requestState := &RequestState{cert: makeCertRequest(t, "/ndn/user/name/KEY/1/self/1")}
+	reservation, caErr := checkChallengeLimits(requestState, "alice@ucla.edu", now)
+	if caErr != nil {
+		t.Fatal(caErr)
+	}
+	reservation.refund()
+	if _, caErr := checkChallengeLimits(requestState, "alice@ucla.edu", now.Add(time.Second)); caErr != nil {
+		t.Errorf("failed to give back a challenge whose email was not sent, got %v", caErr)
+	}
+
+	profile := setupCaKeychain(t)
+	requestId, session := newRequest(t, profile, "/ndn/edu/ucla/refund/KEY/1/self/1")
+	params := map[string]string{emailParamKey: "refund@ucla.edu"}
+	SetMailer(nil)
+	if _, errorMsg := sendChallenge(t, profile, requestId, session, params); errorMsg == nil {
+		t.Fatal("failed to report that the challenge email could not be sent")
+	}
+	SetMailer(&email.MemoryMailer{From: "ca@ndn.example"})
+	defer SetMailer(nil)
+	if _, errorMsg := sendChallenge(t, profile, requestId, session, params); errorMsg != nil {
+		t.Errorf("failed to send the challenge after a failed email, got %s", errorMsg.ErrorInfo)
+	}
+}
@@ -0,0 +1,103 @@
+package ca
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math"
+	"math/big"
+	"regexp"
+	"strings"
+)
+
+// SecretCodeGenerator draws secret codes of Length symbols from Alphabet using crypto/rand.
+// Every symbol is chosen uniformly, so a code carries Length * log2(len(Alphabet)) bits.
+type SecretCodeGenerator struct {
+	Alphabet  []string
+	Length    int
+	Separator string
+}
+
+var (
+	DigitsAlphabet = strings.Split("0123456789", "")
+	// AlphanumericAlphabet leaves out 0, 1, I and O, which are easily confused when typed.
+	AlphanumericAlphabet = strings.Split("23456789ABCDEFGHJKLMNPQRSTUVWXYZ", "")
+)
+
+// MaxSecretCodeLength bounds the symbols of a code, far above what anyone will type and
+// well within the repetition limit of the expression returned by Pattern.
+const MaxSecretCodeLength = 64
+
+var defaultSecretCodeGenerator = SecretCodeGenerator{Alphabet: DigitsAlphabet, Length: 6}
+
+// NewWordListGenerator returns a generator of passphrases of length words from words, joined with "-".
+func NewWordListGenerator(words []string, length int) (SecretCodeGenerator, error) {
+	generator := SecretCodeGenerator{Alphabet: words, Length: length, Separator: "-"}
+	return generator, generator.Validate()
+}
+
+// SetSecretCodeGenerator changes the generator of the codes sent by new email challenges,
+// keeping the rest of the CA-wide challenge config and its overrides.
+func SetSecretCodeGenerator(generator SecretCodeGenerator) error {
+	config := challengeConfig
+	config.Code = generator
+	return SetChallengeConfig(config, challengeOverrides)
+}
+
+// Validate checks that the generator can produce codes that are unambiguous to parse back.
+func (g SecretCodeGenerator) Validate() error {
+	if g.Length < 1 || g.Length > MaxSecretCodeLength {
+		return fmt.Errorf("secret code length must be between 1 and %d, got %d", MaxSecretCodeLength, g.Length)
+	}
+	if len(g.Alphabet) < 2 {
+		return fmt.Errorf("secret code alphabet must have at least 2 symbols, got %d", len(g.Alphabet))
+	}
+
+	seen := make(map[string]bool, len(g.Alphabet))
+	for _, symbol := range g.Alphabet {
+		if symbol == "" || seen[symbol] {
+			return fmt.Errorf("secret code alphabet has an empty or duplicate symbol %q", symbol)
+		}
+		if g.Separator != "" && strings.Contains(symbol, g.Separator) {
+			return fmt.Errorf("secret code symbol %q contains the separator %q", symbol, g.Separator)
+		}
+		if g.Separator == "" && len([]rune(symbol)) != 1 {
+			return fmt.Errorf("secret code symbol %q must be a single character without a separator", symbol)
+		}
+		seen[symbol] = true
+	}
+	return nil
+}
+
+// Entropy returns the strength of a code in bits.
+func (g SecretCodeGenerator) Entropy() float64 {
+	return float64(g.Length) * math.Log2(float64(len(g.Alphabet)))
+}
+
+// Generate draws a new code. crypto/rand.Int rejects out of range samples instead of
+// reducing them modulo the alphabet size, so no symbol is more likely than another.
+func (g SecretCodeGenerator) Generate() (string, error) {
+	if err := g.Validate(); err != nil {
+		return "", err
+	}
+
+	alphabetSize := big.NewInt(int64(len(g.Alphabet)))
+	symbols := make([]string, g.Length)
+	for i := range symbols {
+		index, err := rand.Int(rand.Reader, alphabetSize)
+		if err != nil {
+			return "", fmt.Errorf("failed to draw a secret code: %w", err)
+		}
+		symbols[i] = g.Alphabet[index.Int64()]
+	}
+	return strings.Join(symbols, g.Separator), nil
+}
+
+// Pattern returns an expression that matches exactly the codes the generator can produce.
+func (g SecretCodeGenerator) Pattern() *regexp.Regexp {
+	quoted := make([]string, len(g.Alphabet))
+	for i, symbol := range g.Alphabet {
+		quoted[i] = regexp.QuoteMeta(symbol)
+	}
+	symbol := "(?:" + strings.Join(quoted, "|") + ")"
+	return regexp.MustCompile(fmt.Sprintf("^%s(?:%s%s){%d}$", symbol, regexp.QuoteMeta(g.Separator), symbol, g.Length-1))
+}
@@ -0,0 +1,103 @@
+package ca
+
+import (
+	"ndn/ndncert/challenge/email"
+	"strings"
+	"testing"
+)
+
+// chiSquare returns the statistic of counts against a uniform distribution over the alphabet.
+func chiSquare(counts map[string]int, alphabet []string, samples int) float64 {
+	expected := float64(samples) / float64(len(alphabet))
+	statistic := 0.0
+	for _, symbol := range alphabet {
+		diff := float64(counts[symbol]) - expected
+		statistic += diff * diff / expected
+	}
+	return statistic
+}
+
+func TestSecretCodeDistribution(t *testing.T) {
+	// The thresholds are the chi-square quantiles for p = 1e-6, so a correct generator
+	// fails about once in a million runs while a modulo bias of a few percent is caught.
+	tests := []struct {
+		name      string
+		generator SecretCodeGenerator
+		threshold float64
+	}{
+		{"digits", SecretCodeGenerator{Alphabet: DigitsAlphabet, Length: 6}, 46.0},
+		{"alphanumeric", SecretCodeGenerator{Alphabet: AlphanumericAlphabet, Length: 8}, 84.0},
+	}
+
+	for _, test := range tests {
+		const codes = 20000
+		counts := make(map[string]int)
+		firstSymbolCounts := make(map[string]int)
+		pattern := test.generator.Pattern()
+
+		for i := 0; i < codes; i++ {
+			code, err := test.generator.Generate()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !pattern.MatchString(code) {
+				t.Fatalf("%s: generated code %q does not match %s", test.name, code, pattern)
+			}
+			symbols := strings.Split(code, "")
+			firstSymbolCounts[symbols[0]]++
+			for _, symbol := range symbols {
+				counts[symbol]++
+			}
+		}
+
+		if statistic := chiSquare(counts, test.generator.Alphabet, codes*test.generator.Length); statistic > test.threshold {
+			t.Errorf("%s: symbols are not uniform, chi-square %.1f > %.1f", test.name, statistic, test.threshold)
+		}
+		if statistic := chiSquare(firstSymbolCounts, test.generator.Alphabet, codes); statistic > test.threshold {
+			t.Errorf("%s: first symbols are not uniform, chi-square %.1f > %.1f", test.name, statistic, test.threshold)
+		}
+	}
+}
+
+func TestWordListGenerator(t *testing.T) {
+	words := []string{"apple", "river", "stone", "cloud"}
+	generator, err := NewWordListGenerator(words, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if generator.Entropy() != 8 {
+		t.Errorf("failed to compute the entropy of the passphrases, got %f", generator.Entropy())
+	}
+
+	code, err := generator.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(strings.Split(code, "-")) != 4 || !generator.Pattern().MatchString(code) {
+		t.Errorf("failed to generate a passphrase of 4 words, got %q", code)
+	}
+	if generator.Pattern().MatchString("apple-river-stone") || generator.Pattern().MatchString("apple-river-stone-tree") {
+		t.Error("failed to reject passphrases that the generator cannot produce")
+	}
+	if _, _, err := email.NewCodeEmailWithPattern("alice@ucla.edu", code, generator.Pattern()); err != nil {
+		t.Errorf("failed to accept the passphrase in a code email: %s", err)
+	}
+
+	for _, invalid := range [][]string{{"apple"}, {"apple", "apple"}, {"apple", "big-river"}, {"apple", ""}} {
+		if _, err := NewWordListGenerator(invalid, 4); err == nil {
+			t.Errorf("failed to reject the word list %q", invalid)
+		}
+	}
+	for _, length := range []int{0, MaxSecretCodeLength + 1, 1002} {
+		if _, err := NewWordListGenerator(words, length); err == nil {
+			t.Errorf("failed to reject a passphrase of %d words", length)
+		}
+	}
+	longest, _ := NewWordListGenerator(words, MaxSecretCodeLength)
+	if code, err := longest.Generate(); err != nil || !longest.Pattern().MatchString(code) {
+		t.Errorf("failed to generate a passphrase of %d words: %v", MaxSecretCodeLength, err)
+	}
+	if err := SetSecretCodeGenerator(SecretCodeGenerator{Alphabet: DigitsAlphabet}); err == nil {
+		t.Error("failed to reject a generator with no length")
+	}
+}
@@ -0,0 +1,100 @@
+package ca
+
+import (
+	enc "github.com/zjkmxy/go-ndn/pkg/encoding"
+	"github.com/zjkmxy/go-ndn/pkg/ndn/spec_2022"
+	"time"
+)
+
+// Requesters' clocks are not expected to match ours exactly, so a NotBefore slightly in the past is accepted.
+const validityClockSkew = 2 * time.Minute
+
+type ValidityPolicy struct {
+	// Prefix selects the identities the policy applies to; the longest matching prefix wins.
+	Prefix enc.Name
+	// MaxValidity clamps the requested validity period; zero leaves it unclamped.
+	MaxValidity time.Duration
+	// DefaultValidity is used when the request carries no validity period; zero falls back to MaxValidPeriod.
+	DefaultValidity time.Duration
+}
+
+var maxValidPeriod = 10 * 24 * time.Hour
+var validityPolicies []ValidityPolicy
+
+// SetMaxValidPeriod sets the longest validity period the CA will accept, as advertised in its profile.
+func SetMaxValidPeriod(period time.Duration) {
+	maxValidPeriod = period
+}
+
+func SetValidityPolicies(policies []ValidityPolicy) {
+	validityPolicies = policies
+}
+
+func findValidityPolicy(identity enc.Name) *ValidityPolicy {
+	var match *ValidityPolicy
+	for i := range validityPolicies {
+		policy := &validityPolicies[i]
+		if policy.Prefix.IsPrefix(identity) && (match == nil || len(policy.Prefix) > len(match.Prefix)) {
+			match = policy
+		}
+	}
+	return match
+}
+
+func caCertificateValidity(now time.Time) (*time.Time, *time.Time) {
+	if caKeychain == nil {
+		return nil, nil
+	}
+	key, err := caKeychain.ActiveKey(now)
+	if err != nil {
+		return nil, nil
+	}
+	notBefore, notAfter, err := key.Validity()
+	if err != nil {
+		return nil, nil
+	}
+	return &notBefore, &notAfter
+}
+
+// resolveValidityPeriod checks the validity period requested for identity and returns the one to issue.
+func resolveValidityPeriod(identity enc.Name, requestedNotBefore *time.Time, requestedNotAfter *time.Time, now time.Time) (time.Time, time.Time, *CaError) {
+	policy := findValidityPolicy(identity)
+	caNotBefore, caNotAfter := caCertificateValidity(now)
+
+	var notBefore, notAfter time.Time
+	if requestedNotBefore == nil || requestedNotAfter == nil {
+		validity := maxValidPeriod
+		if policy != nil && policy.DefaultValidity != 0 {
+			validity = policy.DefaultValidity
+		}
+		notBefore, notAfter = now, now.Add(validity)
+		if caNotAfter != nil && notAfter.After(*caNotAfter) {
+			notAfter = *caNotAfter
+		}
+	} else {
+		notBefore, notAfter = *requestedNotBefore, *requestedNotAfter
+		if notBefore.Before(now.Add(-validityClockSkew)) {
+			return notBefore, notAfter, newCaError(ErrorBadValidityPeriod,
+				"validity period starts in the past at %s", notBefore.Format(spec_2022.TimeFmt))
+		}
+		if !notAfter.After(notBefore) {
+			return notBefore, notAfter, newCaError(ErrorBadValidityPeriod, "validity period ends before it starts")
+		}
+		if notAfter.Sub(notBefore) > maxValidPeriod {
+			return notBefore, notAfter, newCaError(ErrorBadValidityPeriod,
+				"validity period exceeds the maximum of %d seconds", uint64(maxValidPeriod.Seconds()))
+		}
+	}
+
+	if policy != nil && policy.MaxValidity != 0 && notAfter.Sub(notBefore) > policy.MaxValidity {
+		notAfter = notBefore.Add(policy.MaxValidity)
+	}
+
+	if caNotBefore != nil && (notBefore.Before(*caNotBefore) || notAfter.After(*caNotAfter)) {
+		return notBefore, notAfter, newCaError(ErrorBadValidityPeriod,
+			"validity period is outside the CA certificate lifetime %s to %s",
+			caNotBefore.Format(spec_2022.TimeFmt), caNotAfter.Format(spec_2022.TimeFmt))
+	}
+
+	return notBefore, notAfter, nil
+}
@@ -0,0 +1,64 @@
+package ca
+
+import (
+	enc "github.com/zjkmxy/go-ndn/pkg/encoding"
+	"testing"
+	"time"
+)
+
+func TestResolveValidityPeriod(t *testing.T) {
+	setupCaKeychain(t)
+	guestPrefix, _ := enc.NameFromStr("/ndn/guest")
+	SetValidityPolicies([]ValidityPolicy{{
+		Prefix:      guestPrefix,
+		MaxValidity: 10 * time.Minute,
+	}})
+	defer SetValidityPolicies(nil)
+
+	user, _ := enc.NameFromStr("/ndn/user/alice")
+	guest, _ := enc.NameFromStr("/ndn/guest/bob")
+	now := time.Now()
+
+	tests := []struct {
+		name         string
+		identity     enc.Name
+		notBefore    time.Time
+		notAfter     time.Time
+		wantError    bool
+		wantValidity time.Duration
+	}{
+		{"accepts window inside CA lifetime", user, now, now.Add(30 * time.Minute), false, 30 * time.Minute},
+		{"tolerates small clock skew", user, now.Add(-time.Minute), now.Add(30 * time.Minute), false, 31 * time.Minute},
+		{"rejects start in the past", user, now.Add(-time.Hour), now.Add(30 * time.Minute), true, 0},
+		{"rejects end before start", user, now.Add(30 * time.Minute), now, true, 0},
+		{"rejects window beyond CA lifetime", user, now, now.Add(2 * time.Hour), true, 0},
+		{"clamps per prefix policy", guest, now, now.Add(30 * time.Minute), false, 10 * time.Minute},
+	}
+
+	for _, test := range tests {
+		notBefore, notAfter, caErr := resolveValidityPeriod(test.identity, &test.notBefore, &test.notAfter, now)
+		if test.wantError {
+			if caErr == nil || caErr.Code != ErrorBadValidityPeriod {
+				t.Errorf("%s: failed to return BadValidityPeriod", test.name)
+			}
+			continue
+		}
+		if caErr != nil {
+			t.Errorf("%s: unexpected error %s", test.name, caErr)
+		} else if notAfter.Sub(notBefore) != test.wantValidity {
+			t.Errorf("%s: got validity %s, want %s", test.name, notAfter.Sub(notBefore), test.wantValidity)
+		}
+	}
+
+	SetMaxValidPeriod(20 * time.Minute)
+	defer SetMaxValidPeriod(10 * 24 * time.Hour)
+	notBefore, notAfter := now, now.Add(30*time.Minute)
+	if _, _, caErr := resolveValidityPeriod(user, &notBefore, &notAfter, now); caErr == nil {
+		t.Error("failed to reject window longer than MaxValidPeriod")
+	}
+
+	notBefore, notAfter, caErr := resolveValidityPeriod(user, nil, nil, now)
+	if caErr != nil || notAfter.Sub(notBefore) != 20*time.Minute {
+		t.Error("failed to default to MaxValidPeriod when no validity is requested")
+	}
+}
@@ -0,0 +1,51 @@
+package client
+
+import (
+	"fmt"
+	enc "github.com/zjkmxy/go-ndn/pkg/encoding"
+	"github.com/zjkmxy/go-ndn/pkg/ndn"
+	"github.com/zjkmxy/go-ndn/pkg/ndn/spec_2022"
+	"ndn/ndncert/challenge/crypto"
+	"ndn/ndncert/challenge/schemaold"
+	"time"
+)
+
+// ValidateData parses a CA response and checks that it was signed by the CA certificate in the profile.
+func ValidateData(profile *schemaold.CaProfile, raw enc.Wire) (ndn.Data, error) {
+	caCert, _, err := spec_2022.Spec{}.ReadData(enc.NewWireReader(profile.CaCert))
+	if err != nil {
+		return nil, fmt.Errorf("malformed CA certificate in profile: %w", err)
+	}
+
+	notBefore, notAfter := caCert.Signature().Validity()
+	now := time.Now()
+	if notBefore == nil || notAfter == nil || now.Before(*notBefore) || now.After(*notAfter) {
+		return nil, fmt.Errorf("CA certificate %s is not valid at %s", caCert.Name(), now)
+	}
+
+	caPublicKey, err := crypto.CertificatePublicKey(caCert)
+	if err != nil {
+		return nil, err
+	}
+
+	data, sigCovered, err := spec_2022.Spec{}.ReadData(enc.NewWireReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("malformed CA response: %w", err)
+	}
+
+	// The KeyLocator names either the CA key, the certificate name without its issuer and
+	// version, or the certificate itself. Any other prefix of the certificate name could be
+	// another key of the CA identity.
+	certName := caCert.Name()
+	keyName := data.Signature().KeyName()
+	if keyName == nil || len(certName) < 2 || !(keyName.Equal(certName[:len(certName)-2]) || keyName.Equal(certName)) {
+		return nil, fmt.Errorf("response %s is not signed by CA key %s", data.Name(), certName)
+	}
+
+	err = crypto.VerifySignature(caPublicKey, data.Signature().SigType(), sigCovered, data.Signature().SigValue())
+	if err != nil {
+		return nil, fmt.Errorf("bad signature on response %s: %w", data.Name(), err)
+	}
+
+	return data, nil
+}
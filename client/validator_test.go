@@ -0,0 +1,72 @@
+package client
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	enc "github.com/zjkmxy/go-ndn/pkg/encoding"
+	"github.com/zjkmxy/go-ndn/pkg/ndn"
+	"github.com/zjkmxy/go-ndn/pkg/ndn/spec_2022"
+	"github.com/zjkmxy/go-ndn/pkg/utils"
+	"ndn/ndncert/challenge/crypto"
+	"ndn/ndncert/challenge/schemaold"
+	"testing"
+	"time"
+)
+
+func mustName(t *testing.T, name string) enc.Name {
+	parsed, err := enc.NameFromStr(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed
+}
+
+func signResponse(t *testing.T, key *ecdsa.PrivateKey, keyLocator string) enc.Wire {
+	signer, err := crypto.NewSigner(mustName(t, keyLocator), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wire, _, err := spec_2022.Spec{}.MakeData(mustName(t, "/ndn/CA/NEW"), &ndn.DataConfig{}, enc.Wire{[]byte("response")}, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return wire
+}
+
+func TestValidateDataKeyLocator(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey, _ := x509.MarshalPKIXPublicKey(key.Public())
+	signer, _ := crypto.NewSigner(mustName(t, "/ndn/KEY/%01"), key)
+	certWire, _, err := spec_2022.Spec{}.MakeData(
+		mustName(t, "/ndn/KEY/%01/self/v=1"),
+		&ndn.DataConfig{ContentType: utils.IdPtr(ndn.ContentTypeKey)},
+		enc.Wire{publicKey},
+		crypto.WithValidity(signer, time.Now().Add(-time.Hour), time.Now().Add(time.Hour)),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile := &schemaold.CaProfile{CaCert: certWire}
+
+	for keyLocator, valid := range map[string]bool{
+		"/ndn/KEY/%01":              true,
+		"/ndn/KEY/%01/self/v=1":     true,
+		"/ndn":                      false,
+		"/ndn/KEY":                  false,
+		"/ndn/KEY/%01/self":         false,
+		"/ndn/KEY/%02":              false,
+		"/ndn/KEY/%01/self/v=1/foo": false,
+	} {
+		_, err := ValidateData(profile, signResponse(t, key, keyLocator))
+		if valid && err != nil {
+			t.Errorf("failed to accept a response with KeyLocator %s: %v", keyLocator, err)
+		} else if !valid && err == nil {
+			t.Errorf("failed to reject a response with KeyLocator %s", keyLocator)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+// Command ndncert-ca manages the configuration of an NDNCERT CA.
+//
+// Usage:
+//
+//	ndncert-ca validate-config [-config path]
+//
+// The configuration file is taken from -config, or else from the NDNCERT_CA_CONFIG environment variable.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"ndn/ndncert/challenge/config"
+	"os"
+)
+
+const usage = "usage: ndncert-ca validate-config [-config path]\n"
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdout io.Writer, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+	switch args[0] {
+	case "validate-config":
+		return validateConfig(args[1:], stdout, stderr)
+	default:
+		fmt.Fprintf(stderr, "unknown command %q\n%s", args[0], usage)
+		return 2
+	}
+}
+
+// validateConfig checks a configuration file without applying it, and prints every problem found.
+func validateConfig(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("validate-config", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	configPath := flags.String("config", "", "path of the CA configuration file (default $"+config.EnvPath+")")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	path, err := config.Path(*configPath)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	if _, err := config.Load(path); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	fmt.Fprintf(stdout, "%s is valid\n", path)
+	return 0
+}
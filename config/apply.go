@@ -0,0 +1,161 @@
+package config
+
+import (
+	"fmt"
+	"ndn/ndncert/challenge/ca"
+	"ndn/ndncert/challenge/crypto"
+	"ndn/ndncert/challenge/email"
+	"ndn/ndncert/challenge/keychain"
+	"os"
+	"time"
+)
+
+// Apply configures the ca package with a validated configuration: it opens the keychain,
+// which must hold a key valid now, the issuance log and the mailer, serves the magic links,
+// and sets every policy and limit.
+func (c *Config) Apply() error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+
+	if err := ca.SetPrefix(c.Prefix); err != nil {
+		return err
+	}
+	prefix, _ := parsePrefix(c.Prefix)
+	kc, err := keychain.Open(c.Keys.Keychain, prefix)
+	if err != nil {
+		return fmt.Errorf("keys.keychain: %w", err)
+	}
+	if _, err := kc.ActiveKey(time.Now()); err != nil {
+		return fmt.Errorf("keys.keychain: %w", err)
+	}
+	ca.SetKeychain(kc)
+	suite, _ := crypto.ParseSuite(c.Keys.CryptoSuite)
+	if err := ca.SetCryptoSuite(suite); err != nil {
+		return err
+	}
+	if err := ca.SetKeySchedule(keySchedules[c.Keys.KeySchedule]); err != nil {
+		return err
+	}
+
+	if err := ca.SetChallenges(c.Challenges.Enabled); err != nil {
+		return err
+	}
+	if err := c.applyEmailChallenge(); err != nil {
+		return err
+	}
+
+	ca.SetMaxValidPeriod(c.Policies.MaxValidity)
+	var validityPolicies []ca.ValidityPolicy
+	for _, policy := range c.Policies.Validity {
+		policyPrefix, _ := parsePrefix(policy.Prefix)
+		validityPolicies = append(validityPolicies, ca.ValidityPolicy{
+			Prefix:          policyPrefix,
+			MaxValidity:     policy.MaxValidity,
+			DefaultValidity: policy.DefaultValidity,
+		})
+	}
+	ca.SetValidityPolicies(validityPolicies)
+	var namePolicies []ca.NamePolicy
+	for _, policy := range c.Policies.Names {
+		namePolicy, _ := policy.namePolicy()
+		namePolicies = append(namePolicies, namePolicy)
+	}
+	ca.SetNamePolicies(namePolicies)
+	addressPolicy, _ := c.Policies.Addresses.addressPolicy()
+	if err := ca.SetAddressPolicy(addressPolicy); err != nil {
+		return err
+	}
+
+	if err := ca.SetRateLimits(ca.RateLimits{
+		PerAddress:     c.Limits.PerAddress.rateLimit(),
+		PerDomain:      c.Limits.PerDomain.rateLimit(),
+		PerKeyName:     c.Limits.PerKeyName.rateLimit(),
+		MaxOutstanding: c.Limits.MaxOutstanding,
+	}); err != nil {
+		return err
+	}
+
+	if c.Storage.IssuanceLog != "" {
+		log, err := os.OpenFile(c.Storage.IssuanceLog, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			return fmt.Errorf("storage.issuance_log: %w", err)
+		}
+		ca.SetIssuanceLog(log)
+	}
+	return c.applyMail()
+}
+
+func (c *Config) applyEmailChallenge() error {
+	config := c.Challenges.Email
+	generator, _ := config.Code.generator(ca.SecretCodeGenerator{})
+	var overrides []ca.ChallengeOverride
+	for _, override := range config.Overrides {
+		overridePrefix, _ := parsePrefix(override.Prefix)
+		code, _ := override.Code.generator(generator)
+		overrides = append(overrides, ca.ChallengeOverride{
+			Prefix:      overridePrefix,
+			MaxAttempts: override.MaxAttempts,
+			Lifetime:    override.Lifetime,
+			Code:        code,
+		})
+	}
+	if err := ca.SetChallengeConfig(ca.ChallengeConfig{
+		MaxAttempts: config.MaxAttempts,
+		Lifetime:    config.Lifetime,
+		Code:        generator,
+	}, overrides); err != nil {
+		return err
+	}
+	ca.SetResendPolicy(ca.ResendPolicy{
+		MaxResends:   config.Resend.MaxResends,
+		MinInterval:  config.Resend.MinInterval,
+		ExtendExpiry: config.Resend.ExtendExpiry,
+	})
+	if err := ca.SetMagicLinkURL(config.MagicLinkURL); err != nil {
+		return err
+	}
+	if err := ca.ServeMagicLinks(config.MagicLinkListen); err != nil {
+		return fmt.Errorf("challenges.email.magic_link_listen: %w", err)
+	}
+	return nil
+}
+
+func (c *Config) applyMail() error {
+	if c.Mail.Templates != "" {
+		templates, err := email.LoadTemplates(c.Mail.Templates, c.Mail.Locale)
+		if err != nil {
+			return fmt.Errorf("mail.templates: %w", err)
+		}
+		ca.SetEmailTemplates(templates)
+	}
+	if !c.emailEnabled() {
+		return nil
+	}
+
+	mailer, err := email.NewSMTPMailer(c.Mail.SMTPAuth)
+	if err != nil {
+		return fmt.Errorf("mail.smtp: %w", err)
+	}
+	// A challenge email is useless once its code has expired, so it is not sent after the
+	// longest challenge lifetime.
+	maxAge := c.Challenges.Email.Lifetime
+	for _, override := range c.Challenges.Email.Overrides {
+		if override.Lifetime > maxAge {
+			maxAge = override.Lifetime
+		}
+	}
+	queue, err := email.NewQueuedMailer(mailer, email.QueueConfig{
+		Workers:        c.Mail.Queue.Workers,
+		Capacity:       c.Mail.Queue.Capacity,
+		MaxAttempts:    c.Mail.Queue.MaxAttempts,
+		InitialBackoff: c.Mail.Queue.InitialBackoff,
+		MaxBackoff:     c.Mail.Queue.MaxBackoff,
+		MaxAge:         maxAge,
+	})
+	if err != nil {
+		return fmt.Errorf("mail: %w", err)
+	}
+	ca.SetMailer(queue)
+	return nil
+}
@@ -0,0 +1,64 @@
+package config
+
+import (
+	enc "github.com/zjkmxy/go-ndn/pkg/encoding"
+	"ndn/ndncert/challenge/ca"
+	"ndn/ndncert/challenge/keychain"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestApply(t *testing.T) {
+	dir := t.TempDir()
+	config := Default()
+	config.Prefix = "/ndn/test"
+	config.Keys.Keychain = filepath.Join(dir, "keys")
+	config.Keys.CryptoSuite = "X25519/ChaCha20-Poly1305"
+	config.Policies.MaxValidity = 48 * time.Hour
+	config.Storage.IssuanceLog = filepath.Join(dir, "issued.jsonl")
+	config.Mail.Smtp.Host, config.Mail.Smtp.Identity = "smtp.ucla.edu", "ca@ucla.edu"
+	config.Challenges.Email.MagicLinkURL = "https://ca.ucla.edu/confirm"
+	config.Challenges.Email.MagicLinkListen = "127.0.0.1:0"
+	if err := config.Apply(); err == nil {
+		t.Fatal("failed to refuse a keychain without a valid key")
+	}
+	defer ca.SetPrefix("/ndn")
+	defer ca.SetMagicLinkURL("")
+	defer ca.ServeMagicLinks("")
+
+	prefix, _ := enc.NameFromStr(config.Prefix)
+	kc, err := keychain.Open(config.Keys.Keychain, prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kc.ScheduleRollover(keychain.KeyTypeEcdsa, time.Now().Add(-time.Hour), time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.Apply(); err != nil {
+		t.Fatal(err)
+	}
+
+	profile, err := ca.Profile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile.CaPrefix.String() != "/ndn/test" || profile.CryptoSuite[0] != "X25519/ChaCha20-Poly1305" ||
+		profile.MaxValidPeriod != uint64((48*time.Hour).Seconds()) {
+		t.Errorf("failed to apply the configuration to the CA profile, got %+v", profile)
+	}
+	if ca.MagicLinkAddr() == nil {
+		t.Error("failed to serve the magic links")
+	}
+	for _, path := range []string{config.Keys.Keychain, config.Storage.IssuanceLog} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("failed to open %s: %v", path, err)
+		}
+	}
+
+	config.Prefix = ""
+	if err := config.Apply(); err == nil {
+		t.Error("failed to refuse applying an invalid configuration")
+	}
+}
@@ -0,0 +1,255 @@
+// Package config reads the configuration file of a CA, and applies it to the ca package.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io"
+	"ndn/ndncert/challenge/ca"
+	"ndn/ndncert/challenge/crypto"
+	"ndn/ndncert/challenge/email"
+	"os"
+	"time"
+)
+
+// EnvPath is the environment variable naming the configuration file when no path is given.
+const EnvPath = "NDNCERT_CA_CONFIG"
+
+// Config is the configuration file of a CA. Fields left out of the file keep the values of Default.
+type Config struct {
+	// Prefix is the name prefix of the CA, e.g. /ndn.
+	Prefix     string           `yaml:"prefix"`
+	Keys       KeysConfig       `yaml:"keys"`
+	Challenges ChallengesConfig `yaml:"challenges"`
+	Policies   PoliciesConfig   `yaml:"policies"`
+	Storage    StorageConfig    `yaml:"storage"`
+	Mail       MailConfig       `yaml:"mail"`
+	Limits     LimitsConfig     `yaml:"limits"`
+}
+
+type KeysConfig struct {
+	// Keychain is the directory of the CA keys and certificates.
+	Keychain string `yaml:"keychain"`
+	// CryptoSuite is "<curve>/<cipher>", e.g. P-256/AES-128-GCM.
+	CryptoSuite string `yaml:"crypto_suite"`
+	// KeySchedule is "compat" or "directional".
+	KeySchedule string `yaml:"key_schedule"`
+}
+
+type ChallengesConfig struct {
+	// Enabled are the challenges offered to requesters.
+	Enabled []string             `yaml:"enabled"`
+	Email   EmailChallengeConfig `yaml:"email"`
+}
+
+type EmailChallengeConfig struct {
+	MaxAttempts uint          `yaml:"max_attempts"`
+	Lifetime    time.Duration `yaml:"lifetime"`
+	Code        CodeConfig    `yaml:"code"`
+	Resend      ResendConfig  `yaml:"resend"`
+	// MagicLinkURL makes the emails carry a confirmation link to this URL when set.
+	MagicLinkURL string `yaml:"magic_link_url"`
+	// MagicLinkListen is the host:port the links are served on, which MagicLinkURL must reach.
+	MagicLinkListen string `yaml:"magic_link_listen"`
+	// Overrides change the challenge for the identities under their prefix.
+	Overrides []ChallengeOverrideConfig `yaml:"overrides"`
+}
+
+type CodeConfig struct {
+	// Alphabet is "digits", "alphanumeric" or "words", the last drawing from Words.
+	Alphabet string   `yaml:"alphabet"`
+	Words    []string `yaml:"words"`
+	// Length is the number of symbols of a code, at most ca.MaxSecretCodeLength.
+	Length int `yaml:"length"`
+}
+
+type ResendConfig struct {
+	MaxResends   uint          `yaml:"max_resends"`
+	MinInterval  time.Duration `yaml:"min_interval"`
+	ExtendExpiry bool          `yaml:"extend_expiry"`
+}
+
+// ChallengeOverrideConfig leaves the challenge settings it does not set as they are CA-wide.
+type ChallengeOverrideConfig struct {
+	Prefix      string        `yaml:"prefix"`
+	MaxAttempts uint          `yaml:"max_attempts"`
+	Lifetime    time.Duration `yaml:"lifetime"`
+	Code        CodeConfig    `yaml:"code"`
+}
+
+type PoliciesConfig struct {
+	MaxValidity time.Duration    `yaml:"max_validity"`
+	Validity    []ValidityConfig `yaml:"validity"`
+	// Names bind identities to verified emails under the CA prefix when empty.
+	Names     []NamePolicyConfig  `yaml:"names"`
+	Addresses AddressPolicyConfig `yaml:"addresses"`
+}
+
+type ValidityConfig struct {
+	Prefix          string        `yaml:"prefix"`
+	MaxValidity     time.Duration `yaml:"max_validity"`
+	DefaultValidity time.Duration `yaml:"default_validity"`
+}
+
+type NamePolicyConfig struct {
+	// Type is "email", "random-suffix" or "allowlist".
+	Type         string `yaml:"type"`
+	Prefix       string `yaml:"prefix"`
+	SuffixLength int    `yaml:"suffix_length"`
+	// Entries are the names of an allowlist, each with the email addresses that may obtain it.
+	Entries []AllowlistEntryConfig `yaml:"entries"`
+}
+
+type AllowlistEntryConfig struct {
+	Name   string   `yaml:"name"`
+	Emails []string `yaml:"emails"`
+}
+
+type AddressPolicyConfig struct {
+	AllowedDomains   []string `yaml:"allowed_domains"`
+	DeniedDomains    []string `yaml:"denied_domains"`
+	AllowDisplayName bool     `yaml:"allow_display_name"`
+	// Subaddress is "keep", "reject" or "strip".
+	Subaddress string `yaml:"subaddress"`
+}
+
+type StorageConfig struct {
+	// IssuanceLog is the file every issued certificate is appended to, as a line of JSON.
+	IssuanceLog string `yaml:"issuance_log"`
+}
+
+type MailConfig struct {
+	email.SMTPAuth `yaml:",inline"`
+	// Templates is a directory of templates per locale, replacing the built-in ones.
+	Templates string `yaml:"templates"`
+	// Locale is the locale of Templates used for requesters who ask for none that exists.
+	Locale string      `yaml:"locale"`
+	Queue  QueueConfig `yaml:"queue"`
+}
+
+type QueueConfig struct {
+	Workers        int           `yaml:"workers"`
+	Capacity       int           `yaml:"capacity"`
+	MaxAttempts    int           `yaml:"max_attempts"`
+	InitialBackoff time.Duration `yaml:"initial_backoff"`
+	MaxBackoff     time.Duration `yaml:"max_backoff"`
+}
+
+type LimitsConfig struct {
+	PerAddress     RateLimitConfig `yaml:"per_address"`
+	PerDomain      RateLimitConfig `yaml:"per_domain"`
+	PerKeyName     RateLimitConfig `yaml:"per_key_name"`
+	MaxOutstanding int             `yaml:"max_outstanding"`
+}
+
+type RateLimitConfig struct {
+	Count  int           `yaml:"count"`
+	Window time.Duration `yaml:"window"`
+}
+
+const (
+	alphabetDigits       = "digits"
+	alphabetAlphanumeric = "alphanumeric"
+	alphabetWords        = "words"
+)
+
+var keySchedules = map[string]crypto.KeySchedule{
+	"compat":      crypto.KeyScheduleCompat,
+	"directional": crypto.KeyScheduleDirectional,
+}
+
+var subaddressPolicies = map[string]email.SubaddressPolicy{
+	"keep":   email.SubaddressKeep,
+	"reject": email.SubaddressReject,
+	"strip":  email.SubaddressStrip,
+}
+
+// Default returns the configuration of a CA under /ndn that offers the email challenge with
+// the defaults of the ca package. Only the keychain and the SMTP server have to be added.
+func Default() Config {
+	challenge := ca.DefaultChallengeConfig
+	resend := ca.DefaultResendPolicy
+	limits := ca.DefaultRateLimits
+	mail := MailConfig{Locale: "en"}
+	mail.Smtp.Port = 587
+	return Config{
+		Prefix: "/ndn",
+		Keys: KeysConfig{
+			CryptoSuite: crypto.DefaultSuite.String(),
+			KeySchedule: "compat",
+		},
+		Challenges: ChallengesConfig{
+			Enabled: []string{"email"},
+			Email: EmailChallengeConfig{
+				MaxAttempts: challenge.MaxAttempts,
+				Lifetime:    challenge.Lifetime,
+				Code:        CodeConfig{Alphabet: alphabetDigits, Length: challenge.Code.Length},
+				Resend: ResendConfig{
+					MaxResends:   resend.MaxResends,
+					MinInterval:  resend.MinInterval,
+					ExtendExpiry: resend.ExtendExpiry,
+				},
+			},
+		},
+		Policies: PoliciesConfig{
+			MaxValidity: 10 * 24 * time.Hour,
+			Addresses:   AddressPolicyConfig{Subaddress: "keep"},
+		},
+		Mail: mail,
+		Limits: LimitsConfig{
+			PerAddress:     RateLimitConfig{limits.PerAddress.Count, limits.PerAddress.Window},
+			PerDomain:      RateLimitConfig{limits.PerDomain.Count, limits.PerDomain.Window},
+			PerKeyName:     RateLimitConfig{limits.PerKeyName.Count, limits.PerKeyName.Window},
+			MaxOutstanding: limits.MaxOutstanding,
+		},
+	}
+}
+
+// Path returns explicit when it is set, and otherwise the path in EnvPath.
+func Path(explicit string) (string, error) {
+	if explicit != "" {
+		return explicit, nil
+	}
+	if path := os.Getenv(EnvPath); path != "" {
+		return path, nil
+	}
+	return "", fmt.Errorf("no CA configuration file given, and %s is not set", EnvPath)
+}
+
+// Load reads and validates the configuration file at path.
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("in file %q: %w", path, err)
+	}
+	return config, nil
+}
+
+// Parse decodes and validates a configuration. Unknown fields are errors, so a misspelled
+// field is not silently left at its default.
+func Parse(data []byte) (*Config, error) {
+	config := Default()
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&config); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+	if errs := config.validate(); len(errs) > 0 {
+		for _, fieldErr := range errs {
+			fieldErr.Line = lineOf(&root, fieldErr.Field)
+		}
+		return nil, errs
+	}
+	return &config, nil
+}
@@ -0,0 +1,81 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadSample(t *testing.T) {
+	config, err := Load("sample_ca.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Keys.Keychain != "/var/lib/ndncert/keys" || config.Mail.Smtp.Host != "smtp_host_address" {
+		t.Errorf("failed to read the sample configuration, got %+v", config)
+	}
+	override := config.Challenges.Email.Overrides[0]
+	if override.Prefix != "/ndn/guest" || override.Lifetime != 2*time.Minute || override.Code.Length != 8 {
+		t.Errorf("failed to read the challenge override, got %+v", override)
+	}
+}
+
+func TestParseDefaults(t *testing.T) {
+	config, err := Parse([]byte("keys:\n  keychain: /tmp/keys\nmail:\n  smtp:\n    host: smtp.ucla.edu\n    identity: ca@ucla.edu\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Default()
+	expected.Keys.Keychain = "/tmp/keys"
+	expected.Mail.Smtp.Host = "smtp.ucla.edu"
+	expected.Mail.Smtp.Identity = "ca@ucla.edu"
+	if !reflect.DeepEqual(*config, expected) {
+		t.Errorf("failed to keep the defaults of the fields left out, got %+v", config)
+	}
+
+	config, err = Parse([]byte("keys:\n  keychain: /tmp/keys\nchallenges:\n  email:\n    resend:\n      extend_expiry: false\nmail:\n  smtp:\n    host: h\n    identity: i\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Challenges.Email.Resend.ExtendExpiry || config.Challenges.Email.Resend.MaxResends != 3 {
+		t.Errorf("failed to override one default among its siblings, got %+v", config.Challenges.Email.Resend)
+	}
+}
+
+func TestParseStrict(t *testing.T) {
+	for _, document := range []string{
+		"keys:\n  keychian: /tmp/keys\n",
+		"mail:\n  smtp:\n    hots: smtp.ucla.edu\n",
+		"limits:\n  per_address:\n    count: many\n",
+		"challenges:\n  email:\n    lifetime: 5 minutes\n",
+	} {
+		if _, err := Parse([]byte(document)); err == nil || !strings.Contains(err.Error(), "line ") {
+			t.Errorf("failed to reject %q with its line, got %v", document, err)
+		}
+	}
+}
+
+func TestPath(t *testing.T) {
+	t.Setenv(EnvPath, "")
+	if _, err := Path(""); err == nil {
+		t.Error("failed to require a configuration path")
+	}
+	t.Setenv(EnvPath, "/etc/ndncert/ca.yml")
+	if path, _ := Path(""); path != "/etc/ndncert/ca.yml" {
+		t.Errorf("failed to take the path from %s, got %q", EnvPath, path)
+	}
+	if path, _ := Path("ca.yml"); path != "ca.yml" {
+		t.Errorf("failed to prefer the explicit path, got %q", path)
+	}
+
+	path := filepath.Join(t.TempDir(), "ca.yml")
+	if err := os.WriteFile(path, []byte("prefix: \"\"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path); err == nil || !strings.Contains(err.Error(), path) {
+		t.Errorf("failed to name the file of an invalid configuration, got %v", err)
+	}
+}
@@ -0,0 +1,364 @@
+package config
+
+import (
+	"fmt"
+	enc "github.com/zjkmxy/go-ndn/pkg/encoding"
+	"gopkg.in/yaml.v3"
+	"ndn/ndncert/challenge/ca"
+	"ndn/ndncert/challenge/crypto"
+	"ndn/ndncert/challenge/email"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FieldError is a problem with one field of a configuration, named by its path in the file,
+// e.g. challenges.email.overrides[1].lifetime.
+type FieldError struct {
+	Field string
+	// Line is the line of the field in the file, or 0 when the field is left to its default.
+	Line int
+	Err  error
+}
+
+func (e *FieldError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("line %d: %s: %s", e.Line, e.Field, e.Err)
+	}
+	return fmt.Sprintf("%s: %s", e.Field, e.Err)
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// ValidationErrors are all the problems found in a configuration.
+type ValidationErrors []*FieldError
+
+func (errs ValidationErrors) Error() string {
+	messages := make([]string, len(errs))
+	for i, err := range errs {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "\n")
+}
+
+type validator struct {
+	errs ValidationErrors
+}
+
+func (v *validator) check(field string, err error) {
+	if err != nil {
+		v.errs = append(v.errs, &FieldError{Field: field, Err: err})
+	}
+}
+
+func (v *validator) fail(field string, format string, args ...any) {
+	v.check(field, fmt.Errorf(format, args...))
+}
+
+// Validate checks every field of the configuration, and returns ValidationErrors when
+// some are wrong.
+func (c *Config) Validate() error {
+	if errs := c.validate(); len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func (c *Config) validate() ValidationErrors {
+	v := &validator{}
+
+	prefix, err := parsePrefix(c.Prefix)
+	v.check("prefix", err)
+
+	if c.Keys.Keychain == "" {
+		v.fail("keys.keychain", "the directory of the CA keys is required")
+	}
+	_, err = crypto.ParseSuite(c.Keys.CryptoSuite)
+	v.check("keys.crypto_suite", err)
+	if _, ok := keySchedules[c.Keys.KeySchedule]; !ok {
+		v.fail("keys.key_schedule", "unknown key schedule %q, expected compat or directional", c.Keys.KeySchedule)
+	}
+
+	if len(c.Challenges.Enabled) == 0 {
+		v.fail("challenges.enabled", "at least one challenge must be enabled")
+	}
+	for i, challenge := range c.Challenges.Enabled {
+		if !ca.IsChallengeSupported(challenge) {
+			v.fail(fmt.Sprintf("challenges.enabled[%d]", i), "unsupported challenge %q", challenge)
+		}
+	}
+	c.validateEmailChallenge(v, prefix)
+
+	if c.Policies.MaxValidity <= 0 {
+		v.fail("policies.max_validity", "must be positive, got %s", c.Policies.MaxValidity)
+	}
+	for i, policy := range c.Policies.Validity {
+		field := fmt.Sprintf("policies.validity[%d]", i)
+		v.check(field+".prefix", checkUnder(prefix, policy.Prefix))
+		if policy.MaxValidity < 0 || policy.DefaultValidity < 0 {
+			v.fail(field, "validity periods must not be negative")
+		} else if policy.MaxValidity > 0 && policy.DefaultValidity > policy.MaxValidity {
+			v.fail(field+".default_validity", "%s is longer than max_validity %s", policy.DefaultValidity, policy.MaxValidity)
+		}
+	}
+	for i, policy := range c.Policies.Names {
+		_, err := policy.namePolicy()
+		v.check(fmt.Sprintf("policies.names[%d]", i), err)
+	}
+	_, err = c.Policies.Addresses.addressPolicy()
+	v.check("policies.addresses", err)
+
+	if c.emailEnabled() {
+		smtp := c.Mail.Smtp
+		if smtp.Host == "" {
+			v.fail("mail.smtp.host", "the SMTP server is required by the email challenge")
+		}
+		if smtp.Identity == "" {
+			v.fail("mail.smtp.identity", "the sender address is required by the email challenge")
+		}
+		if smtp.Port < 1 || smtp.Port > 65535 {
+			v.fail("mail.smtp.port", "invalid port %d", smtp.Port)
+		}
+		switch smtp.TLS {
+		case "", email.TLSOpportunistic, email.TLSStartTLS, email.TLSImplicit:
+		default:
+			v.fail("mail.smtp.tls", "unknown TLS mode %q", smtp.TLS)
+		}
+		switch smtp.Auth {
+		case "", email.AuthPlain, email.AuthLogin, email.AuthCramMD5, email.AuthNone:
+		default:
+			v.fail("mail.smtp.auth", "unknown authentication mechanism %q", smtp.Auth)
+		}
+		if dkim := smtp.DKIM; dkim.PrivateKey != "" && (dkim.Domain == "" || dkim.Selector == "") {
+			v.fail("mail.smtp.dkim", "a domain and a selector are required to sign with DKIM")
+		}
+	}
+	if c.Mail.Templates != "" && c.Mail.Locale == "" {
+		v.fail("mail.locale", "the fallback locale of the templates is required")
+	}
+	queue := c.Mail.Queue
+	if queue.Workers < 0 || queue.Capacity < 0 || queue.MaxAttempts < 0 || queue.InitialBackoff < 0 || queue.MaxBackoff < 0 {
+		v.fail("mail.queue", "queue settings must not be negative")
+	}
+
+	limits := c.Limits
+	for field, limit := range map[string]RateLimitConfig{
+		"limits.per_address":  limits.PerAddress,
+		"limits.per_domain":   limits.PerDomain,
+		"limits.per_key_name": limits.PerKeyName,
+	} {
+		if limit.Count < 0 || (limit.Count > 0 && limit.Window <= 0) {
+			v.fail(field, "invalid rate limit of %d challenges per %s", limit.Count, limit.Window)
+		}
+	}
+	if limits.MaxOutstanding < 0 {
+		v.fail("limits.max_outstanding", "must not be negative, got %d", limits.MaxOutstanding)
+	}
+	return v.errs
+}
+
+func (c *Config) validateEmailChallenge(v *validator, prefix enc.Name) {
+	config := c.Challenges.Email
+	if config.MaxAttempts < 1 {
+		v.fail("challenges.email.max_attempts", "at least one attempt must be allowed")
+	}
+	if config.Lifetime < time.Second {
+		v.fail("challenges.email.lifetime", "must be at least a second, got %s", config.Lifetime)
+	}
+	generator, err := config.Code.generator(ca.SecretCodeGenerator{})
+	v.check("challenges.email.code", err)
+	if config.MagicLinkURL != "" {
+		parsed, err := url.Parse(config.MagicLinkURL)
+		if err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" {
+			v.fail("challenges.email.magic_link_url", "%q is not an absolute HTTP URL", config.MagicLinkURL)
+		}
+		if config.MagicLinkListen == "" {
+			v.fail("challenges.email.magic_link_url", "nothing serves the links, magic_link_listen must be set")
+		}
+	}
+	if config.MagicLinkListen != "" {
+		if _, _, err := net.SplitHostPort(config.MagicLinkListen); err != nil {
+			v.check("challenges.email.magic_link_listen", err)
+		} else if config.MagicLinkURL == "" {
+			v.fail("challenges.email.magic_link_listen", "links are disabled, magic_link_url must be set")
+		}
+	}
+
+	seen := make(map[string]bool)
+	base := ca.ChallengeConfig{MaxAttempts: config.MaxAttempts, Lifetime: config.Lifetime, Code: generator}
+	for i, override := range config.Overrides {
+		field := fmt.Sprintf("challenges.email.overrides[%d]", i)
+		if err := checkUnder(prefix, override.Prefix); err != nil {
+			v.check(field+".prefix", err)
+		} else if seen[override.Prefix] {
+			v.fail(field+".prefix", "duplicate override for %s", override.Prefix)
+		}
+		seen[override.Prefix] = true
+		if override.Lifetime != 0 && override.Lifetime < time.Second {
+			v.fail(field+".lifetime", "must be at least a second, got %s", override.Lifetime)
+		}
+		code, err := override.Code.generator(generator)
+		if err == nil && generator.Validate() == nil {
+			err = base.WithOverride(ca.ChallengeOverride{Code: code}).Code.Validate()
+		}
+		v.check(field+".code", err)
+	}
+}
+
+func (c *Config) emailEnabled() bool {
+	for _, challenge := range c.Challenges.Enabled {
+		if challenge == "email" {
+			return true
+		}
+	}
+	return false
+}
+
+// generator returns the code generator of the config. Settings left empty are taken from
+// base, which is zero for the CA-wide config.
+func (c CodeConfig) generator(base ca.SecretCodeGenerator) (ca.SecretCodeGenerator, error) {
+	generator := ca.SecretCodeGenerator{Length: c.Length}
+	switch c.Alphabet {
+	case "":
+		if base.Alphabet == nil {
+			return generator, fmt.Errorf("the code alphabet is required")
+		}
+	case alphabetDigits:
+		generator.Alphabet = ca.DigitsAlphabet
+	case alphabetAlphanumeric:
+		generator.Alphabet = ca.AlphanumericAlphabet
+	case alphabetWords:
+		generator.Alphabet = c.Words
+		generator.Separator = "-"
+	default:
+		return generator, fmt.Errorf("unknown code alphabet %q, expected digits, alphanumeric or words", c.Alphabet)
+	}
+	if len(c.Words) > 0 && c.Alphabet != alphabetWords {
+		return generator, fmt.Errorf("words are only used by the words alphabet")
+	}
+	if base.Alphabet == nil {
+		return generator, generator.Validate()
+	}
+	return generator, nil
+}
+
+func (p NamePolicyConfig) namePolicy() (ca.NamePolicy, error) {
+	var prefix enc.Name
+	if p.Type != "allowlist" {
+		var err error
+		if prefix, err = parsePrefix(p.Prefix); err != nil {
+			return nil, err
+		}
+	}
+	switch p.Type {
+	case "email":
+		return &ca.EmailNamePolicy{Prefix: prefix}, nil
+	case "random-suffix":
+		if p.SuffixLength < 1 {
+			return nil, fmt.Errorf("suffix_length must be positive, got %d", p.SuffixLength)
+		}
+		return &ca.RandomSuffixPolicy{Prefix: prefix, SuffixLength: p.SuffixLength}, nil
+	case "allowlist":
+		policy := &ca.AllowlistPolicy{}
+		for _, entry := range p.Entries {
+			parsed, err := parsePrefix(entry.Name)
+			if err != nil {
+				return nil, err
+			}
+			if len(entry.Emails) == 0 {
+				return nil, fmt.Errorf("no email address may obtain %s", entry.Name)
+			}
+			policy.Entries = append(policy.Entries, ca.AllowlistEntry{Name: parsed, Emails: entry.Emails})
+		}
+		return policy, nil
+	default:
+		return nil, fmt.Errorf("unknown name policy %q, expected email, random-suffix or allowlist", p.Type)
+	}
+}
+
+func (p AddressPolicyConfig) addressPolicy() (email.AddressPolicy, error) {
+	subaddress, ok := subaddressPolicies[p.Subaddress]
+	if !ok {
+		return email.AddressPolicy{}, fmt.Errorf("unknown subaddress policy %q, expected keep, reject or strip", p.Subaddress)
+	}
+	policy := email.AddressPolicy{
+		AllowedDomains:   p.AllowedDomains,
+		DeniedDomains:    p.DeniedDomains,
+		AllowDisplayName: p.AllowDisplayName,
+		Subaddress:       subaddress,
+	}
+	return policy, policy.Validate()
+}
+
+func (l RateLimitConfig) rateLimit() ca.RateLimit {
+	return ca.RateLimit{Count: l.Count, Window: l.Window}
+}
+
+func parsePrefix(prefix string) (enc.Name, error) {
+	name, err := enc.NameFromStr(prefix)
+	if err != nil {
+		return nil, fmt.Errorf("invalid name %q: %w", prefix, err)
+	}
+	if len(name) == 0 {
+		return nil, fmt.Errorf("name must not be empty")
+	}
+	return name, nil
+}
+
+// checkUnder checks that name is a valid name under the CA prefix.
+func checkUnder(caPrefix enc.Name, name string) error {
+	parsed, err := parsePrefix(name)
+	if err != nil {
+		return err
+	}
+	if caPrefix != nil && !caPrefix.IsPrefix(parsed) {
+		return fmt.Errorf("%s is not under the CA prefix %s", name, caPrefix)
+	}
+	return nil
+}
+
+// lineOf finds the line of the field at path in a decoded document, such as
+// challenges.email.overrides[1].lifetime, or of its closest ancestor in the document.
+func lineOf(root *yaml.Node, path string) int {
+	node := root
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+	line := 0
+	for _, segment := range strings.Split(path, ".") {
+		key, index := segment, -1
+		if open := strings.IndexByte(segment, '['); open >= 0 {
+			key = segment[:open]
+			index, _ = strconv.Atoi(strings.TrimSuffix(segment[open+1:], "]"))
+		}
+		keyNode, value := mappingEntry(node, key)
+		if value == nil {
+			return line
+		}
+		node, line = value, keyNode.Line
+		if index >= 0 {
+			if node.Kind != yaml.SequenceNode || index >= len(node.Content) {
+				return line
+			}
+			node = node.Content[index]
+			line = node.Line
+		}
+	}
+	return line
+}
+
+func mappingEntry(node *yaml.Node, key string) (*yaml.Node, *yaml.Node) {
+	if node.Kind != yaml.MappingNode {
+		return nil, nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i], node.Content[i+1]
+		}
+	}
+	return nil, nil
+}
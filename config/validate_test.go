@@ -0,0 +1,88 @@
+package config
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+const validBase = `keys:
+  keychain: /tmp/keys
+mail:
+  smtp:
+    host: smtp.ucla.edu
+    identity: ca@ucla.edu
+`
+
+func TestValidateFields(t *testing.T) {
+	for _, test := range []struct {
+		document string
+		field    string
+		line     int
+	}{
+		{"prefix: /ndn\nkeys:\n  keychain: \"\"\n", "keys.keychain", 3},
+		{validBase + "prefix: \"\"\n", "prefix", 7},
+		{"keys:\n  keychain: k\n  key_schedule: fast\n", "keys.key_schedule", 3},
+		{validBase + "challenges:\n  enabled: [email, pin]\n", "challenges.enabled[1]", 8},
+		{validBase + "challenges:\n  email:\n    max_attempts: 0\n", "challenges.email.max_attempts", 9},
+		{validBase + "challenges:\n  email:\n    code:\n      alphabet: emoji\n", "challenges.email.code", 9},
+		{validBase + "challenges:\n  email:\n    code:\n      alphabet: words\n      words: [apple]\n", "challenges.email.code", 9},
+		{validBase + "challenges:\n  email:\n    code:\n      alphabet: digits\n      length: 1002\n", "challenges.email.code", 9},
+		{validBase + "challenges:\n  email:\n    magic_link_url: /confirm\n", "challenges.email.magic_link_url", 9},
+		{validBase + "challenges:\n  email:\n    magic_link_url: https://ca.ucla.edu/confirm\n", "challenges.email.magic_link_url", 9},
+		{validBase + "challenges:\n  email:\n    magic_link_url: https://ca.ucla.edu/confirm\n    magic_link_listen: 8443\n", "challenges.email.magic_link_listen", 10},
+		{validBase + "challenges:\n  email:\n    magic_link_listen: 127.0.0.1:8443\n", "challenges.email.magic_link_listen", 9},
+		{validBase + "challenges:\n  email:\n    overrides:\n      - prefix: /ndn/a\n      - prefix: /ndn/a\n", "challenges.email.overrides[1].prefix", 11},
+		{validBase + "challenges:\n  email:\n    overrides:\n      - prefix: /ndn/a\n        code:\n          length: -1\n", "challenges.email.overrides[0].code", 11},
+		{validBase + "challenges:\n  email:\n    overrides:\n      - prefix: /ndn/a\n        code:\n          length: 65\n", "challenges.email.overrides[0].code", 11},
+		{validBase + "policies:\n  validity:\n    - prefix: /ndn/a\n      max_validity: 1h\n      default_validity: 2h\n", "policies.validity[0].default_validity", 11},
+		{validBase + "policies:\n  names:\n    - type: email\n    - type: random-suffix\n      prefix: /ndn\n", "policies.names[0]", 9},
+		{validBase + "policies:\n  names:\n    - type: allowlist\n      entries:\n        - name: /ndn/admin\n", "policies.names[0]", 9},
+		{validBase + "policies:\n  addresses:\n    subaddress: drop\n", "policies.addresses", 8},
+		{"keys:\n  keychain: k\nmail:\n  smtp:\n    identity: ca@ucla.edu\n", "mail.smtp.host", 4},
+		{"keys:\n  keychain: k\nmail:\n  smtp:\n    host: h\n    identity: i\n    port: 0\n", "mail.smtp.port", 7},
+		{validBase + "limits:\n  per_domain:\n    count: 10\n    window: 0s\n", "limits.per_domain", 8},
+	} {
+		_, err := Parse([]byte(test.document))
+		var errs ValidationErrors
+		if !errors.As(err, &errs) {
+			t.Errorf("failed to reject the document for %s, got %v", test.field, err)
+			continue
+		}
+		var found *FieldError
+		for _, fieldErr := range errs {
+			if fieldErr.Field == test.field {
+				found = fieldErr
+			}
+		}
+		if found == nil || found.Line != test.line {
+			t.Errorf("failed to point at %s on line %d, got %v", test.field, test.line, err)
+		}
+	}
+}
+
+func TestValidateAllErrors(t *testing.T) {
+	config := Default()
+	config.Prefix = ""
+	config.Limits.MaxOutstanding = -1
+	err := config.Validate()
+	var errs ValidationErrors
+	if !errors.As(err, &errs) || len(errs) != 5 {
+		t.Fatalf("failed to report every problem, got %v", err)
+	}
+	if !strings.HasPrefix(errs[0].Error(), "prefix: ") {
+		t.Errorf("failed to name the field of an error without a line, got %q", errs[0])
+	}
+
+	config = Default()
+	config.Challenges.Enabled = nil
+	config.Keys.Keychain = "/tmp/keys"
+	if err := config.Validate(); err == nil {
+		t.Error("failed to require an enabled challenge")
+	}
+	config.Challenges.Enabled = []string{"email"}
+	config.Mail.Smtp.Host, config.Mail.Smtp.Identity = "smtp.ucla.edu", "ca@ucla.edu"
+	if err := config.Validate(); err != nil {
+		t.Errorf("failed to accept a complete configuration, got %v", err)
+	}
+}
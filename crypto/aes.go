@@ -1,14 +1,35 @@
 package crypto
 
 import (
-	"crypto/aes"
+	"bytes"
 	"crypto/cipher"
 	"crypto/rand"
+	"encoding/binary"
+	"fmt"
 	"io"
+	"math"
 )
 
 const NonceSizeBytes = 12
 const TagSizeBytes = 16
+const ivRandomSizeBytes = 8
+const blockSizeBytes = 16
+
+// EncryptionIv tracks the IVs used to encrypt messages towards the other side of a session.
+// Every IV shares the same random part, and the counter advances by the number of
+// AES blocks in each message so that no (key, IV) pair is ever reused.
+type EncryptionIv struct {
+	random  [ivRandomSizeBytes]byte
+	counter uint32
+}
+
+// DecryptionIv tracks the IVs received from the other side of a session, to reject
+// messages whose random part changes or whose counter goes backwards, as replays do.
+type DecryptionIv struct {
+	random      [ivRandomSizeBytes]byte
+	counter     uint32
+	initialized bool
+}
 
 type EncryptedMessage struct {
 	/**
@@ -29,58 +50,101 @@ type EncryptedMessage struct {
 	EncryptedPayload []byte
 }
 
-func EncryptPayload(key [TagSizeBytes]byte, plaintext []byte, requestId [8]uint8) EncryptedMessage {
-	block, cipherErr := aes.NewCipher(key[:])
-	if cipherErr != nil {
-		panic(cipherErr.Error())
+func NewEncryptionIv() (*EncryptionIv, error) {
+	iv := &EncryptionIv{}
+	if _, randReadErr := io.ReadFull(rand.Reader, iv.random[:]); randReadErr != nil {
+		return nil, randReadErr
+	}
+	return iv, nil
+}
+
+func (iv *EncryptionIv) next(payloadSize int) ([NonceSizeBytes]byte, error) {
+	var nonce [NonceSizeBytes]byte
+	blocks := blockCount(payloadSize)
+	if uint64(iv.counter)+blocks > math.MaxUint32 {
+		return nonce, fmt.Errorf("AES-GCM IV counter exhausted")
+	}
+
+	copy(nonce[:ivRandomSizeBytes], iv.random[:])
+	binary.BigEndian.PutUint32(nonce[ivRandomSizeBytes:], iv.counter)
+	iv.counter += uint32(blocks)
+	return nonce, nil
+}
+
+// Check verifies that nonce continues the sequence of IVs received so far.
+func (iv *DecryptionIv) Check(nonce [NonceSizeBytes]byte) error {
+	if !iv.initialized {
+		return nil
+	}
+	if !bytes.Equal(nonce[:ivRandomSizeBytes], iv.random[:]) {
+		return fmt.Errorf("%w: random part of the IV changed within the session", ErrReplay)
+	}
+	if binary.BigEndian.Uint32(nonce[ivRandomSizeBytes:]) < iv.counter {
+		return fmt.Errorf("%w: IV counter went backwards, the message may be replayed", ErrReplay)
+	}
+	return nil
+}
+
+func (iv *DecryptionIv) update(nonce [NonceSizeBytes]byte, payloadSize int) {
+	copy(iv.random[:], nonce[:ivRandomSizeBytes])
+	iv.counter = binary.BigEndian.Uint32(nonce[ivRandomSizeBytes:]) + uint32(blockCount(payloadSize))
+	iv.initialized = true
+}
+
+func blockCount(payloadSize int) uint64 {
+	return uint64((payloadSize + blockSizeBytes - 1) / blockSizeBytes)
+}
+
+func EncryptPayload(key [16]byte, plaintext []byte, requestId [8]uint8, iv *EncryptionIv) (EncryptedMessage, error) {
+	aead, err := CipherAes128Gcm.newAEAD(key[:])
+	if err != nil {
+		return EncryptedMessage{}, err
 	}
+	return sealPayload(aead, plaintext, requestId, iv)
+}
 
-	nonce := make([]byte, NonceSizeBytes)
-	if _, randReadErr := io.ReadFull(rand.Reader, nonce); randReadErr != nil {
-		panic(randReadErr.Error())
+func DecryptPayload(key [16]byte, message EncryptedMessage, requestId [8]uint8, iv *DecryptionIv) ([]byte, error) {
+	aead, err := CipherAes128Gcm.newAEAD(key[:])
+	if err != nil {
+		return nil, err
 	}
+	return openPayload(aead, message, requestId, iv)
+}
 
-	aesgcm, encryptErr := cipher.NewGCM(block)
-	if encryptErr != nil {
-		panic(encryptErr.Error())
+func sealPayload(aead cipher.AEAD, plaintext []byte, requestId [8]uint8, iv *EncryptionIv) (EncryptedMessage, error) {
+	nonce, ivErr := iv.next(len(plaintext))
+	if ivErr != nil {
+		return EncryptedMessage{}, ivErr
 	}
 
-	out := aesgcm.Seal(nil, nonce, plaintext, requestId[:])
+	out := aead.Seal(nil, nonce[:], plaintext, requestId[:])
 	encryptedPayload := out[:len(plaintext)]
 	_authenticationTag := out[len(plaintext):]
 
-	var initializationVector [NonceSizeBytes]byte
 	var authenticationTag [TagSizeBytes]byte
-
-	copy(initializationVector[:], nonce)
 	copy(authenticationTag[:], _authenticationTag)
 
 	return EncryptedMessage{
-		initializationVector,
+		nonce,
 		authenticationTag,
 		encryptedPayload,
-	}
+	}, nil
 }
 
-func DecryptPayload(key [16]byte, message EncryptedMessage, requestId [8]uint8) []byte {
-	block, cipherErr := aes.NewCipher(key[:])
-	if cipherErr != nil {
-		panic(cipherErr.Error())
-	}
-
-	nonce := message.InitializationVector[:]
-
-	aesgcm, encryptErr := cipher.NewGCM(block)
-	if encryptErr != nil {
-		panic(encryptErr.Error())
+func openPayload(aead cipher.AEAD, message EncryptedMessage, requestId [8]uint8, iv *DecryptionIv) ([]byte, error) {
+	if ivErr := iv.Check(message.InitializationVector); ivErr != nil {
+		return nil, ivErr
 	}
 
-	ciphertext := append(message.EncryptedPayload, message.AuthenticationTag[:]...)
+	ciphertext := make([]byte, 0, len(message.EncryptedPayload)+TagSizeBytes)
+	ciphertext = append(ciphertext, message.EncryptedPayload...)
+	ciphertext = append(ciphertext, message.AuthenticationTag[:]...)
 
-	plaintext, err := aesgcm.Open(nil, nonce, ciphertext, requestId[:])
+	plaintext, err := aead.Open(nil, message.InitializationVector[:], ciphertext, requestId[:])
 	if err != nil {
-		panic(err.Error())
+		return nil, ErrAuthentication
 	}
 
-	return plaintext
+	iv.update(message.InitializationVector, len(message.EncryptedPayload))
+	return plaintext, nil
 }
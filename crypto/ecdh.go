@@ -3,30 +3,51 @@ package crypto
 import (
 	"crypto/ecdh"
 	"crypto/rand"
+	"fmt"
 )
 
+// ECDHState is one side of the key agreement of a request. The zero Curve selects P-256.
 type ECDHState struct {
+	Curve           Curve
 	RemotePublicKey *ecdh.PublicKey
 	PublicKey       *ecdh.PublicKey
 	privateKey      *ecdh.PrivateKey
 }
 
-func (e *ECDHState) SetRemotePublicKey(pubKey []byte) {
-	curveP256 := ecdh.P256()
-	remotePubKey, err := curveP256.NewPublicKey(pubKey)
+func (e *ECDHState) SetRemotePublicKey(pubKey []byte) error {
+	curve, err := e.Curve.ecdhCurve()
 	if err != nil {
-		panic(err.Error())
+		return err
+	}
+	remotePubKey, err := curve.NewPublicKey(pubKey)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidPoint, err)
 	}
 	e.RemotePublicKey = remotePubKey
+	return nil
 }
 
-func (e *ECDHState) GenerateKeyPair() {
-	curveP256 := ecdh.P256()
-	e.privateKey, _ = curveP256.GenerateKey(rand.Reader)
-	e.PublicKey = e.privateKey.PublicKey()
+func (e *ECDHState) GenerateKeyPair() error {
+	curve, err := e.Curve.ecdhCurve()
+	if err != nil {
+		return err
+	}
+	privateKey, err := curve.GenerateKey(rand.Reader)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrKeyDerivation, err)
+	}
+	e.privateKey = privateKey
+	e.PublicKey = privateKey.PublicKey()
+	return nil
 }
 
-func (e *ECDHState) GetSharedSecret() []byte {
-	sharedSecret, _ := e.privateKey.ECDH(e.RemotePublicKey)
-	return sharedSecret
+func (e *ECDHState) GetSharedSecret() ([]byte, error) {
+	if e.privateKey == nil || e.RemotePublicKey == nil {
+		return nil, fmt.Errorf("%w: key pair or remote public key missing", ErrKeyDerivation)
+	}
+	sharedSecret, err := e.privateKey.ECDH(e.RemotePublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrKeyDerivation, err)
+	}
+	return sharedSecret, nil
 }
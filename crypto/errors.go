@@ -0,0 +1,16 @@
+package crypto
+
+import "errors"
+
+var (
+	// ErrAuthentication is returned when a ciphertext fails AES-GCM authentication, i.e. it was tampered with.
+	ErrAuthentication = errors.New("message authentication failed")
+	// ErrReplay is returned when the IV of a message does not continue the peer's IV sequence.
+	ErrReplay = errors.New("unexpected initialization vector")
+	// ErrInvalidPoint is returned when a peer's ECDH public key is not a valid point on the curve.
+	ErrInvalidPoint = errors.New("invalid ECDH public key")
+	// ErrKeyDerivation is returned when a shared secret or symmetric key cannot be derived.
+	ErrKeyDerivation = errors.New("key derivation failed")
+	// ErrUnsupportedSuite is returned when a curve or cipher is not implemented.
+	ErrUnsupportedSuite = errors.New("unsupported crypto suite")
+)
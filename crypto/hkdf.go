@@ -2,16 +2,97 @@ package crypto
 
 import (
 	"crypto/sha256"
+	"fmt"
+	enc "github.com/zjkmxy/go-ndn/pkg/encoding"
 	"golang.org/x/crypto/hkdf"
 	"io"
 )
 
-func HKDF(secret []byte, salt []byte) []byte {
+// KeySchedule selects how the symmetric keys of a request are derived from the ECDH shared secret.
+type KeySchedule uint64
+
+const (
+	// KeyScheduleCompat derives a single key with the request ID as HKDF info and uses it in
+	// both directions, as ca-module.cpp and requester-request.cpp of the C++ NDNCERT do.
+	KeyScheduleCompat KeySchedule = iota
+	// KeyScheduleDirectional derives one key per direction, each bound to the request ID
+	// and the CA prefix through the HKDF info, so a key never protects traffic of another
+	// request or of the opposite direction.
+	KeyScheduleDirectional
+)
+
+const (
+	requesterToCaLabel = "NDNCERT requester to CA"
+	caToRequesterLabel = "NDNCERT CA to requester"
+)
+
+// KeyContext is the request the keys of a session are bound to.
+type KeyContext struct {
+	RequestId [8]byte
+	CaPrefix  enc.Name
+}
+
+// SessionKeys are the symmetric keys of a session. Under KeyScheduleCompat both fields
+// hold the same key.
+type SessionKeys struct {
+	RequesterToCa []byte
+	CaToRequester []byte
+}
+
+func (s KeySchedule) Validate() error {
+	if s != KeyScheduleCompat && s != KeyScheduleDirectional {
+		return fmt.Errorf("%w: key schedule %d", ErrUnsupportedSuite, s)
+	}
+	return nil
+}
+
+// HKDF derives the 16 byte AES-128-GCM key of the NDNCERT request requestId.
+func HKDF(secret []byte, salt []byte, requestId [8]byte) ([]byte, error) {
+	return DeriveKey(secret, salt, requestId[:], 16)
+}
+
+// DeriveKey runs HKDF-SHA256 over secret and salt and expands a keySize byte key bound to info.
+func DeriveKey(secret []byte, salt []byte, info []byte, keySize int) ([]byte, error) {
 	hash := sha256.New
-	hkdf := hkdf.New(hash, secret, salt, nil)
-	key := make([]byte, 16)
+	hkdf := hkdf.New(hash, secret, salt, info)
+	key := make([]byte, keySize)
 	if _, err := io.ReadFull(hkdf, key); err != nil {
-		panic(err)
+		return nil, fmt.Errorf("%w: %s", ErrKeyDerivation, err)
+	}
+	return key, nil
+}
+
+// DeriveSessionKeys derives the keySize byte keys of the request described by context.
+func DeriveSessionKeys(schedule KeySchedule, secret []byte, salt []byte, context KeyContext, keySize int) (SessionKeys, error) {
+	if err := schedule.Validate(); err != nil {
+		return SessionKeys{}, err
+	}
+
+	if schedule == KeyScheduleCompat {
+		key, err := DeriveKey(secret, salt, context.RequestId[:], keySize)
+		if err != nil {
+			return SessionKeys{}, err
+		}
+		return SessionKeys{RequesterToCa: key, CaToRequester: key}, nil
+	}
+
+	requesterToCa, err := DeriveKey(secret, salt, context.info(requesterToCaLabel), keySize)
+	if err != nil {
+		return SessionKeys{}, err
 	}
-	return key
+	caToRequester, err := DeriveKey(secret, salt, context.info(caToRequesterLabel), keySize)
+	if err != nil {
+		zeroize(requesterToCa)
+		return SessionKeys{}, err
+	}
+	return SessionKeys{RequesterToCa: requesterToCa, CaToRequester: caToRequester}, nil
+}
+
+// info is the label, a zero byte, the request ID and the TLV encoding of the CA prefix.
+func (c KeyContext) info(label string) []byte {
+	info := make([]byte, 0, len(label)+1+len(c.RequestId)+c.CaPrefix.EncodingLength()+4)
+	info = append(info, label...)
+	info = append(info, 0)
+	info = append(info, c.RequestId[:]...)
+	return append(info, c.CaPrefix.Bytes()...)
 }
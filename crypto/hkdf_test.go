@@ -0,0 +1,94 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/hex"
+	enc "github.com/zjkmxy/go-ndn/pkg/encoding"
+	"testing"
+)
+
+func mustHex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestDeriveKeyKnownAnswers(t *testing.T) {
+	// RFC 5869 test cases 1 to 3.
+	tests := []struct {
+		secret, salt, info, okm string
+	}{
+		{
+			secret: "0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b",
+			salt:   "000102030405060708090a0b0c",
+			info:   "f0f1f2f3f4f5f6f7f8f9",
+			okm:    "3cb25f25faacd57a90434f64d0362f2a2d2d0a90cf1a5a4c5db02d56ecc4c5bf34007208d5b887185865",
+		},
+		{
+			secret: "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f" +
+				"202122232425262728292a2b2c2d2e2f303132333435363738393a3b3c3d3e3f404142434445464748494a4b4c4d4e4f",
+			salt: "606162636465666768696a6b6c6d6e6f707172737475767778797a7b7c7d7e7f" +
+				"808182838485868788898a8b8c8d8e8f909192939495969798999a9b9c9d9e9fa0a1a2a3a4a5a6a7a8a9aaabacadaeaf",
+			info: "b0b1b2b3b4b5b6b7b8b9babbbcbdbebfc0c1c2c3c4c5c6c7c8c9cacbcccdcecf" +
+				"d0d1d2d3d4d5d6d7d8d9dadbdcdddedfe0e1e2e3e4e5e6e7e8e9eaebecedeeeff0f1f2f3f4f5f6f7f8f9fafbfcfdfeff",
+			okm: "b11e398dc80327a1c8e7f78c596a49344f012eda2d4efad8a050cc4c19afa97c" +
+				"59045a99cac7827271cb41c65e590e09da3275600c2f09b8367793a9aca3db71cc30c58179ec3e87c14c01d5c1f3434f1d87",
+		},
+		{
+			secret: "0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b",
+			okm:    "8da4e775a563c18f715f802a063c5a31b8a11f5c5ee1879ec3454e5f3c738d2d9d201395faa4b61a96c8",
+		},
+	}
+	for _, test := range tests {
+		okm := mustHex(t, test.okm)
+		key, err := DeriveKey(mustHex(t, test.secret), mustHex(t, test.salt), mustHex(t, test.info), len(okm))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(key, okm) {
+			t.Errorf("failed to derive the expected key, got %x want %x", key, okm)
+		}
+	}
+}
+
+func TestDeriveSessionKeys(t *testing.T) {
+	secret := mustHex(t, "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f")
+	salt := mustHex(t, "202122232425262728292a2b2c2d2e2f303132333435363738393a3b3c3d3e3f")
+	caPrefix, _ := enc.NameFromStr("/ndn")
+	context := KeyContext{RequestId: [8]byte{'r', 'e', 'q', 'u', 'e', 's', 't', '1'}, CaPrefix: caPrefix}
+
+	compat, err := DeriveSessionKeys(KeyScheduleCompat, secret, salt, context, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy, _ := HKDF(secret, salt, context.RequestId)
+	if !bytes.Equal(compat.RequesterToCa, legacy) || !bytes.Equal(compat.CaToRequester, legacy) {
+		t.Error("failed to derive the NDNCERT key in compatibility mode")
+	}
+	if !bytes.Equal(legacy, mustHex(t, "7cc09e91890e7b28f476e28da4f8396c")) {
+		t.Errorf("failed to derive the expected NDNCERT key, got %x", legacy)
+	}
+
+	directional, err := DeriveSessionKeys(KeyScheduleDirectional, secret, salt, context, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(directional.RequesterToCa, mustHex(t, "e9ac95c2f0f49686c027bd397aad4bc9")) {
+		t.Errorf("failed to derive the expected requester to CA key, got %x", directional.RequesterToCa)
+	}
+	if !bytes.Equal(directional.CaToRequester, mustHex(t, "d9bf190a708a2622a9fefc67085f7266")) {
+		t.Errorf("failed to derive the expected CA to requester key, got %x", directional.CaToRequester)
+	}
+
+	context.RequestId[0] ^= 1
+	other, _ := DeriveSessionKeys(KeyScheduleDirectional, secret, salt, context, 16)
+	if bytes.Equal(other.RequesterToCa, directional.RequesterToCa) {
+		t.Error("failed to bind the keys to the request ID")
+	}
+
+	if _, err := DeriveSessionKeys(KeySchedule(7), secret, salt, context, 16); err == nil {
+		t.Error("failed to reject an unknown key schedule")
+	}
+}
@@ -0,0 +1,161 @@
+package crypto
+
+import (
+	"crypto/cipher"
+	"errors"
+	"fmt"
+	"ndn/ndncert/challenge/schemaold"
+)
+
+// ErrSessionClosed is returned when a session is used after its key material has been erased.
+var ErrSessionClosed = errors.New("session is closed")
+
+// Role is the side of a request a session belongs to.
+type Role int
+
+const (
+	RoleRequester Role = iota
+	RoleCa
+)
+
+// Session is the encrypted channel of a single NDNCERT request. Its keys are derived with
+// HKDF from the ECDH shared secret and the salt chosen by the CA, and every message is
+// sealed with the AEAD of the suite using the request ID as associated data.
+type Session struct {
+	requestId    [8]byte
+	suite        Suite
+	schedule     KeySchedule
+	keys         SessionKeys
+	sealAead     cipher.AEAD
+	openAead     cipher.AEAD
+	encryptionIv *EncryptionIv
+	decryptionIv DecryptionIv
+	closed       bool
+}
+
+// NewSession derives the session key shared with the owner of peerPublicKey, using a
+// single key for both directions as NDNCERT does. local must have been generated on
+// the curve of suite.
+func NewSession(suite Suite, local *ECDHState, peerPublicKey []byte, salt []byte, requestId [8]byte) (*Session, error) {
+	return NewSessionWithSchedule(suite, KeyScheduleCompat, RoleRequester, local, peerPublicKey, salt,
+		KeyContext{RequestId: requestId})
+}
+
+// NewSessionWithSchedule derives the session keys with schedule. role selects which of
+// the directional keys seals outgoing messages and which opens incoming ones.
+func NewSessionWithSchedule(suite Suite, schedule KeySchedule, role Role, local *ECDHState,
+	peerPublicKey []byte, salt []byte, context KeyContext) (*Session, error) {
+	if err := suite.Validate(); err != nil {
+		return nil, err
+	}
+	if local.Curve.orDefault() != suite.Curve.orDefault() {
+		return nil, fmt.Errorf("%w: key pair is on %s but the suite uses %s",
+			ErrUnsupportedSuite, local.Curve.orDefault(), suite.Curve.orDefault())
+	}
+	keySize, _ := suite.Cipher.KeySize()
+
+	if err := local.SetRemotePublicKey(peerPublicKey); err != nil {
+		return nil, err
+	}
+
+	sharedSecret, err := local.GetSharedSecret()
+	if err != nil {
+		return nil, err
+	}
+	defer zeroize(sharedSecret)
+
+	keys, err := DeriveSessionKeys(schedule, sharedSecret, salt, context, keySize)
+	if err != nil {
+		return nil, err
+	}
+
+	session := &Session{
+		requestId: context.RequestId,
+		suite:     suite,
+		schedule:  schedule,
+		keys:      keys,
+	}
+
+	sealKey, openKey := keys.RequesterToCa, keys.CaToRequester
+	if role == RoleCa {
+		sealKey, openKey = openKey, sealKey
+	}
+	if session.sealAead, err = suite.Cipher.newAEAD(sealKey); err == nil {
+		session.openAead, err = suite.Cipher.newAEAD(openKey)
+	}
+	if err == nil {
+		session.encryptionIv, err = NewEncryptionIv()
+	}
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	return session, nil
+}
+
+func (s *Session) RequestId() [8]byte {
+	return s.requestId
+}
+
+func (s *Session) Suite() Suite {
+	return s.suite
+}
+
+func (s *Session) KeySchedule() KeySchedule {
+	return s.schedule
+}
+
+// Seal encrypts plaintext into a CipherMsg for the peer.
+func (s *Session) Seal(plaintext []byte) (*schemaold.CipherMsg, error) {
+	if s.closed {
+		return nil, ErrSessionClosed
+	}
+
+	encryptedMessage, err := sealPayload(s.sealAead, plaintext, s.requestId, s.encryptionIv)
+	if err != nil {
+		return nil, err
+	}
+
+	return &schemaold.CipherMsg{
+		InitVec:  encryptedMessage.InitializationVector[:],
+		AuthNTag: encryptedMessage.AuthenticationTag[:],
+		Payload:  encryptedMessage.EncryptedPayload,
+	}, nil
+}
+
+// Open authenticates and decrypts a CipherMsg received from the peer.
+func (s *Session) Open(cipherMsg *schemaold.CipherMsg) ([]byte, error) {
+	if s.closed {
+		return nil, ErrSessionClosed
+	}
+
+	if len(cipherMsg.InitVec) != NonceSizeBytes || len(cipherMsg.AuthNTag) != TagSizeBytes {
+		return nil, fmt.Errorf("%w: malformed IV or authentication tag", ErrAuthentication)
+	}
+
+	var encryptedMessage EncryptedMessage
+	copy(encryptedMessage.InitializationVector[:], cipherMsg.InitVec)
+	copy(encryptedMessage.AuthenticationTag[:], cipherMsg.AuthNTag)
+	encryptedMessage.EncryptedPayload = cipherMsg.Payload
+
+	return openPayload(s.openAead, encryptedMessage, s.requestId, &s.decryptionIv)
+}
+
+// Close erases the key material of the session. It must be called once the request completes.
+func (s *Session) Close() {
+	zeroize(s.keys.RequesterToCa)
+	zeroize(s.keys.CaToRequester)
+	s.sealAead = nil
+	s.openAead = nil
+	if s.encryptionIv != nil {
+		zeroize(s.encryptionIv.random[:])
+	}
+	zeroize(s.decryptionIv.random[:])
+	s.closed = true
+}
+
+func zeroize(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
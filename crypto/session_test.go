@@ -0,0 +1,160 @@
+package crypto
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func newSessionPair(t *testing.T, suite Suite) (*Session, *Session) {
+	requester := ECDHState{Curve: suite.Curve}
+	ca := ECDHState{Curve: suite.Curve}
+	if err := requester.GenerateKeyPair(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ca.GenerateKeyPair(); err != nil {
+		t.Fatal(err)
+	}
+
+	salt := []byte("0123456789abcdef0123456789abcdef")
+	requestId := [8]byte{'r', 'e', 'q', 'u', 'e', 's', 't', '1'}
+
+	requesterSession, err := NewSession(suite, &requester, ca.PublicKey.Bytes(), salt, requestId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caSession, err := NewSession(suite, &ca, requester.PublicKey.Bytes(), salt, requestId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return requesterSession, caSession
+}
+
+func TestSessionRoundTrip(t *testing.T) {
+	for _, curve := range []Curve{CurveP256, CurveP384, CurveX25519} {
+		for _, cipher := range []Cipher{CipherAes128Gcm, CipherAes256Gcm, CipherChaCha20Poly1305} {
+			suite := Suite{Curve: curve, Cipher: cipher}
+			requesterSession, caSession := newSessionPair(t, suite)
+
+			for _, message := range [][]byte{[]byte("email"), bytes.Repeat([]byte("x"), 100), []byte("code")} {
+				cipherMsg, err := requesterSession.Seal(message)
+				if err != nil {
+					t.Fatal(err)
+				}
+				plaintext, err := caSession.Open(cipherMsg)
+				if err != nil {
+					t.Fatalf("%s: failed to open message sealed by the peer: %s", suite, err)
+				}
+				if !bytes.Equal(plaintext, message) {
+					t.Errorf("%s: failed to round trip %q, got %q", suite, message, plaintext)
+				}
+			}
+		}
+	}
+}
+
+func TestParseSuite(t *testing.T) {
+	suite, err := ParseSuite("X25519/ChaCha20-Poly1305")
+	if err != nil || suite != (Suite{Curve: CurveX25519, Cipher: CipherChaCha20Poly1305}) {
+		t.Errorf("failed to parse a supported suite, got %v, %v", suite, err)
+	}
+	if suite, err := ParseSuite(DefaultSuite.String()); err != nil || suite != DefaultSuite {
+		t.Errorf("failed to round trip the default suite, got %v, %v", suite, err)
+	}
+	for _, value := range []string{"P-256", "P-521/AES-128-GCM", "P-256/AES-192-GCM"} {
+		if _, err := ParseSuite(value); !errors.Is(err, ErrUnsupportedSuite) {
+			t.Errorf("failed to reject %q, got %v", value, err)
+		}
+	}
+}
+
+func TestSessionRejectsCurveMismatch(t *testing.T) {
+	requester := ECDHState{Curve: CurveX25519}
+	ca := ECDHState{}
+	if err := requester.GenerateKeyPair(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ca.GenerateKeyPair(); err != nil {
+		t.Fatal(err)
+	}
+
+	suite := Suite{Curve: CurveX25519, Cipher: CipherAes128Gcm}
+	if _, err := NewSession(suite, &ca, requester.PublicKey.Bytes(), nil, [8]byte{}); !errors.Is(err, ErrUnsupportedSuite) {
+		t.Errorf("failed to reject a key pair on another curve, got %v", err)
+	}
+	if _, err := NewSession(DefaultSuite, &ca, requester.PublicKey.Bytes(), nil, [8]byte{}); !errors.Is(err, ErrInvalidPoint) {
+		t.Errorf("failed to reject an X25519 key as a P-256 point, got %v", err)
+	}
+}
+
+func TestSessionRejectsTamperingAndReplay(t *testing.T) {
+	requesterSession, caSession := newSessionPair(t, DefaultSuite)
+
+	first, _ := requesterSession.Seal([]byte("first"))
+	second, _ := requesterSession.Seal([]byte("second"))
+
+	tampered := *first
+	tampered.Payload = append([]byte{}, first.Payload...)
+	tampered.Payload[0] ^= 1
+	if _, err := caSession.Open(&tampered); !errors.Is(err, ErrAuthentication) {
+		t.Errorf("failed to return ErrAuthentication for a tampered payload, got %v", err)
+	}
+
+	if _, err := caSession.Open(second); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := caSession.Open(first); !errors.Is(err, ErrReplay) {
+		t.Errorf("failed to return ErrReplay for an out of order message, got %v", err)
+	}
+}
+
+func TestSessionClose(t *testing.T) {
+	requesterSession, _ := newSessionPair(t, DefaultSuite)
+	requesterSession.Close()
+
+	if !bytes.Equal(requesterSession.keys.RequesterToCa, make([]byte, 16)) {
+		t.Error("failed to zeroize the session key")
+	}
+	if _, err := requesterSession.Seal([]byte("late")); !errors.Is(err, ErrSessionClosed) {
+		t.Error("failed to refuse sealing after Close")
+	}
+}
+
+func TestDirectionalSession(t *testing.T) {
+	requester := ECDHState{}
+	ca := ECDHState{}
+	if err := requester.GenerateKeyPair(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ca.GenerateKeyPair(); err != nil {
+		t.Fatal(err)
+	}
+	context := KeyContext{RequestId: [8]byte{'r', 'e', 'q', 'u', 'e', 's', 't', '1'}}
+	salt := []byte("0123456789abcdef0123456789abcdef")
+
+	requesterSession, err := NewSessionWithSchedule(DefaultSuite, KeyScheduleDirectional, RoleRequester,
+		&requester, ca.PublicKey.Bytes(), salt, context)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caSession, err := NewSessionWithSchedule(DefaultSuite, KeyScheduleDirectional, RoleCa,
+		&ca, requester.PublicKey.Bytes(), salt, context)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A message reflected back to its sender is sealed with the key of the other direction.
+	reflected, _ := requesterSession.Seal([]byte("reflected"))
+	if _, err := requesterSession.Open(reflected); !errors.Is(err, ErrAuthentication) {
+		t.Errorf("failed to reject a reflected message, got %v", err)
+	}
+
+	request, _ := requesterSession.Seal([]byte("request"))
+	if plaintext, err := caSession.Open(request); err != nil || string(plaintext) != "request" {
+		t.Errorf("failed to open a request from the requester, got %q, %v", plaintext, err)
+	}
+	response, _ := caSession.Seal([]byte("response"))
+	if plaintext, err := requesterSession.Open(response); err != nil || string(plaintext) != "response" {
+		t.Errorf("failed to open a response from the CA, got %q, %v", plaintext, err)
+	}
+}
@@ -0,0 +1,168 @@
+package crypto
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"fmt"
+	enc "github.com/zjkmxy/go-ndn/pkg/encoding"
+	"github.com/zjkmxy/go-ndn/pkg/ndn"
+	"time"
+)
+
+// go-ndn encodes SignatureValue with a one-byte length, so longer signatures cannot be produced.
+const maxSignatureSizeBytes = 252
+
+type validitySigner struct {
+	ndn.Signer
+	notBefore time.Time
+	notAfter  time.Time
+}
+
+type ecdsaSigner struct {
+	keyName enc.Name
+	key     *ecdsa.PrivateKey
+}
+
+type ed25519Signer struct {
+	keyName enc.Name
+	key     ed25519.PrivateKey
+}
+
+type rsaSigner struct {
+	keyName enc.Name
+	key     *rsa.PrivateKey
+}
+
+// NewSigner creates a Data signer for an ECDSA, Ed25519 or RSA private key.
+// The key name is placed in the KeyLocator of every signed packet.
+func NewSigner(keyName enc.Name, key crypto.Signer) (ndn.Signer, error) {
+	switch k := key.(type) {
+	case *ecdsa.PrivateKey:
+		return &ecdsaSigner{keyName, k}, nil
+	case ed25519.PrivateKey:
+		return &ed25519Signer{keyName, k}, nil
+	case *rsa.PrivateKey:
+		if k.Size() > maxSignatureSizeBytes {
+			return nil, fmt.Errorf("RSA key of %d bits is too large to sign NDN packets", k.Size()*8)
+		}
+		return &rsaSigner{keyName, k}, nil
+	default:
+		return nil, fmt.Errorf("unsupported signing key type %T", key)
+	}
+}
+
+// WithValidity wraps a signer so that the packets it signs carry a ValidityPeriod, as certificates require.
+func WithValidity(signer ndn.Signer, notBefore time.Time, notAfter time.Time) ndn.Signer {
+	return &validitySigner{signer, notBefore, notAfter}
+}
+
+func (s *validitySigner) SigInfo() (*ndn.SigConfig, error) {
+	config, err := s.Signer.SigInfo()
+	if err != nil {
+		return nil, err
+	}
+	config.NotBefore = &s.notBefore
+	config.NotAfter = &s.notAfter
+	return config, nil
+}
+
+func (s *ecdsaSigner) SigInfo() (*ndn.SigConfig, error) {
+	return &ndn.SigConfig{
+		Type:    ndn.SignatureSha256WithEcdsa,
+		KeyName: s.keyName,
+	}, nil
+}
+
+func (s *ecdsaSigner) EstimateSize() uint {
+	// DER SEQUENCE of two INTEGERs, each possibly padded with a leading zero byte.
+	coordinateSize := (s.key.Curve.Params().BitSize + 7) / 8
+	return uint(2*(coordinateSize+3) + 3)
+}
+
+func (s *ecdsaSigner) ComputeSigValue(covered enc.Wire) ([]byte, error) {
+	digest := sha256.Sum256(covered.Join())
+	return ecdsa.SignASN1(rand.Reader, s.key, digest[:])
+}
+
+func (s *ed25519Signer) SigInfo() (*ndn.SigConfig, error) {
+	return &ndn.SigConfig{
+		Type:    ndn.SignatureEd25519,
+		KeyName: s.keyName,
+	}, nil
+}
+
+func (s *ed25519Signer) EstimateSize() uint {
+	return ed25519.SignatureSize
+}
+
+func (s *ed25519Signer) ComputeSigValue(covered enc.Wire) ([]byte, error) {
+	return ed25519.Sign(s.key, covered.Join()), nil
+}
+
+func (s *rsaSigner) SigInfo() (*ndn.SigConfig, error) {
+	return &ndn.SigConfig{
+		Type:    ndn.SignatureSha256WithRsa,
+		KeyName: s.keyName,
+	}, nil
+}
+
+func (s *rsaSigner) EstimateSize() uint {
+	return uint(s.key.Size())
+}
+
+func (s *rsaSigner) ComputeSigValue(covered enc.Wire) ([]byte, error) {
+	digest := sha256.Sum256(covered.Join())
+	return rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, digest[:])
+}
+
+// CertificatePublicKey extracts the public key carried in the content of an NDN certificate.
+func CertificatePublicKey(cert ndn.Data) (crypto.PublicKey, error) {
+	if cert.ContentType() == nil || *cert.ContentType() != ndn.ContentTypeKey {
+		return nil, fmt.Errorf("%s is not a certificate", cert.Name())
+	}
+	publicKey, err := x509.ParsePKIXPublicKey(cert.Content().Join())
+	if err != nil {
+		return nil, fmt.Errorf("malformed public key in certificate %s: %w", cert.Name(), err)
+	}
+	return publicKey, nil
+}
+
+// VerifySignature checks a signature value over the signed portion of a packet.
+func VerifySignature(publicKey crypto.PublicKey, sigType ndn.SigType, covered enc.Wire, sigValue []byte) error {
+	switch sigType {
+	case ndn.SignatureSha256WithEcdsa:
+		k, ok := publicKey.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("signature type %d does not match %T", sigType, publicKey)
+		}
+		digest := sha256.Sum256(covered.Join())
+		if !ecdsa.VerifyASN1(k, digest[:], sigValue) {
+			return fmt.Errorf("invalid ECDSA signature")
+		}
+	case ndn.SignatureEd25519:
+		k, ok := publicKey.(ed25519.PublicKey)
+		if !ok {
+			return fmt.Errorf("signature type %d does not match %T", sigType, publicKey)
+		}
+		if !ed25519.Verify(k, covered.Join(), sigValue) {
+			return fmt.Errorf("invalid Ed25519 signature")
+		}
+	case ndn.SignatureSha256WithRsa:
+		k, ok := publicKey.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("signature type %d does not match %T", sigType, publicKey)
+		}
+		digest := sha256.Sum256(covered.Join())
+		if err := rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], sigValue); err != nil {
+			return fmt.Errorf("invalid RSA signature: %w", err)
+		}
+	default:
+		return fmt.Errorf("unsupported signature type %d", sigType)
+	}
+	return nil
+}
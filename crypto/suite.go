@@ -0,0 +1,121 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"fmt"
+	"golang.org/x/crypto/chacha20poly1305"
+	"strings"
+)
+
+// Curve names the group used for the ECDH exchange in NEW.
+type Curve string
+
+// Cipher names the AEAD used to seal CHALLENGE parameters.
+type Cipher string
+
+const (
+	CurveP256   Curve = "P-256"
+	CurveP384   Curve = "P-384"
+	CurveX25519 Curve = "X25519"
+)
+
+const (
+	CipherAes128Gcm        Cipher = "AES-128-GCM"
+	CipherAes256Gcm        Cipher = "AES-256-GCM"
+	CipherChaCha20Poly1305 Cipher = "ChaCha20-Poly1305"
+)
+
+// Suite is the combination of key agreement and AEAD a CA uses for its requests.
+// It is advertised in the CA profile as "<curve>/<cipher>", e.g. "P-256/AES-128-GCM".
+type Suite struct {
+	Curve  Curve
+	Cipher Cipher
+}
+
+// DefaultSuite is the suite defined by NDNCERT, which every requester supports.
+var DefaultSuite = Suite{Curve: CurveP256, Cipher: CipherAes128Gcm}
+
+func (s Suite) String() string {
+	return string(s.Curve) + "/" + string(s.Cipher)
+}
+
+// ParseSuite parses a suite in the form produced by Suite.String.
+func ParseSuite(value string) (Suite, error) {
+	curve, cipher, found := strings.Cut(value, "/")
+	if !found {
+		return Suite{}, fmt.Errorf("%w: %q is not in the form <curve>/<cipher>", ErrUnsupportedSuite, value)
+	}
+	suite := Suite{Curve: Curve(curve), Cipher: Cipher(cipher)}
+	if err := suite.Validate(); err != nil {
+		return Suite{}, err
+	}
+	return suite, nil
+}
+
+// Validate checks that both the curve and the cipher of the suite are supported.
+func (s Suite) Validate() error {
+	if _, err := s.Curve.ecdhCurve(); err != nil {
+		return err
+	}
+	if _, err := s.Cipher.KeySize(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c Curve) orDefault() Curve {
+	if c == "" {
+		return CurveP256
+	}
+	return c
+}
+
+func (c Curve) ecdhCurve() (ecdh.Curve, error) {
+	switch c {
+	case CurveP256, "":
+		return ecdh.P256(), nil
+	case CurveP384:
+		return ecdh.P384(), nil
+	case CurveX25519:
+		return ecdh.X25519(), nil
+	default:
+		return nil, fmt.Errorf("%w: curve %q", ErrUnsupportedSuite, c)
+	}
+}
+
+// KeySize returns the length of the symmetric key the cipher is used with.
+func (c Cipher) KeySize() (int, error) {
+	switch c {
+	case CipherAes128Gcm, "":
+		return 16, nil
+	case CipherAes256Gcm:
+		return 32, nil
+	case CipherChaCha20Poly1305:
+		return chacha20poly1305.KeySize, nil
+	default:
+		return 0, fmt.Errorf("%w: cipher %q", ErrUnsupportedSuite, c)
+	}
+}
+
+// newAEAD returns the cipher keyed with key. Every supported cipher uses a 12 byte
+// nonce and a 16 byte tag, so they all fit the CipherMsg layout of NDNCERT.
+func (c Cipher) newAEAD(key []byte) (cipher.AEAD, error) {
+	keySize, err := c.KeySize()
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != keySize {
+		return nil, fmt.Errorf("%w: %s requires a %d byte key", ErrKeyDerivation, c, keySize)
+	}
+
+	if c == CipherChaCha20Poly1305 {
+		return chacha20poly1305.New(key)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,241 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	enc "github.com/zjkmxy/go-ndn/pkg/encoding"
+	"ndn/ndncert/challenge/schemaold"
+	"os"
+	"testing"
+)
+
+// The GCM vectors are test cases 3, 4, 15 and 16 of "The Galois/Counter Mode of Operation"
+// (McGrew and Viega), as used in the NIST validation suite.
+const (
+	gcmKey128   = "feffe9928665731c6d6a8f9467308308"
+	gcmKey256   = "feffe9928665731c6d6a8f9467308308feffe9928665731c6d6a8f9467308308"
+	gcmIv       = "cafebabefacedbaddecaf888"
+	gcmAad      = "feedfacedeadbeeffeedfacedeadbeefabaddad2"
+	gcmPlain    = "d9313225f88406e5a55909c5aff5269a86a7a9531534f7da2e4c303d8a318a721c3c0c95956809532fcf0e2449a6b525b16aedf5aa0de657ba637b391aafd255"
+	gcmCipher   = "42831ec2217774244b7221b784d0d49ce3aa212f2c02a4e035c17e2329aca12e21d514b25466931c7d8f6a5aac84aa051ba30b396a0aac973d58e091473f5985"
+	gcmCipher15 = "522dc1f099567d07f47f37a32a84427d643a8cdcbfe5c0c97598a2bd2555d1aa8cb08e48590dbb3da7b08b1056828838c5f61e6393ba7a0abcc9f662898015ad"
+)
+
+func TestAeadKnownAnswers(t *testing.T) {
+	tests := []struct {
+		name                             string
+		cipher                           Cipher
+		key, nonce, aad, plain, expected string
+		tag                              string
+	}{
+		{"GCM test case 3", CipherAes128Gcm, gcmKey128, gcmIv, "", gcmPlain, gcmCipher,
+			"4d5c2af327cd64a62cf35abd2ba6fab4"},
+		{"GCM test case 4", CipherAes128Gcm, gcmKey128, gcmIv, gcmAad, gcmPlain[:120], gcmCipher[:120],
+			"5bc94fbc3221a5db94fae95ae7121a47"},
+		{"GCM test case 15", CipherAes256Gcm, gcmKey256, gcmIv, "", gcmPlain, gcmCipher15,
+			"b094dac5d93471bdec1a502270e3cc6c"},
+		{"GCM test case 16", CipherAes256Gcm, gcmKey256, gcmIv, gcmAad, gcmPlain[:120], gcmCipher15[:120],
+			"76fc6ece0f4e1768cddf8853bb2d551b"},
+		// RFC 8439, section 2.8.2.
+		{"ChaCha20-Poly1305", CipherChaCha20Poly1305,
+			"808182838485868788898a8b8c8d8e8f909192939495969798999a9b9c9d9e9f",
+			"070000004041424344454647", "50515253c0c1c2c3c4c5c6c7",
+			"4c616469657320616e642047656e746c656d656e206f662074686520636c617373206f66202739393a204966204920636f756c64206f6666657220796f75206f6e6c79206f6e652074697020666f7220746865206675747572652c2073756e73637265656e20776f756c642062652069742e",
+			"d31a8d34648e60db7b86afbc53ef7ec2a4aded51296e08fea9e2b5a736ee62d63dbea45e8ca9671282fafb69da92728b1a71de0a9e060b2905d6a5b67ecd3b3692ddbd7f2d778b8c9803aee328091b58fab324e4fad675945585808b4831d7bc3ff4def08e4b7a9de576d26586cec64b6116",
+			"1ae10b594f09e26a7e902ecbd0600691"},
+	}
+
+	for _, test := range tests {
+		aead, err := test.cipher.newAEAD(mustHex(t, test.key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := append(mustHex(t, test.expected), mustHex(t, test.tag)...)
+		sealed := aead.Seal(nil, mustHex(t, test.nonce), mustHex(t, test.plain), mustHex(t, test.aad))
+		if !bytes.Equal(sealed, expected) {
+			t.Errorf("%s: failed to produce the expected ciphertext, got %x", test.name, sealed)
+		}
+		opened, err := aead.Open(nil, mustHex(t, test.nonce), expected, mustHex(t, test.aad))
+		if err != nil || !bytes.Equal(opened, mustHex(t, test.plain)) {
+			t.Errorf("%s: failed to open the expected ciphertext: %v", test.name, err)
+		}
+	}
+}
+
+func TestEncryptPayloadKnownAnswer(t *testing.T) {
+	// With the random part and counter of the IV fixed to those of GCM test case 3, the
+	// only difference from that vector is the request ID that NDNCERT uses as AAD.
+	var key [16]byte
+	copy(key[:], mustHex(t, gcmKey128))
+	iv := &EncryptionIv{counter: 0xdecaf888}
+	copy(iv.random[:], mustHex(t, gcmIv))
+	requestId := [8]byte{0xfe, 0xed, 0xfa, 0xce, 0xde, 0xad, 0xbe, 0xef}
+
+	message, err := EncryptPayload(key, mustHex(t, gcmPlain), requestId, iv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(message.InitializationVector[:], mustHex(t, gcmIv)) {
+		t.Errorf("failed to build the IV from the random part and counter, got %x", message.InitializationVector)
+	}
+	if !bytes.Equal(message.EncryptedPayload, mustHex(t, gcmCipher)) {
+		t.Errorf("failed to produce the GCM test case 3 ciphertext, got %x", message.EncryptedPayload)
+	}
+	if iv.counter != 0xdecaf888+4 {
+		t.Errorf("failed to advance the IV counter by the 4 blocks of the payload, got %x", iv.counter)
+	}
+
+	plaintext, err := DecryptPayload(key, message, requestId, &DecryptionIv{})
+	if err != nil || !bytes.Equal(plaintext, mustHex(t, gcmPlain)) {
+		t.Errorf("failed to decrypt the payload: %v", err)
+	}
+	requestId[0] ^= 1
+	if _, err := DecryptPayload(key, message, requestId, &DecryptionIv{}); !errors.Is(err, ErrAuthentication) {
+		t.Error("failed to bind the ciphertext to the request ID")
+	}
+}
+
+func TestEcdhKnownAnswers(t *testing.T) {
+	tests := []struct {
+		name                          string
+		curve                         Curve
+		privateKey, peerKey, expected string
+	}{
+		// RFC 7748, section 6.1, from both sides.
+		{"X25519 Alice", CurveX25519,
+			"77076d0a7318a57d3c16c17251b26645df4c2f87ebc0992ab177fba51db92c2a",
+			"de9edb7d7b7dc1b4d35b61c2ece435373f8343c85b78674dadfc7e146f882b4f",
+			"4a5d9d5ba4ce2de1728e3bf480350f25e07e21c947d19e3376f09b3c1e161742"},
+		{"X25519 Bob", CurveX25519,
+			"5dab087e624a8a4b79e17f8b83800ee66f3bb1292618b6fd1c2f8b27ff88e0eb",
+			"8520f0098930a754748b7ddcb43ef75a0dbf3a0d26381af4eba4a98eaa9b4e6a",
+			"4a5d9d5ba4ce2de1728e3bf480350f25e07e21c947d19e3376f09b3c1e161742"},
+		// Generated with OpenSSL: "openssl pkeyutl -derive" over two prime256v1 keys.
+		{"P-256", CurveP256,
+			"cf8a2be50271bd6e289811ce0c2f0070ef3d8b05a7e09958cc5e8376c5db8ef3",
+			"04155ad79d1ce194ea2257f2acdd75b83e198a8e8792d295c7563cd9ad9e00d888" +
+				"68f59bdd8d92297bb2462b05daefa3a9229eecd6c97a59e9fcd78ffdb6a85a62",
+			"488eb0876c4c5d5ed7d1eace391762490706446369b7db72afb3453b8e8935ea"},
+	}
+
+	for _, test := range tests {
+		curve, _ := test.curve.ecdhCurve()
+		privateKey, err := curve.NewPrivateKey(mustHex(t, test.privateKey))
+		if err != nil {
+			t.Fatal(err)
+		}
+		state := ECDHState{Curve: test.curve, privateKey: privateKey, PublicKey: privateKey.PublicKey()}
+		if err := state.SetRemotePublicKey(mustHex(t, test.peerKey)); err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		sharedSecret, err := state.GetSharedSecret()
+		if err != nil || !bytes.Equal(sharedSecret, mustHex(t, test.expected)) {
+			t.Errorf("%s: failed to derive the expected shared secret, got %x, %v", test.name, sharedSecret, err)
+		}
+	}
+
+	state := ECDHState{}
+	if err := state.SetRemotePublicKey(mustHex(t, "04"+gcmKey256+gcmKey256)); !errors.Is(err, ErrInvalidPoint) {
+		t.Errorf("failed to reject a point that is not on P-256, got %v", err)
+	}
+}
+
+// opensslSessions are NDNCERT sessions recomputed step by step with the OpenSSL command line
+// and libcrypto: P-256 ECDH between the CA and requester keys ("openssl pkeyutl -derive"),
+// HKDF-SHA256 of the shared secret and salt with the request ID as info ("openssl kdf HKDF")
+// and AES-128-GCM with the request ID as AAD (EVP_aes_128_gcm). They check each step against
+// a second implementation, not against sessions captured from the C++ NDNCERT.
+type opensslSessions struct {
+	OpenSSL  string `json:"openssl"`
+	Sessions []struct {
+		CaPrivateKey        string `json:"caPrivateKey"`
+		CaPublicKey         string `json:"caPublicKey"`
+		RequesterPrivateKey string `json:"requesterPrivateKey"`
+		RequesterPublicKey  string `json:"requesterPublicKey"`
+		SharedSecret        string `json:"sharedSecret"`
+		Salt                string `json:"salt"`
+		RequestId           string `json:"requestId"`
+		Key                 string `json:"key"`
+		Messages            []struct {
+			Iv        string `json:"iv"`
+			Plaintext string `json:"plaintext"`
+			CipherMsg string `json:"cipherMsg"`
+		} `json:"messages"`
+	} `json:"sessions"`
+}
+
+const opensslSessionsFile = "testdata/openssl-sessions.json"
+
+func TestOpenSSLSessions(t *testing.T) {
+	raw, err := os.ReadFile(opensslSessionsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var vectors opensslSessions
+	if err := json.Unmarshal(raw, &vectors); err != nil {
+		t.Fatal(err)
+	}
+	if len(vectors.Sessions) == 0 {
+		t.Fatalf("%s holds no sessions", opensslSessionsFile)
+	}
+
+	for i, session := range vectors.Sessions {
+		for _, side := range []struct{ privateKey, peerKey string }{
+			{session.CaPrivateKey, session.RequesterPublicKey},
+			{session.RequesterPrivateKey, session.CaPublicKey},
+		} {
+			curve, _ := CurveP256.ecdhCurve()
+			privateKey, err := curve.NewPrivateKey(mustHex(t, side.privateKey))
+			if err != nil {
+				t.Fatalf("session %d: %s", i, err)
+			}
+			state := ECDHState{Curve: CurveP256, privateKey: privateKey, PublicKey: privateKey.PublicKey()}
+			if err := state.SetRemotePublicKey(mustHex(t, side.peerKey)); err != nil {
+				t.Fatalf("session %d: %s", i, err)
+			}
+			sharedSecret, err := state.GetSharedSecret()
+			if err != nil || !bytes.Equal(sharedSecret, mustHex(t, session.SharedSecret)) {
+				t.Errorf("session %d: failed to derive the shared secret, got %x, %v", i, sharedSecret, err)
+			}
+		}
+
+		var key [16]byte
+		var requestId [8]byte
+		copy(key[:], mustHex(t, session.Key))
+		copy(requestId[:], mustHex(t, session.RequestId))
+
+		derived, err := HKDF(mustHex(t, session.SharedSecret), mustHex(t, session.Salt), requestId)
+		if err != nil || !bytes.Equal(derived, key[:]) {
+			t.Errorf("session %d: failed to derive the session key, got %x, %v", i, derived, err)
+		}
+		for j, message := range session.Messages {
+			wire := mustHex(t, message.CipherMsg)
+			cipherMsg, err := schemaold.ParseCipherMsg(enc.NewBufferReader(wire), true)
+			if err != nil {
+				t.Fatalf("session %d, message %d: %s", i, j, err)
+			}
+			var encryptedMessage EncryptedMessage
+			copy(encryptedMessage.InitializationVector[:], cipherMsg.InitVec)
+			copy(encryptedMessage.AuthenticationTag[:], cipherMsg.AuthNTag)
+			encryptedMessage.EncryptedPayload = cipherMsg.Payload
+
+			plaintext, err := DecryptPayload(key, encryptedMessage, requestId, &DecryptionIv{})
+			if err != nil || !bytes.Equal(plaintext, mustHex(t, message.Plaintext)) {
+				t.Errorf("session %d, message %d: failed to decrypt the message: %v", i, j, err)
+			}
+
+			ivBytes := mustHex(t, message.Iv)
+			iv := &EncryptionIv{counter: binary.BigEndian.Uint32(ivBytes[ivRandomSizeBytes:])}
+			copy(iv.random[:], ivBytes)
+			sealed, err := EncryptPayload(key, mustHex(t, message.Plaintext), requestId, iv)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(sealed.EncryptedPayload, cipherMsg.Payload) || !bytes.Equal(sealed.AuthenticationTag[:], cipherMsg.AuthNTag) {
+				t.Errorf("session %d, message %d: failed to produce the OpenSSL ciphertext, got %x", i, j, sealed.EncryptedPayload)
+			}
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package email
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/net/idna"
+	"net/mail"
+	"strings"
+)
+
+// SubaddressPolicy decides what happens to the +tag of an address such as alice+ndn@ucla.edu.
+type SubaddressPolicy int
+
+const (
+	// SubaddressKeep treats alice+ndn@ucla.edu as a mailbox of its own.
+	SubaddressKeep SubaddressPolicy = iota
+	// SubaddressReject refuses addresses with a +tag.
+	SubaddressReject
+	// SubaddressStrip binds alice+ndn@ucla.edu to alice@ucla.edu.
+	SubaddressStrip
+)
+
+const (
+	subaddressSeparator = "+"
+	maxDomainLength     = 253
+	maxLabelLength      = 63
+)
+
+var (
+	ErrInvalidAddress    = errors.New("invalid email address")
+	ErrDisplayName       = errors.New("email address has a display name")
+	ErrSubaddress        = errors.New("email address has a subaddress")
+	ErrDomainNotAllowed  = errors.New("email domain is not allowed")
+	ErrInvalidDomainRule = errors.New("invalid email domain rule")
+)
+
+// AddressPolicy decides which addresses code emails may be sent to, and the canonical form
+// an address takes before it is bound to an identity: a lowercase local part and a lowercase
+// ASCII domain, with internationalized labels mapped by UTS 46 and encoded in punycode.
+//
+// Domain rules are either a domain, matching only itself, or *.domain, matching every
+// subdomain but not the domain itself.
+type AddressPolicy struct {
+	// AllowedDomains, when not empty, are the only domains accepted.
+	AllowedDomains []string
+	// DeniedDomains are refused even when they are allowed.
+	DeniedDomains []string
+	// AllowDisplayName accepts "Alice" <alice@ucla.edu>, keeping only the address.
+	AllowDisplayName bool
+	Subaddress       SubaddressPolicy
+}
+
+var DefaultAddressPolicy = AddressPolicy{}
+
+// Validate checks that the domain rules are well-formed.
+func (p AddressPolicy) Validate() error {
+	for _, rule := range append(append([]string{}, p.AllowedDomains...), p.DeniedDomains...) {
+		if _, err := normalizeDomainRule(rule); err != nil {
+			return err
+		}
+	}
+	if p.Subaddress < SubaddressKeep || p.Subaddress > SubaddressStrip {
+		return fmt.Errorf("unknown subaddress policy %d", p.Subaddress)
+	}
+	return nil
+}
+
+// Normalize returns the canonical form of address, or why the policy refuses it.
+func (p AddressPolicy) Normalize(address string) (string, error) {
+	parsed, err := mail.ParseAddress(address)
+	if err != nil {
+		return "", fmt.Errorf("%w %q: %s", ErrInvalidAddress, address, err)
+	}
+	if !p.AllowDisplayName && parsed.Address != strings.TrimSpace(address) {
+		return "", fmt.Errorf("%w: %q", ErrDisplayName, address)
+	}
+
+	at := strings.LastIndex(parsed.Address, "@")
+	local, domain := strings.ToLower(parsed.Address[:at]), parsed.Address[at+1:]
+	if tag := strings.Index(local, subaddressSeparator); tag >= 0 {
+		switch p.Subaddress {
+		case SubaddressReject:
+			return "", fmt.Errorf("%w: %q", ErrSubaddress, address)
+		case SubaddressStrip:
+			local = local[:tag]
+		}
+	}
+	if local == "" {
+		return "", fmt.Errorf("%w %q: empty local part", ErrInvalidAddress, address)
+	}
+
+	domain, err = normalizeDomain(domain)
+	if err != nil {
+		return "", fmt.Errorf("%w %q: %s", ErrInvalidAddress, address, err)
+	}
+	if !p.domainAllowed(domain) {
+		return "", fmt.Errorf("%w: %s", ErrDomainNotAllowed, domain)
+	}
+	return local + "@" + domain, nil
+}
+
+func (p AddressPolicy) domainAllowed(domain string) bool {
+	for _, rule := range p.DeniedDomains {
+		if matchDomainRule(rule, domain) {
+			return false
+		}
+	}
+	if len(p.AllowedDomains) == 0 {
+		return true
+	}
+	for _, rule := range p.AllowedDomains {
+		if matchDomainRule(rule, domain) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchDomainRule(rule string, domain string) bool {
+	rule, err := normalizeDomainRule(rule)
+	if err != nil {
+		return false
+	}
+	if strings.HasPrefix(rule, "*.") {
+		return strings.HasSuffix(domain, rule[1:])
+	}
+	return domain == rule
+}
+
+func normalizeDomainRule(rule string) (string, error) {
+	wildcard := strings.HasPrefix(rule, "*.")
+	domain, err := normalizeDomain(strings.TrimPrefix(rule, "*."))
+	if err != nil {
+		return "", fmt.Errorf("%w %q: %s", ErrInvalidDomainRule, rule, err)
+	}
+	if wildcard {
+		return "*." + domain, nil
+	}
+	return domain, nil
+}
+
+// normalizeDomain maps domain with UTS 46 for lookup, as browsers and mail clients do,
+// so Unicode and ACE forms of one domain normalize alike. Domain literals such as
+// [192.0.2.1] are refused, since they name no identity.
+func normalizeDomain(domain string) (string, error) {
+	domain = strings.TrimSuffix(domain, ".")
+	if domain == "" {
+		return "", errors.New("empty domain")
+	}
+	domain, err := idna.Lookup.ToASCII(domain)
+	if err != nil {
+		return "", err
+	}
+	for _, label := range strings.Split(domain, ".") {
+		if err := checkLabel(label); err != nil {
+			return "", err
+		}
+	}
+	if len(domain) > maxDomainLength {
+		return "", fmt.Errorf("domain is longer than %d characters", maxDomainLength)
+	}
+	return domain, nil
+}
+
+func checkLabel(label string) error {
+	if label == "" || len(label) > maxLabelLength {
+		return fmt.Errorf("domain label %q is empty or longer than %d characters", label, maxLabelLength)
+	}
+	if label[0] == '-' || label[len(label)-1] == '-' {
+		return fmt.Errorf("domain label %q starts or ends with a hyphen", label)
+	}
+	for _, c := range []byte(label) {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-') {
+			return fmt.Errorf("domain label %q has the invalid character %q", label, c)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package email
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNormalizeDomain(t *testing.T) {
+	// Labels from RFC 3492, section 7.1, and UTS 46 mappings of case, width and dots.
+	for domain, expected := range map[string]string{
+		"münchen.de":            "xn--mnchen-3ya.de",
+		"ドメイン名例.jp":             "xn--eckwd4c7cu47r2wf.jp",
+		"majiでkoiする5秒前.jp":      "xn--majikoi5-783gue6qz075azm5e.jp",
+		"BÜCHER.example":        "xn--bcher-kva.example",
+		"bu\u0308cher.example":  "xn--bcher-kva.example",
+		"xn--bcher-kva.example": "xn--bcher-kva.example",
+		"ｕｃｌａ．ｅｄｕ":              "ucla.edu",
+		"faß.de":                "xn--fa-hia.de",
+		"UCLA.edu.":             "ucla.edu",
+	} {
+		if normalized, err := normalizeDomain(domain); err != nil || normalized != expected {
+			t.Errorf("failed to normalize %q to %q, got %q, %v", domain, expected, normalized, err)
+		}
+	}
+	for _, domain := range []string{"xn--a.example", "bad_label.example", "-ucla.edu", "ucla..edu"} {
+		if normalized, err := normalizeDomain(domain); err == nil {
+			t.Errorf("failed to refuse %q, got %q", domain, normalized)
+		}
+	}
+}
+
+func TestAddressPolicyNormalize(t *testing.T) {
+	policy := AddressPolicy{
+		AllowedDomains: []string{"ucla.edu", "*.ucla.edu", "bücher.example"},
+		DeniedDomains:  []string{"*.guest.ucla.edu"},
+	}
+	if err := policy.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		address  string
+		expected string
+		err      error
+	}{
+		{"Alice@UCLA.Edu", "alice@ucla.edu", nil},
+		{"alice@CS.ucla.edu", "alice@cs.ucla.edu", nil},
+		{"alice@Bücher.example", "alice@xn--bcher-kva.example", nil},
+		{"alice+ndn@ucla.edu", "alice+ndn@ucla.edu", nil},
+		{`"Eve" <eve@ucla.edu>`, "", ErrDisplayName},
+		{"<eve@ucla.edu>", "", ErrDisplayName},
+		{"eve@ucla.edu.evil.com", "", ErrDomainNotAllowed},
+		{"eve@evilucla.edu", "", ErrDomainNotAllowed},
+		{"eve@lab.guest.ucla.edu", "", ErrDomainNotAllowed},
+		{"eve@[192.0.2.1]", "", ErrInvalidAddress},
+		{"eve@-ucla.edu", "", ErrInvalidAddress},
+		{"not an address", "", ErrInvalidAddress},
+	} {
+		normalized, err := policy.Normalize(test.address)
+		if normalized != test.expected || !errors.Is(err, test.err) {
+			t.Errorf("failed to normalize %q to %q, got %q, %v", test.address, test.expected, normalized, err)
+		}
+	}
+
+	policy.AllowDisplayName = true
+	policy.Subaddress = SubaddressStrip
+	if normalized, err := policy.Normalize(`"Alice" <Alice+NDN@ucla.edu>`); err != nil || normalized != "alice@ucla.edu" {
+		t.Errorf("failed to strip the display name and subaddress, got %q, %v", normalized, err)
+	}
+	policy.Subaddress = SubaddressReject
+	if _, err := policy.Normalize("alice+ndn@ucla.edu"); !errors.Is(err, ErrSubaddress) {
+		t.Errorf("failed to reject a subaddress, got %v", err)
+	}
+
+	if err := (AddressPolicy{DeniedDomains: []string{"*.bad_domain"}}).Validate(); !errors.Is(err, ErrInvalidDomainRule) {
+		t.Errorf("failed to reject a malformed domain rule, got %v", err)
+	}
+}
@@ -3,15 +3,10 @@ package email
 import (
 	"fmt"
 	"gopkg.in/yaml.v3"
-	"net/mail"
-	"net/smtp"
 	"os"
 	"regexp"
 )
 
-const smtpConfigFilePath = "../config/smtp.yml"
-const codeEmailSubjectLine = "Subject: Your NDN Email Challenge Secret Pin"
-
 type Status int
 
 type SMTPAuth struct {
@@ -21,6 +16,20 @@ type SMTPAuth struct {
 		Password string `yaml:"password"`
 		Host     string `yaml:"host"`
 		Port     int64  `yaml:"port"`
+		// TLS is TLSOpportunistic, TLSStartTLS or TLSImplicit, and defaults to TLSOpportunistic.
+		TLS        string `yaml:"tls"`
+		ServerName string `yaml:"server_name"`
+		RootCAs    string `yaml:"root_cas"`
+		ClientCert string `yaml:"client_cert"`
+		ClientKey  string `yaml:"client_key"`
+		// Auth is AuthPlain, AuthLogin, AuthCramMD5 or AuthNone, and defaults to AuthPlain.
+		Auth string `yaml:"auth"`
+		// DKIM signs outgoing mail when PrivateKey is set.
+		DKIM struct {
+			Domain     string `yaml:"domain"`
+			Selector   string `yaml:"selector"`
+			PrivateKey string `yaml:"private_key"`
+		} `yaml:"dkim"`
 	}
 }
 
@@ -29,14 +38,16 @@ type CodeEmail struct {
 	ChallengeCode  string
 }
 
+var defaultCodePattern = regexp.MustCompile("^\\d{6}$")
+
 const (
 	Success Status = iota
 	Invalid
 	Error
 )
 
-func readSmtpConfig() (*SMTPAuth, error) {
-	buf, err := os.ReadFile(smtpConfigFilePath)
+func readSmtpConfig(path string) (*SMTPAuth, error) {
+	buf, err := os.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
@@ -44,20 +55,25 @@ func readSmtpConfig() (*SMTPAuth, error) {
 	c := &SMTPAuth{}
 	err = yaml.Unmarshal(buf, c)
 	if err != nil {
-		return nil, fmt.Errorf("in file %q: %w", smtpConfigFilePath, err)
+		return nil, fmt.Errorf("in file %q: %w", path, err)
 	}
 
 	return c, err
 }
 
 func NewCodeEmail(e string, c string) (CodeEmail, Status, error) {
-	_, emailErr := mail.ParseAddress(e)
+	return NewCodeEmailWithPattern(e, c, defaultCodePattern)
+}
+
+// NewCodeEmailWithPattern is NewCodeEmail for codes that are not six digits, such as
+// alphanumeric codes or passphrases, which must match codePattern instead.
+func NewCodeEmailWithPattern(e string, c string, codePattern *regexp.Regexp) (CodeEmail, Status, error) {
+	e, emailErr := DefaultAddressPolicy.Normalize(e)
 	if emailErr != nil {
-		return CodeEmail{}, Invalid, fmt.Errorf("invalid email address %s: failed to match regex", e)
+		return CodeEmail{}, Invalid, emailErr
 	}
 
-	codeMatcher := regexp.MustCompile("^\\d{6}$")
-	isMatch := codeMatcher.Match([]byte(c))
+	isMatch := codePattern.Match([]byte(c))
 	if !isMatch {
 		return CodeEmail{}, Invalid, fmt.Errorf("invalid code %s: failed to match regex", c)
 	}
@@ -65,28 +81,32 @@ func NewCodeEmail(e string, c string) (CodeEmail, Status, error) {
 	return CodeEmail{e, c}, Success, nil
 }
 
-func (c CodeEmail) SendCodeEmail() (Status, error) {
-	conf, readSmtpConfigErr := readSmtpConfig()
-	if readSmtpConfigErr != nil {
-		return Error, fmt.Errorf("failed to read config file from path: %s", smtpConfigFilePath)
-	}
+// Send delivers the code email through mailer, rendered with the default templates.
+func (c CodeEmail) Send(mailer Mailer) (Status, error) {
+	return c.SendWithDetails(mailer, MessageDetails{}, DefaultTemplates())
+}
 
-	address := fmt.Sprintf("%s:%d", conf.Smtp.Host, conf.Smtp.Port)
-	auth := smtp.PlainAuth(conf.Smtp.Identity, conf.Smtp.Username, conf.Smtp.Password, conf.Smtp.Host)
-	from := conf.Smtp.Identity
-	to := []string{c.ChallengeEmail}
-	subject := fmt.Sprintf("From: <%s>\r\nTo: <%s>\r\n%s\r\n\r\n",
-		from,
-		to,
-		codeEmailSubjectLine)
-	body := fmt.Sprintf("Secret  PIN: %s\r\n", c.ChallengeCode)
-	message := []byte(subject + body)
+// SendWithDetails delivers the code email through mailer, rendered with the templates of details.Locale.
+func (c CodeEmail) SendWithDetails(mailer Mailer, details MessageDetails, templates *TemplateSet) (Status, error) {
+	message, composeErr := c.Compose(mailer.Sender(), details, templates)
+	if composeErr != nil {
+		return Error, fmt.Errorf("failed to compose code challenge email: %w", composeErr)
+	}
 
-	sendMailErr := smtp.SendMail(address, auth, from, to, message)
+	sendMailErr := mailer.Send([]string{c.ChallengeEmail}, message)
 
 	if sendMailErr != nil {
-		return Error, fmt.Errorf("failed to send code challenge email to %s", c.ChallengeEmail)
+		return Error, fmt.Errorf("failed to send code challenge email to %s: %w", c.ChallengeEmail, sendMailErr)
 	}
 
 	return Success, nil
 }
+
+// Enqueue hands the code email to mailer and returns the ID its delivery is tracked with.
+func (c CodeEmail) Enqueue(mailer TrackedMailer, details MessageDetails, templates *TemplateSet) (string, error) {
+	message, composeErr := c.Compose(mailer.Sender(), details, templates)
+	if composeErr != nil {
+		return "", fmt.Errorf("failed to compose code challenge email: %w", composeErr)
+	}
+	return mailer.Enqueue([]string{c.ChallengeEmail}, message)
+}
@@ -1,6 +1,9 @@
 package email
 
-import "testing"
+import (
+	"regexp"
+	"testing"
+)
 
 func TestNewCodeEmailBadEmailAddress(t *testing.T) {
 	ce, status, err := NewCodeEmail("bad_email_address", "123456")
@@ -46,3 +49,14 @@ func TestValidCodeEmail(t *testing.T) {
 		t.Error("failed to return nil on receiving valid email and code")
 	}
 }
+
+func TestCodeEmailWithPattern(t *testing.T) {
+	pattern := regexp.MustCompile("^[A-Z2-9]{8}$")
+	if _, status, err := NewCodeEmailWithPattern("good_email_address@gmail.com", "ABCD2345", pattern); status != Success || err != nil {
+		t.Error("failed to accept a code matching the given pattern")
+	}
+
+	if _, status, err := NewCodeEmailWithPattern("good_email_address@gmail.com", "123456", pattern); status != Invalid || err == nil {
+		t.Error("failed to reject a six digit code that does not match the given pattern")
+	}
+}
@@ -0,0 +1,272 @@
+package email
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+const dkimHeaderName = "DKIM-Signature"
+
+// minDKIMRSABits follows RFC 8301, which makes verifiers free to reject shorter keys, and
+// receivers such as Gmail already do.
+const minDKIMRSABits = 2048
+
+// defaultDKIMHeaders are the headers of a composed code email that are signed when present.
+var defaultDKIMHeaders = []string{"From", "To", "Subject", "Date", "Message-ID", "MIME-Version", "Content-Type", "Content-Language"}
+
+// DKIMSigner adds an RFC 6376 DKIM-Signature to messages, with relaxed/relaxed
+// canonicalization and rsa-sha256 or ed25519-sha256 (RFC 8463) signatures.
+type DKIMSigner struct {
+	Domain   string
+	Selector string
+	// Key is an *rsa.PrivateKey or an ed25519.PrivateKey.
+	Key crypto.Signer
+	// Headers are the headers to sign, defaulting to those of a code email.
+	Headers []string
+}
+
+// DKIMMailer signs every message with its DKIMSigner before handing it to the Mailer it wraps.
+type DKIMMailer struct {
+	Mailer
+	Signer *DKIMSigner
+}
+
+// NewDKIMSigner checks that key is an RSA key of at least 2048 bits or an Ed25519 key.
+func NewDKIMSigner(domain string, selector string, key crypto.Signer) (*DKIMSigner, error) {
+	if domain == "" || selector == "" {
+		return nil, errors.New("DKIM signing needs a domain and a selector")
+	}
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		if key.N.BitLen() < minDKIMRSABits {
+			return nil, fmt.Errorf("DKIM RSA key of %d bits is too short, at least %d are needed", key.N.BitLen(), minDKIMRSABits)
+		}
+	case ed25519.PrivateKey:
+	default:
+		return nil, fmt.Errorf("unsupported DKIM key type %T", key)
+	}
+	return &DKIMSigner{Domain: domain, Selector: selector, Key: key}, nil
+}
+
+// LoadDKIMSigner reads a PEM private key in PKCS #8, or PKCS #1 for RSA, from keyPath.
+func LoadDKIMSigner(domain string, selector string, keyPath string) (*DKIMSigner, error) {
+	encoded, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(encoded)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM private key in %s", keyPath)
+	}
+
+	var key any
+	if block.Type == "RSA PRIVATE KEY" {
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	} else {
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("DKIM private key in %s: %w", keyPath, err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported DKIM key type %T", key)
+	}
+	return NewDKIMSigner(domain, selector, signer)
+}
+
+// NewDKIMMailer signs the messages sent through mailer with signer.
+func NewDKIMMailer(mailer Mailer, signer *DKIMSigner) *DKIMMailer {
+	return &DKIMMailer{Mailer: mailer, Signer: signer}
+}
+
+func (m *DKIMMailer) Send(to []string, message []byte) error {
+	signed, err := m.Signer.Sign(message)
+	if err != nil {
+		return fmt.Errorf("failed to sign with DKIM: %w", err)
+	}
+	return m.Mailer.Send(to, signed)
+}
+
+// Sign returns message with a DKIM-Signature header prepended.
+func (s *DKIMSigner) Sign(message []byte) ([]byte, error) {
+	return s.signAt(message, time.Now())
+}
+
+func (s *DKIMSigner) signAt(message []byte, now time.Time) ([]byte, error) {
+	message = toCRLF(message)
+	headers, body := splitMessage(message)
+
+	algorithm := "rsa-sha256"
+	if _, ok := s.Key.(ed25519.PrivateKey); ok {
+		algorithm = "ed25519-sha256"
+	}
+	names := s.Headers
+	if len(names) == 0 {
+		names = defaultDKIMHeaders
+	}
+	signed := selectHeaders(headers, names)
+	signedNames := make([]string, len(signed))
+	for i, header := range signed {
+		signedNames[i] = strings.ToLower(headerName(header))
+	}
+
+	bodyHash := sha256.Sum256(relaxedBody(body))
+	value := fmt.Sprintf(" v=1; a=%s; c=relaxed/relaxed; d=%s; s=%s;\r\n\tt=%d; h=%s;\r\n\tbh=%s;\r\n\tb=",
+		algorithm, s.Domain, s.Selector, now.Unix(), strings.Join(signedNames, ":"),
+		base64.StdEncoding.EncodeToString(bodyHash[:]))
+	signatureHeader := dkimHeaderName + ":" + value
+
+	var data bytes.Buffer
+	for _, header := range signed {
+		data.WriteString(relaxedHeader(header))
+	}
+	data.WriteString(strings.TrimSuffix(relaxedHeader(signatureHeader), "\r\n"))
+
+	signature, err := s.sign(data.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	var output bytes.Buffer
+	output.WriteString(signatureHeader)
+	output.WriteString(base64.StdEncoding.EncodeToString(signature))
+	output.WriteString("\r\n")
+	output.Write(message)
+	return output.Bytes(), nil
+}
+
+func (s *DKIMSigner) sign(data []byte) ([]byte, error) {
+	hash := sha256.Sum256(data)
+	if key, ok := s.Key.(ed25519.PrivateKey); ok {
+		// RFC 8463 signs the SHA-256 hash with PureEdDSA, rather than the data itself.
+		return ed25519.Sign(key, hash[:]), nil
+	}
+	return s.Key.Sign(rand.Reader, hash[:], crypto.SHA256)
+}
+
+// DNSRecord returns the TXT record to publish at <selector>._domainkey.<domain>.
+func (s *DKIMSigner) DNSRecord() (string, error) {
+	switch key := s.Key.Public().(type) {
+	case *rsa.PublicKey:
+		encoded, err := x509.MarshalPKIXPublicKey(key)
+		if err != nil {
+			return "", err
+		}
+		return "v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(encoded), nil
+	case ed25519.PublicKey:
+		return "v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(key), nil
+	default:
+		return "", fmt.Errorf("unsupported DKIM key type %T", key)
+	}
+}
+
+// toCRLF turns bare line feeds into CRLF, the line ending DKIM signatures are computed over.
+func toCRLF(message []byte) []byte {
+	var output bytes.Buffer
+	for i, c := range message {
+		if c == '\n' && (i == 0 || message[i-1] != '\r') {
+			output.WriteByte('\r')
+		}
+		output.WriteByte(c)
+	}
+	return output.Bytes()
+}
+
+// splitMessage returns the header fields of message, each with its folded lines and
+// final CRLF, and the body after the empty line.
+func splitMessage(message []byte) ([]string, []byte) {
+	head, body, found := bytes.Cut(message, []byte("\r\n\r\n"))
+	if !found {
+		head, body = bytes.TrimSuffix(message, []byte("\r\n")), nil
+	}
+
+	var headers []string
+	for _, line := range strings.SplitAfter(string(head)+"\r\n", "\r\n") {
+		if line == "" {
+			continue
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(headers) > 0 {
+			headers[len(headers)-1] += line
+		} else {
+			headers = append(headers, line)
+		}
+	}
+	return headers, body
+}
+
+func headerName(header string) string {
+	name, _, _ := strings.Cut(header, ":")
+	return strings.TrimSpace(name)
+}
+
+// selectHeaders picks the headers to sign in the order of names, taking the last instance
+// of a repeated header first as RFC 6376, section 5.4.2 requires.
+func selectHeaders(headers []string, names []string) []string {
+	used := make(map[int]bool)
+	var selected []string
+	for _, name := range names {
+		for i := len(headers) - 1; i >= 0; i-- {
+			if !used[i] && strings.EqualFold(headerName(headers[i]), name) {
+				used[i] = true
+				selected = append(selected, headers[i])
+				break
+			}
+		}
+	}
+	return selected
+}
+
+// relaxedHeader canonicalizes a header field as in RFC 6376, section 3.4.2.
+func relaxedHeader(header string) string {
+	name, value, _ := strings.Cut(header, ":")
+	value = strings.ReplaceAll(value, "\r\n", "")
+	return strings.ToLower(strings.TrimSpace(name)) + ":" + strings.TrimSpace(compressWhitespace(value)) + "\r\n"
+}
+
+// relaxedBody canonicalizes a body as in RFC 6376, section 3.4.4.
+func relaxedBody(body []byte) []byte {
+	lines := strings.Split(string(body), "\r\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(compressWhitespace(line), " ")
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
+
+func compressWhitespace(s string) string {
+	var output strings.Builder
+	space := false
+	for i := 0; i < len(s); i++ {
+		if s[i] == ' ' || s[i] == '\t' {
+			space = true
+			continue
+		}
+		if space {
+			output.WriteByte(' ')
+			space = false
+		}
+		output.WriteByte(s[i])
+	}
+	if space {
+		output.WriteByte(' ')
+	}
+	return output.String()
+}
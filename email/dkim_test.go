@@ -0,0 +1,267 @@
+package email
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	dkimSignatureValuePattern = regexp.MustCompile(`((?:^|;)\s*b=)[^;]*`)
+	foldingPattern            = regexp.MustCompile(`\r\n([ \t])`)
+	whitespacePattern         = regexp.MustCompile(`[ \t]+`)
+)
+
+// The key and message of RFC 8463, appendix A.
+const (
+	rfc8463Seed      = "nWGxne/9WmC6hEr0kuwsxERJxWl7MmkZcDusAxyuf2A="
+	rfc8463PublicKey = "11qYAYKxCrfVS/7TyWQHOg7hcvPapiMlrwIaaPcHURo="
+	rfc8463Message   = "From: Joe SixPack <joe@football.example.com>\r\n" +
+		"To: Suzie Q <suzie@shopping.example.net>\r\n" +
+		"Subject: Is dinner ready?\r\n" +
+		"Date: Fri, 11 Jul 2003 21:00:37 -0700 (PDT)\r\n" +
+		"Message-ID: <20030712040037.46341.5F8J@football.example.com>\r\n" +
+		"\r\n" +
+		"Hi.\r\n" +
+		"\r\n" +
+		"We lost the game.  Are you hungry yet?\r\n" +
+		"\r\n" +
+		"Joe.\r\n"
+)
+
+// dkimTags parses the tag list of a DKIM-Signature.
+func dkimTags(value string) map[string]string {
+	tags := make(map[string]string)
+	for _, tag := range strings.Split(value, ";") {
+		name, tagValue, found := strings.Cut(tag, "=")
+		if found {
+			tags[strings.TrimSpace(name)] = strings.Join(strings.Fields(tagValue), "")
+		}
+	}
+	return tags
+}
+
+// verifyDKIM checks the first DKIM-Signature of message as a receiving server would. It
+// canonicalizes on its own rather than with the helpers of the signer, and checks itself
+// against the signature of RFC 8463 in TestDKIMVerifyExample.
+func verifyDKIM(message []byte, publicKey crypto.PublicKey) error {
+	head, body, _ := strings.Cut(string(message), "\r\n\r\n")
+	var headers []string
+	for _, line := range strings.Split(foldingPattern.ReplaceAllString(head, "$1"), "\r\n") {
+		name, value, _ := strings.Cut(line, ":")
+		headers = append(headers, strings.ToLower(strings.TrimSpace(name))+":"+
+			strings.TrimSpace(whitespacePattern.ReplaceAllString(value, " ")))
+	}
+	if !strings.HasPrefix(headers[0], "dkim-signature:") {
+		return errors.New("no DKIM-Signature header")
+	}
+	tags := dkimTags(strings.TrimPrefix(headers[0], "dkim-signature:"))
+	if tags["v"] != "1" || tags["c"] != "relaxed/relaxed" {
+		return errors.New("unexpected DKIM version or canonicalization")
+	}
+
+	lines := strings.Split(body, "\r\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(whitespacePattern.ReplaceAllString(line, " "), " ")
+	}
+	canonicalBody := strings.TrimRight(strings.Join(lines, "\r\n"), "\r\n")
+	if canonicalBody != "" {
+		canonicalBody += "\r\n"
+	}
+	bodyHash := sha256.Sum256([]byte(canonicalBody))
+	if tags["bh"] != base64.StdEncoding.EncodeToString(bodyHash[:]) {
+		return errors.New("body hash mismatch")
+	}
+
+	// Each name in h= takes the next instance of that header from the bottom.
+	var data strings.Builder
+	used := make(map[int]bool)
+	for _, name := range strings.Split(strings.ToLower(tags["h"]), ":") {
+		for i := len(headers) - 1; i > 0; i-- {
+			if !used[i] && strings.HasPrefix(headers[i], name+":") {
+				used[i] = true
+				data.WriteString(headers[i] + "\r\n")
+				break
+			}
+		}
+	}
+	data.WriteString(dkimSignatureValuePattern.ReplaceAllString(headers[0], "$1"))
+	hash := sha256.Sum256([]byte(data.String()))
+
+	signature, err := base64.StdEncoding.DecodeString(tags["b"])
+	if err != nil {
+		return err
+	}
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		if tags["a"] != "rsa-sha256" {
+			return errors.New("unexpected algorithm " + tags["a"])
+		}
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], signature)
+	case ed25519.PublicKey:
+		if tags["a"] != "ed25519-sha256" || !ed25519.Verify(key, hash[:], signature) {
+			return errors.New("bad Ed25519 signature")
+		}
+		return nil
+	}
+	return errors.New("unsupported key")
+}
+
+func rfc8463Key(t *testing.T) ed25519.PrivateKey {
+	seed, err := base64.StdEncoding.DecodeString(rfc8463Seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ed25519.NewKeyFromSeed(seed)
+}
+
+func TestDKIMVerifyExample(t *testing.T) {
+	// The signed message of RFC 8463, appendix A.3, with its repeated and spaced h= list.
+	signed := "DKIM-Signature: v=1; a=ed25519-sha256; c=relaxed/relaxed;\r\n" +
+		" d=football.example.com; i=@football.example.com;\r\n" +
+		" q=dns/txt; s=brisbane; t=1528637909; h=from : to :\r\n" +
+		" subject : date : message-id : from : subject : date;\r\n" +
+		" bh=2jUSOH9NhtVGCQWNr9BrIAPreKQjO6Sn7XIkfJVOzv8=;\r\n" +
+		" b=/gCrinpcQOoIfuHNQIbq4pgh9kyIK3AQUdt9OdqQehSwhEIug4D11Bus\r\n" +
+		" Fa3bT3FY5OsU7ZbnKELq+eXdp1Q1Dw==\r\n" + rfc8463Message
+	publicKey, _ := base64.StdEncoding.DecodeString(rfc8463PublicKey)
+	if err := verifyDKIM([]byte(signed), ed25519.PublicKey(publicKey)); err != nil {
+		t.Errorf("failed to verify the signature of RFC 8463: %s", err)
+	}
+	tampered := strings.Replace(signed, "hungry", "thirsty", 1)
+	if err := verifyDKIM([]byte(tampered), ed25519.PublicKey(publicKey)); err == nil {
+		t.Error("failed to detect a changed body in the RFC 8463 message")
+	}
+}
+
+func TestDKIMSignKnownAnswer(t *testing.T) {
+	// Computed for the key and message of RFC 8463 with a separate relaxed canonicalization
+	// and "openssl pkeyutl -sign -rawin" over the SHA-256 hash of the signed headers.
+	expected := "DKIM-Signature: v=1; a=ed25519-sha256; c=relaxed/relaxed; d=football.example.com; s=brisbane;\r\n" +
+		"\tt=1528637909; h=from:to:subject:date:message-id;\r\n" +
+		"\tbh=2jUSOH9NhtVGCQWNr9BrIAPreKQjO6Sn7XIkfJVOzv8=;\r\n" +
+		"\tb=9NaSCHHe0iASR5k3Fsc/l6fyAWFFSBv882Vnl9CfkgcaCrtBQV91Mu+dIjUGRybbHnD+lJnhWKuKccZeUfJ4Aw==\r\n" +
+		rfc8463Message
+	signer, err := NewDKIMSigner("football.example.com", "brisbane", rfc8463Key(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := signer.signAt([]byte(rfc8463Message), time.Unix(1528637909, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(signed) != expected {
+		t.Errorf("failed to produce the known DKIM signature, got %q", signed)
+	}
+}
+
+func TestDKIMCanonicalization(t *testing.T) {
+	// The example of RFC 6376, section 3.4.5.
+	headers, body := splitMessage([]byte("A: X\r\nB : Y\t\r\n\tZ  \r\n\r\n C \r\nD \t E\r\n\r\n\r\n"))
+	var canonical strings.Builder
+	for _, header := range headers {
+		canonical.WriteString(relaxedHeader(header))
+	}
+	if canonical.String() != "a:X\r\nb:Y Z\r\n" {
+		t.Errorf("failed to canonicalize the headers, got %q", canonical.String())
+	}
+	if string(relaxedBody(body)) != " C\r\nD E\r\n" {
+		t.Errorf("failed to canonicalize the body, got %q", relaxedBody(body))
+	}
+	if relaxedBody([]byte("\r\n\r\n")) != nil {
+		t.Error("failed to canonicalize an empty body to nothing")
+	}
+}
+
+func TestDKIMSign(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, ed25519Key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	codeEmail, _, _ := NewCodeEmail("alice@ucla.edu", "123456")
+	message, err := codeEmail.Compose("ca@ndn.example", MessageDetails{CaName: "/ndn", Locale: "en"}, DefaultTemplates())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []crypto.Signer{rsaKey, ed25519Key} {
+		signer, err := NewDKIMSigner("ndn.example", "ca", key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mailer := &MemoryMailer{From: "ca@ndn.example"}
+		if err := NewDKIMMailer(mailer, signer).Send([]string{"alice@ucla.edu"}, message); err != nil {
+			t.Fatal(err)
+		}
+		signed := mailer.Messages()[0].Data
+
+		if err := verifyDKIM(signed, key.Public()); err != nil {
+			t.Errorf("failed to sign a verifiable message with %T: %s", key, err)
+		}
+		if !bytes.HasSuffix(signed, message) {
+			t.Errorf("failed to leave the message intact when signing with %T", key)
+		}
+		tags := dkimTags(strings.SplitN(string(signed), "\r\n\t", 2)[0])
+		if tags["d"] != "ndn.example" || tags["s"] != "ca" {
+			t.Errorf("failed to name the domain and selector, got %v", tags)
+		}
+
+		tampered := bytes.Replace(signed, []byte("123456"), []byte("654321"), 1)
+		if err := verifyDKIM(tampered, key.Public()); err == nil {
+			t.Errorf("failed to detect a changed body with %T", key)
+		}
+		tampered = bytes.Replace(signed, []byte("To: <alice@ucla.edu>"), []byte("To: <eve@ucla.edu>"), 1)
+		if err := verifyDKIM(tampered, key.Public()); err == nil {
+			t.Errorf("failed to detect a changed recipient with %T", key)
+		}
+	}
+}
+
+func TestLoadDKIMSigner(t *testing.T) {
+	dir := t.TempDir()
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	_, ed25519Key, _ := ed25519.GenerateKey(rand.Reader)
+	ed25519Der, _ := x509.MarshalPKCS8PrivateKey(ed25519Key)
+	for name, block := range map[string]*pem.Block{
+		"rsa.pem":     {Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)},
+		"ed25519.pem": {Type: "PRIVATE KEY", Bytes: ed25519Der},
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), pem.EncodeToMemory(block), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for name, keyType := range map[string]string{"rsa.pem": "k=rsa", "ed25519.pem": "k=ed25519"} {
+		signer, err := LoadDKIMSigner("ndn.example", "ca", filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if record, err := signer.DNSRecord(); err != nil || !strings.Contains(record, keyType) {
+			t.Errorf("failed to describe the %s key in the DNS record, got %q", name, record)
+		}
+	}
+
+	for _, bits := range []int{512, 1024} {
+		smallKey, _ := rsa.GenerateKey(rand.Reader, bits)
+		if _, err := NewDKIMSigner("ndn.example", "ca", smallKey); err == nil {
+			t.Errorf("failed to refuse a %d-bit RSA key", bits)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package email
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Mailer delivers composed messages. Sender is the address the messages are sent from.
+type Mailer interface {
+	Sender() string
+	Send(to []string, message []byte) error
+}
+
+// Message is a message delivered through a Mailer.
+type Message struct {
+	From string
+	To   []string
+	Data []byte
+}
+
+// MaildirMailer delivers messages into the new/ directory of a maildir.
+type MaildirMailer struct {
+	Dir  string
+	From string
+}
+
+// MboxMailer appends messages to an mbox file.
+type MboxMailer struct {
+	Path  string
+	From  string
+	mutex sync.Mutex
+}
+
+// MemoryMailer keeps the messages it is given, for tests and local development.
+type MemoryMailer struct {
+	From     string
+	mutex    sync.Mutex
+	messages []Message
+}
+
+func (m *MaildirMailer) Sender() string {
+	return m.From
+}
+
+// Send writes the message to tmp/ and renames it into new/, so readers never see a partial message.
+func (m *MaildirMailer) Send(to []string, message []byte) error {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(m.Dir, sub), 0700); err != nil {
+			return err
+		}
+	}
+
+	unique := make([]byte, 8)
+	if _, err := rand.Read(unique); err != nil {
+		return err
+	}
+	hostname, _ := os.Hostname()
+	fileName := fmt.Sprintf("%d.%d_%s.%s", time.Now().Unix(), os.Getpid(), hex.EncodeToString(unique),
+		strings.NewReplacer("/", "\\057", ":", "\\072").Replace(hostname))
+
+	tmpPath := filepath.Join(m.Dir, "tmp", fileName)
+	if err := os.WriteFile(tmpPath, message, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, filepath.Join(m.Dir, "new", fileName))
+}
+
+func (m *MboxMailer) Sender() string {
+	return m.From
+}
+
+// Send appends the message after a "From " separator line, quoting body lines that start with "From ".
+func (m *MboxMailer) Send(to []string, message []byte) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var entry bytes.Buffer
+	fmt.Fprintf(&entry, "From %s %s\n", m.From, time.Now().UTC().Format(time.ANSIC))
+	for _, line := range strings.Split(strings.ReplaceAll(string(message), "\r\n", "\n"), "\n") {
+		if strings.HasPrefix(strings.TrimLeft(line, ">"), "From ") {
+			entry.WriteString(">")
+		}
+		entry.WriteString(line)
+		entry.WriteString("\n")
+	}
+	entry.WriteString("\n")
+
+	file, err := os.OpenFile(m.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(entry.Bytes()); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func (m *MemoryMailer) Sender() string {
+	return m.From
+}
+
+func (m *MemoryMailer) Send(to []string, message []byte) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.messages = append(m.messages, Message{
+		From: m.From,
+		To:   append([]string{}, to...),
+		Data: append([]byte{}, message...),
+	})
+	return nil
+}
+
+// Messages returns the messages sent so far, oldest first.
+func (m *MemoryMailer) Messages() []Message {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return append([]Message{}, m.messages...)
+}
@@ -0,0 +1,71 @@
+package email
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMemoryMailer(t *testing.T) {
+	mailer := &MemoryMailer{From: "ca@ndn.example"}
+	codeEmail, _, _ := NewCodeEmail("alice@ucla.edu", "123456")
+	if status, err := codeEmail.Send(mailer); status != Success || err != nil {
+		t.Fatalf("failed to send through the memory mailer: %v", err)
+	}
+
+	messages := mailer.Messages()
+	if len(messages) != 1 || messages[0].To[0] != "alice@ucla.edu" || messages[0].From != "ca@ndn.example" {
+		t.Fatalf("failed to capture the message, got %+v", messages)
+	}
+	if !strings.Contains(string(messages[0].Data), "123456") {
+		t.Error("failed to include the code in the captured message")
+	}
+}
+
+func TestMaildirMailer(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "Maildir")
+	mailer := &MaildirMailer{Dir: dir, From: "ca@ndn.example"}
+	for _, code := range []string{"123456", "654321"} {
+		codeEmail, _, _ := NewCodeEmail("alice@ucla.edu", code)
+		if status, err := codeEmail.Send(mailer); status != Success || err != nil {
+			t.Fatalf("failed to deliver into the maildir: %v", err)
+		}
+	}
+
+	delivered, err := os.ReadDir(filepath.Join(dir, "new"))
+	if err != nil || len(delivered) != 2 {
+		t.Fatalf("failed to deliver one file per message into new/, got %d, %v", len(delivered), err)
+	}
+	if pending, _ := os.ReadDir(filepath.Join(dir, "tmp")); len(pending) != 0 {
+		t.Error("failed to move the delivered messages out of tmp/")
+	}
+}
+
+func TestMboxMailer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mbox")
+	mailer := &MboxMailer{Path: path, From: "ca@ndn.example"}
+	if err := mailer.Send([]string{"alice@ucla.edu"}, []byte("Subject: first\r\n\r\nFrom the CA\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := mailer.Send([]string{"bob@ucla.edu"}, []byte("Subject: second\r\n\r\nbody\r\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	mbox, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	separators := 0
+	for _, line := range strings.Split(string(mbox), "\n") {
+		if strings.HasPrefix(line, "From ca@ndn.example ") {
+			separators++
+		}
+	}
+	if separators != 2 {
+		t.Errorf("failed to separate the two messages, found %d separators in %q", separators, mbox)
+	}
+	if !strings.Contains(string(mbox), "\n>From the CA\n") {
+		t.Error("failed to quote a body line starting with From")
+	}
+}
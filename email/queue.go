@@ -0,0 +1,305 @@
+package email
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DeliveryStatus is the progress of a message handed to a Queue.
+type DeliveryStatus int
+
+const (
+	DeliveryQueued DeliveryStatus = iota
+	DeliverySending
+	DeliveryRetrying
+	DeliveryDelivered
+	DeliveryFailed
+)
+
+// deliveryEvictionInterval is how often the statuses of finished deliveries are swept.
+const deliveryEvictionInterval = time.Minute
+
+var (
+	ErrQueueFull       = errors.New("email queue is full")
+	ErrQueueClosed     = errors.New("email queue is closed")
+	ErrUnknownDelivery = errors.New("unknown delivery")
+	ErrMessageExpired  = errors.New("message expired before it was delivered")
+)
+
+// TrackedMailer is a Mailer that keeps delivering after Send returns, and reports the
+// delivery of each message it accepted.
+type TrackedMailer interface {
+	Mailer
+	Enqueue(to []string, message []byte) (string, error)
+	Status(deliveryId string) (DeliveryStatus, error)
+}
+
+// QueueConfig bounds the work of a Queue. Zero fields take the defaults in parentheses.
+type QueueConfig struct {
+	// Workers is the number of messages sent concurrently (2).
+	Workers int
+	// Capacity is the number of messages waiting for a worker before Enqueue fails (100).
+	Capacity int
+	// MaxAttempts is the number of sends before a message is given up (5).
+	MaxAttempts int
+	// InitialBackoff is the delay before the first retry, doubled for every later one (1s).
+	InitialBackoff time.Duration
+	// MaxBackoff caps the delay between retries (5m).
+	MaxBackoff time.Duration
+	// MaxAge gives up a message not delivered within it, so a challenge email is not sent
+	// once its code has expired. Messages never expire when zero.
+	MaxAge time.Duration
+	// Retention is how long the status of a delivered or failed message is kept (1h).
+	Retention time.Duration
+}
+
+// Queue delivers messages through a Mailer in the background, retrying failures with
+// exponential backoff. It is a TrackedMailer, so it can replace the Mailer it wraps.
+// Messages are kept in memory only, since challenge emails carry their codes.
+type Queue struct {
+	mailer     Mailer
+	config     QueueConfig
+	jobs       chan *queueEntry
+	stop       chan struct{}
+	workers    sync.WaitGroup
+	mutex      sync.Mutex
+	deliveries map[string]*delivery
+	closed     bool
+	// nextEviction is when finished deliveries are next swept.
+	nextEviction time.Time
+}
+
+type delivery struct {
+	status  DeliveryStatus
+	lastErr error
+	// finished is when the delivery was delivered or failed, and zero until then.
+	finished time.Time
+}
+
+// queueEntry is a message waiting for delivery.
+type queueEntry struct {
+	Id          string
+	To          []string
+	Message     []byte
+	Attempts    int
+	NextAttempt time.Time
+	Created     time.Time
+}
+
+func (s DeliveryStatus) String() string {
+	switch s {
+	case DeliveryQueued:
+		return "queued"
+	case DeliverySending:
+		return "sending"
+	case DeliveryRetrying:
+		return "retrying"
+	case DeliveryDelivered:
+		return "delivered"
+	case DeliveryFailed:
+		return "failed"
+	default:
+		return fmt.Sprintf("DeliveryStatus(%d)", int(s))
+	}
+}
+
+// NewQueue starts the workers of a queue in front of mailer.
+func NewQueue(mailer Mailer, config QueueConfig) (*Queue, error) {
+	if config.Workers <= 0 {
+		config.Workers = 2
+	}
+	if config.Capacity <= 0 {
+		config.Capacity = 100
+	}
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = 5
+	}
+	if config.InitialBackoff <= 0 {
+		config.InitialBackoff = time.Second
+	}
+	if config.MaxBackoff <= 0 {
+		config.MaxBackoff = 5 * time.Minute
+	}
+	if config.Retention <= 0 {
+		config.Retention = time.Hour
+	}
+
+	q := &Queue{
+		mailer:     mailer,
+		config:     config,
+		jobs:       make(chan *queueEntry, config.Capacity),
+		stop:       make(chan struct{}),
+		deliveries: make(map[string]*delivery),
+	}
+	for i := 0; i < config.Workers; i++ {
+		q.workers.Add(1)
+		go q.work()
+	}
+	return q, nil
+}
+
+func (q *Queue) Sender() string {
+	return q.mailer.Sender()
+}
+
+// Send enqueues the message, so errors of the delivery itself are only seen through Status.
+func (q *Queue) Send(to []string, message []byte) error {
+	_, err := q.Enqueue(to, message)
+	return err
+}
+
+// Enqueue accepts a message for delivery and returns the ID to query its status with.
+func (q *Queue) Enqueue(to []string, message []byte) (string, error) {
+	unique := make([]byte, 16)
+	if _, err := rand.Read(unique); err != nil {
+		return "", err
+	}
+	entry := &queueEntry{
+		Id:      hex.EncodeToString(unique),
+		To:      append([]string{}, to...),
+		Message: append([]byte{}, message...),
+		Created: time.Now(),
+	}
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if q.closed {
+		return "", ErrQueueClosed
+	}
+	q.evictFinished(entry.Created)
+	select {
+	case q.jobs <- entry:
+	default:
+		return "", ErrQueueFull
+	}
+	q.deliveries[entry.Id] = &delivery{status: DeliveryQueued}
+	return entry.Id, nil
+}
+
+// Status returns the progress of a delivery, and the last error when it is retrying or failed.
+func (q *Queue) Status(deliveryId string) (DeliveryStatus, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	d, ok := q.deliveries[deliveryId]
+	if !ok {
+		return DeliveryFailed, ErrUnknownDelivery
+	}
+	return d.status, d.lastErr
+}
+
+// Close stops the workers once the messages being sent are done. Messages still waiting
+// are dropped.
+func (q *Queue) Close() {
+	q.mutex.Lock()
+	if q.closed {
+		q.mutex.Unlock()
+		return
+	}
+	q.closed = true
+	close(q.stop)
+	q.mutex.Unlock()
+	q.workers.Wait()
+}
+
+func (q *Queue) work() {
+	defer q.workers.Done()
+	for {
+		select {
+		case <-q.stop:
+			return
+		case entry := <-q.jobs:
+			q.deliver(entry)
+		}
+	}
+}
+
+func (q *Queue) deliver(entry *queueEntry) {
+	if q.expired(entry, time.Now()) {
+		q.mutex.Lock()
+		defer q.mutex.Unlock()
+		q.finish(entry, DeliveryFailed, ErrMessageExpired)
+		return
+	}
+	q.setStatus(entry.Id, DeliverySending, nil)
+	err := q.mailer.Send(entry.To, entry.Message)
+	entry.Attempts++
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if err == nil {
+		q.finish(entry, DeliveryDelivered, nil)
+		return
+	}
+	if entry.Attempts >= q.config.MaxAttempts {
+		q.finish(entry, DeliveryFailed, err)
+		return
+	}
+
+	entry.NextAttempt = time.Now().Add(q.backoff(entry.Attempts))
+	q.deliveries[entry.Id] = &delivery{status: DeliveryRetrying, lastErr: err}
+	if !q.closed {
+		q.schedule(entry)
+	}
+}
+
+// finish records how the delivery of entry ended. q.mutex must be held.
+func (q *Queue) finish(entry *queueEntry, status DeliveryStatus, err error) {
+	q.deliveries[entry.Id] = &delivery{status: status, lastErr: err, finished: time.Now()}
+}
+
+// evictFinished forgets the deliveries that finished more than Retention ago. q.mutex must be held.
+func (q *Queue) evictFinished(now time.Time) {
+	if now.Before(q.nextEviction) {
+		return
+	}
+	q.nextEviction = now.Add(deliveryEvictionInterval)
+	for id, d := range q.deliveries {
+		if !d.finished.IsZero() && now.Sub(d.finished) > q.config.Retention {
+			delete(q.deliveries, id)
+		}
+	}
+}
+
+// expired reports whether entry is older than MaxAge.
+func (q *Queue) expired(entry *queueEntry, now time.Time) bool {
+	return q.config.MaxAge > 0 && now.Sub(entry.Created) > q.config.MaxAge
+}
+
+// backoff is InitialBackoff doubled for every attempt after the first, up to MaxBackoff.
+func (q *Queue) backoff(attempts int) time.Duration {
+	delay := q.config.InitialBackoff
+	for i := 1; i < attempts && delay < q.config.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > q.config.MaxBackoff {
+		delay = q.config.MaxBackoff
+	}
+	return delay
+}
+
+// schedule hands entry back to the workers once its next attempt is due.
+func (q *Queue) schedule(entry *queueEntry) {
+	go func() {
+		timer := time.NewTimer(time.Until(entry.NextAttempt))
+		defer timer.Stop()
+		select {
+		case <-q.stop:
+			return
+		case <-timer.C:
+		}
+		select {
+		case <-q.stop:
+		case q.jobs <- entry:
+		}
+	}()
+}
+
+func (q *Queue) setStatus(deliveryId string, status DeliveryStatus, err error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.deliveries[deliveryId] = &delivery{status: status, lastErr: err}
+}
@@ -0,0 +1,154 @@
+package email
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyMailer fails the first failures sends, then delivers into a MemoryMailer.
+type flakyMailer struct {
+	MemoryMailer
+	failures int
+	attempts int
+	mutex    sync.Mutex
+}
+
+func (m *flakyMailer) Send(to []string, message []byte) error {
+	m.mutex.Lock()
+	m.attempts++
+	failed := m.attempts <= m.failures
+	m.mutex.Unlock()
+	if failed {
+		return errors.New("421 try again later")
+	}
+	return m.MemoryMailer.Send(to, message)
+}
+
+// blockingMailer holds every send until release is closed.
+type blockingMailer struct {
+	MemoryMailer
+	release chan struct{}
+}
+
+func (m *blockingMailer) Send(to []string, message []byte) error {
+	<-m.release
+	return m.MemoryMailer.Send(to, message)
+}
+
+func waitForDelivery(t *testing.T, queue *Queue, deliveryId string, want DeliveryStatus) error {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		status, err := queue.Status(deliveryId)
+		if status == want {
+			return err
+		}
+		time.Sleep(time.Millisecond)
+	}
+	status, _ := queue.Status(deliveryId)
+	t.Fatalf("delivery %s did not become %s, still %s", deliveryId, want, status)
+	return nil
+}
+
+func TestQueueRetries(t *testing.T) {
+	mailer := &flakyMailer{failures: 2}
+	queue, err := NewQueue(mailer, QueueConfig{InitialBackoff: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer queue.Close()
+
+	deliveryId, err := queue.Enqueue([]string{"alice@ucla.edu"}, []byte("message"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForDelivery(t, queue, deliveryId, DeliveryDelivered)
+	if mailer.attempts != 3 || len(mailer.Messages()) != 1 {
+		t.Errorf("failed to deliver on the third attempt, got %d attempts", mailer.attempts)
+	}
+
+	giveUp, _ := NewQueue(&flakyMailer{failures: 10}, QueueConfig{MaxAttempts: 3, InitialBackoff: time.Millisecond})
+	defer giveUp.Close()
+	deliveryId, _ = giveUp.Enqueue([]string{"alice@ucla.edu"}, []byte("message"))
+	if err := waitForDelivery(t, giveUp, deliveryId, DeliveryFailed); err == nil {
+		t.Error("failed to report the last error of a failed delivery")
+	}
+}
+
+func TestQueueBackoff(t *testing.T) {
+	queue := &Queue{config: QueueConfig{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second}}
+	for attempts, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 5 * time.Second, 30: 5 * time.Second} {
+		if got := queue.backoff(attempts); got != want {
+			t.Errorf("failed to back off %s after %d attempts, got %s", want, attempts, got)
+		}
+	}
+}
+
+func TestQueueClose(t *testing.T) {
+	mailer := &flakyMailer{failures: 1}
+	queue, err := NewQueue(mailer, QueueConfig{InitialBackoff: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	deliveryId, _ := queue.Enqueue([]string{"alice@ucla.edu"}, []byte("your code is 123456"))
+	waitForDelivery(t, queue, deliveryId, DeliveryRetrying)
+	queue.Close()
+
+	if _, err := queue.Enqueue([]string{"alice@ucla.edu"}, []byte("message")); !errors.Is(err, ErrQueueClosed) {
+		t.Errorf("failed to refuse a message after Close, got %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if len(mailer.Messages()) != 0 {
+		t.Error("failed to drop the waiting message on Close")
+	}
+}
+
+func TestQueueMaxAge(t *testing.T) {
+	queue, err := NewQueue(&flakyMailer{failures: 10}, QueueConfig{MaxAge: 20 * time.Millisecond, InitialBackoff: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer queue.Close()
+	deliveryId, _ := queue.Enqueue([]string{"alice@ucla.edu"}, []byte("message"))
+	if err := waitForDelivery(t, queue, deliveryId, DeliveryFailed); !errors.Is(err, ErrMessageExpired) {
+		t.Errorf("failed to give up a message past its age, got %v", err)
+	}
+}
+
+func TestQueueEviction(t *testing.T) {
+	queue, err := NewQueue(&MemoryMailer{}, QueueConfig{Retention: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer queue.Close()
+	delivered, _ := queue.Enqueue([]string{"alice@ucla.edu"}, []byte("first"))
+	waitForDelivery(t, queue, delivered, DeliveryDelivered)
+
+	time.Sleep(10 * time.Millisecond)
+	queue.mutex.Lock()
+	queue.nextEviction = time.Time{}
+	queue.mutex.Unlock()
+	if _, err := queue.Enqueue([]string{"alice@ucla.edu"}, []byte("second")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := queue.Status(delivered); !errors.Is(err, ErrUnknownDelivery) {
+		t.Errorf("failed to evict a finished delivery after the retention period, got %v", err)
+	}
+}
+
+func TestQueueCapacity(t *testing.T) {
+	mailer := &blockingMailer{release: make(chan struct{})}
+	queue, _ := NewQueue(mailer, QueueConfig{Workers: 1, Capacity: 1})
+	defer queue.Close()
+	defer close(mailer.release)
+
+	first, _ := queue.Enqueue([]string{"alice@ucla.edu"}, []byte("first"))
+	waitForDelivery(t, queue, first, DeliverySending)
+	if _, err := queue.Enqueue([]string{"alice@ucla.edu"}, []byte("second")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := queue.Enqueue([]string{"alice@ucla.edu"}, []byte("third")); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("failed to refuse a message beyond the capacity, got %v", err)
+	}
+}
@@ -0,0 +1,242 @@
+package email
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// TLS modes of an SMTP connection.
+const (
+	// TLSOpportunistic upgrades the connection with STARTTLS when the server offers it, as smtp.SendMail does.
+	TLSOpportunistic = "opportunistic"
+	// TLSStartTLS refuses to send unless the connection is upgraded with STARTTLS.
+	TLSStartTLS = "starttls"
+	// TLSImplicit connects with TLS from the start, usually on port 465.
+	TLSImplicit = "implicit"
+)
+
+// Authentication mechanisms of an SMTP connection.
+const (
+	AuthPlain   = "plain"
+	AuthLogin   = "login"
+	AuthCramMD5 = "cram-md5"
+	AuthNone    = "none"
+)
+
+var ErrStartTLSUnavailable = errors.New("SMTP server does not offer STARTTLS")
+
+// SMTPMailer submits messages to an SMTP server.
+type SMTPMailer struct {
+	Config    SMTPAuth
+	tlsOnce   sync.Once
+	tlsConfig *tls.Config
+	tlsErr    error
+}
+
+// NewSMTPMailer checks conf and loads its certificates once, for every later send.
+func NewSMTPMailer(conf SMTPAuth) (*SMTPMailer, error) {
+	mailer := &SMTPMailer{Config: conf}
+	switch conf.Smtp.TLS {
+	case "", TLSOpportunistic, TLSStartTLS, TLSImplicit:
+	default:
+		return nil, fmt.Errorf("unknown SMTP TLS mode %q", conf.Smtp.TLS)
+	}
+	switch conf.Smtp.Auth {
+	case "", AuthPlain, AuthLogin, AuthCramMD5, AuthNone:
+	default:
+		return nil, fmt.Errorf("unknown SMTP authentication mechanism %q", conf.Smtp.Auth)
+	}
+
+	if _, err := mailer.loadTLSConfig(); err != nil {
+		return nil, err
+	}
+	return mailer, nil
+}
+
+// LoadSMTPMailer reads the SMTP configuration at path once, for every later send.
+func LoadSMTPMailer(path string) (*SMTPMailer, error) {
+	conf, err := readSmtpConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewSMTPMailer(*conf)
+}
+
+// NewQueuedMailer delivers through mailer from a background queue, signing with DKIM when
+// the configuration of mailer has a key.
+func NewQueuedMailer(mailer *SMTPMailer, config QueueConfig) (*Queue, error) {
+	var transport Mailer = mailer
+	if dkim := mailer.Config.Smtp.DKIM; dkim.PrivateKey != "" {
+		signer, err := LoadDKIMSigner(dkim.Domain, dkim.Selector, dkim.PrivateKey)
+		if err != nil {
+			return nil, err
+		}
+		transport = NewDKIMMailer(mailer, signer)
+	}
+	return NewQueue(transport, config)
+}
+
+func (conf SMTPAuth) newTLSConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName: conf.Smtp.ServerName,
+		MinVersion: tls.VersionTLS12,
+	}
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = conf.Smtp.Host
+	}
+
+	if conf.Smtp.RootCAs != "" {
+		bundle, err := os.ReadFile(conf.Smtp.RootCAs)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read SMTP root CAs: %w", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(bundle) {
+			return nil, fmt.Errorf("no certificate found in SMTP root CAs %s", conf.Smtp.RootCAs)
+		}
+	}
+
+	if conf.Smtp.ClientCert != "" || conf.Smtp.ClientKey != "" {
+		certificate, err := tls.LoadX509KeyPair(conf.Smtp.ClientCert, conf.Smtp.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load SMTP client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+	return tlsConfig, nil
+}
+
+// loadTLSConfig builds the TLS config on first use, also for a mailer not made by
+// NewSMTPMailer, and shares it between concurrent sends.
+func (m *SMTPMailer) loadTLSConfig() (*tls.Config, error) {
+	m.tlsOnce.Do(func() {
+		m.tlsConfig, m.tlsErr = m.Config.newTLSConfig()
+	})
+	return m.tlsConfig, m.tlsErr
+}
+
+func (m *SMTPMailer) Sender() string {
+	return m.Config.Smtp.Identity
+}
+
+func (m *SMTPMailer) Send(to []string, message []byte) error {
+	conf := m.Config.Smtp
+	tlsConfig, err := m.loadTLSConfig()
+	if err != nil {
+		return err
+	}
+
+	address := net.JoinHostPort(conf.Host, strconv.FormatInt(conf.Port, 10))
+	var client *smtp.Client
+	if conf.TLS == TLSImplicit {
+		conn, err := tls.Dial("tcp", address, tlsConfig)
+		if err != nil {
+			return err
+		}
+		client, err = smtp.NewClient(conn, conf.Host)
+		if err != nil {
+			conn.Close()
+			return err
+		}
+	} else {
+		client, err = smtp.Dial(address)
+		if err != nil {
+			return err
+		}
+	}
+	defer client.Close()
+
+	if conf.TLS != TLSImplicit {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			if err := client.StartTLS(tlsConfig); err != nil {
+				return err
+			}
+		} else if conf.TLS == TLSStartTLS {
+			return ErrStartTLSUnavailable
+		}
+	}
+
+	if auth := m.auth(); auth != nil {
+		if ok, _ := client.Extension("AUTH"); !ok {
+			return fmt.Errorf("SMTP server does not support authentication")
+		}
+		if err := client.Auth(auth); err != nil {
+			return err
+		}
+	}
+
+	if err := client.Mail(m.Sender()); err != nil {
+		return err
+	}
+	for _, recipient := range to {
+		if err := client.Rcpt(recipient); err != nil {
+			return err
+		}
+	}
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := writer.Write(message); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+func (m *SMTPMailer) auth() smtp.Auth {
+	conf := m.Config.Smtp
+	switch conf.Auth {
+	case AuthNone:
+		return nil
+	case AuthLogin:
+		return &loginAuth{username: conf.Username, password: conf.Password, host: conf.Host}
+	case AuthCramMD5:
+		return smtp.CRAMMD5Auth(conf.Username, conf.Password)
+	default:
+		return smtp.PlainAuth(conf.Identity, conf.Username, conf.Password, conf.Host)
+	}
+}
+
+// loginAuth implements the LOGIN mechanism, which some servers offer instead of PLAIN.
+// Like smtp.PlainAuth, it only sends the password over TLS or to localhost.
+type loginAuth struct {
+	username, password, host string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, errors.New("unencrypted connection")
+	}
+	if server.Name != a.host {
+		return "", nil, errors.New("wrong host name")
+	}
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	switch string(fromServer) {
+	case "Username:", "User Name\x00":
+		return []byte(a.username), nil
+	case "Password:", "Password\x00":
+		return []byte(a.password), nil
+	default:
+		return nil, fmt.Errorf("unexpected LOGIN challenge %q", fromServer)
+	}
+}
+
+func isLocalhost(name string) bool {
+	return name == "localhost" || name == "127.0.0.1" || name == "::1"
+}
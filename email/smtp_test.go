@@ -0,0 +1,398 @@
+package email
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testPKI is a CA with a server certificate for 127.0.0.1 and a client certificate,
+// written as PEM files for the SMTP configuration.
+type testPKI struct {
+	caFile, clientCertFile, clientKeyFile string
+	serverCert                            tls.Certificate
+	caPool                                *x509.CertPool
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	dir := t.TempDir()
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test SMTP CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDer, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert, _ := x509.ParseCertificate(caDer)
+
+	issue := func(serial int64, usage x509.ExtKeyUsage) ([]byte, *ecdsa.PrivateKey) {
+		key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: "127.0.0.1"},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+			ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return der, key
+	}
+	writePem := func(name, blockType string, der []byte) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	serverDer, serverKey := issue(2, x509.ExtKeyUsageServerAuth)
+	clientDer, clientKey := issue(3, x509.ExtKeyUsageClientAuth)
+	clientKeyDer, _ := x509.MarshalECPrivateKey(clientKey)
+
+	pki := &testPKI{
+		caFile:         writePem("ca.pem", "CERTIFICATE", caDer),
+		clientCertFile: writePem("client.pem", "CERTIFICATE", clientDer),
+		clientKeyFile:  writePem("client-key.pem", "EC PRIVATE KEY", clientKeyDer),
+		serverCert:     tls.Certificate{Certificate: [][]byte{serverDer}, PrivateKey: serverKey},
+		caPool:         x509.NewCertPool(),
+	}
+	pki.caPool.AddCert(caCert)
+	return pki
+}
+
+type receivedMail struct {
+	mechanism  string
+	tls        bool
+	clientCert bool
+	from       string
+	to         []string
+	data       string
+}
+
+// fakeSmtpServer is an in-process stand-in for an SMTP server, speaking just enough of
+// the protocol for the client: EHLO, STARTTLS, AUTH PLAIN/LOGIN/CRAM-MD5, MAIL, RCPT and DATA.
+type fakeSmtpServer struct {
+	listener          net.Listener
+	tlsConfig         *tls.Config
+	implicitTLS       bool
+	offerStartTLS     bool
+	username          string
+	password          string
+	requireClientCert bool
+	mutex             sync.Mutex
+	received          []receivedMail
+}
+
+func startFakeSmtpServer(t *testing.T, pki *testPKI, server *fakeSmtpServer) *fakeSmtpServer {
+	server.tlsConfig = &tls.Config{Certificates: []tls.Certificate{pki.serverCert}}
+	if server.requireClientCert {
+		server.tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		server.tlsConfig.ClientCAs = pki.caPool
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.listener = listener
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+	return server
+}
+
+func (s *fakeSmtpServer) config(tlsMode string, auth string, pki *testPKI) SMTPAuth {
+	var conf SMTPAuth
+	_, port, _ := net.SplitHostPort(s.listener.Addr().String())
+	conf.Smtp.Identity = "ca@ndn.example"
+	conf.Smtp.Username = s.username
+	conf.Smtp.Password = s.password
+	conf.Smtp.Host = "127.0.0.1"
+	conf.Smtp.Port, _ = strconv.ParseInt(port, 10, 64)
+	conf.Smtp.TLS = tlsMode
+	conf.Smtp.Auth = auth
+	conf.Smtp.RootCAs = pki.caFile
+	return conf
+}
+
+func (s *fakeSmtpServer) mails() []receivedMail {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]receivedMail{}, s.received...)
+}
+
+func (s *fakeSmtpServer) serve(conn net.Conn) {
+	defer func() { conn.Close() }()
+	var mail receivedMail
+	if s.implicitTLS {
+		tlsConn := tls.Server(conn, s.tlsConfig)
+		if tlsConn.Handshake() != nil {
+			return
+		}
+		conn = tlsConn
+		mail.tls = true
+		mail.clientCert = len(tlsConn.ConnectionState().PeerCertificates) > 0
+	}
+
+	reader := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+	readLine := func() (string, bool) {
+		line, err := reader.ReadString('\n')
+		return strings.TrimRight(line, "\r\n"), err == nil
+	}
+	decode := func(line string) string {
+		decoded, _ := base64.StdEncoding.DecodeString(line)
+		return string(decoded)
+	}
+	authenticated := func(username, password string) {
+		if username == s.username && password == s.password {
+			reply("235 authenticated")
+		} else {
+			mail.mechanism = ""
+			reply("535 bad credentials")
+		}
+	}
+
+	reply("220 fake ESMTP")
+	for {
+		line, ok := readLine()
+		if !ok {
+			return
+		}
+		verb, argument, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			reply("250-fake")
+			if s.offerStartTLS && !mail.tls {
+				reply("250-STARTTLS")
+			}
+			reply("250 AUTH PLAIN LOGIN CRAM-MD5")
+		case "STARTTLS":
+			reply("220 ready")
+			tlsConn := tls.Server(conn, s.tlsConfig)
+			if tlsConn.Handshake() != nil {
+				return
+			}
+			conn = tlsConn
+			reader = bufio.NewReader(conn)
+			mail.tls = true
+			mail.clientCert = len(tlsConn.ConnectionState().PeerCertificates) > 0
+		case "AUTH":
+			mechanism, initial, _ := strings.Cut(argument, " ")
+			mail.mechanism = mechanism
+			switch mechanism {
+			case "PLAIN":
+				fields := strings.Split(decode(initial), "\x00")
+				if len(fields) != 3 {
+					reply("501 malformed")
+					continue
+				}
+				authenticated(fields[1], fields[2])
+			case "LOGIN":
+				reply("334 " + base64.StdEncoding.EncodeToString([]byte("Username:")))
+				username, _ := readLine()
+				reply("334 " + base64.StdEncoding.EncodeToString([]byte("Password:")))
+				password, _ := readLine()
+				authenticated(decode(username), decode(password))
+			case "CRAM-MD5":
+				challenge := "<1896.697170952@fake>"
+				reply("334 " + base64.StdEncoding.EncodeToString([]byte(challenge)))
+				response, _ := readLine()
+				username, digest, _ := strings.Cut(decode(response), " ")
+				mac := hmac.New(md5.New, []byte(s.password))
+				mac.Write([]byte(challenge))
+				if username == s.username && digest == hex.EncodeToString(mac.Sum(nil)) {
+					reply("235 authenticated")
+				} else {
+					reply("535 bad credentials")
+				}
+			default:
+				reply("504 unsupported")
+			}
+		case "MAIL":
+			mail.from = strings.Trim(strings.TrimPrefix(argument, "FROM:"), "<>")
+			reply("250 ok")
+		case "RCPT":
+			mail.to = append(mail.to, strings.Trim(strings.TrimPrefix(argument, "TO:"), "<>"))
+			reply("250 ok")
+		case "DATA":
+			reply("354 go ahead")
+			var data strings.Builder
+			for {
+				dataLine, ok := readLine()
+				if !ok {
+					return
+				}
+				if dataLine == "." {
+					break
+				}
+				data.WriteString(dataLine + "\n")
+			}
+			mail.data = data.String()
+			s.mutex.Lock()
+			s.received = append(s.received, mail)
+			s.mutex.Unlock()
+			reply("250 queued")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+func TestSMTPMailerTLSModes(t *testing.T) {
+	pki := newTestPKI(t)
+	tests := []struct {
+		name       string
+		server     *fakeSmtpServer
+		tlsMode    string
+		auth       string
+		clientCert bool
+		wantTLS    bool
+	}{
+		{"implicit TLS with PLAIN", &fakeSmtpServer{implicitTLS: true}, TLSImplicit, AuthPlain, false, true},
+		{"mandatory STARTTLS with LOGIN", &fakeSmtpServer{offerStartTLS: true}, TLSStartTLS, AuthLogin, false, true},
+		{"opportunistic STARTTLS with CRAM-MD5", &fakeSmtpServer{offerStartTLS: true}, "", AuthCramMD5, false, true},
+		{"cleartext with CRAM-MD5", &fakeSmtpServer{}, TLSOpportunistic, AuthCramMD5, false, false},
+		{"client certificate", &fakeSmtpServer{implicitTLS: true, requireClientCert: true}, TLSImplicit, AuthNone, true, true},
+	}
+
+	for _, test := range tests {
+		test.server.username, test.server.password = "user", "secret"
+		server := startFakeSmtpServer(t, pki, test.server)
+		conf := server.config(test.tlsMode, test.auth, pki)
+		if test.clientCert {
+			conf.Smtp.ClientCert, conf.Smtp.ClientKey = pki.clientCertFile, pki.clientKeyFile
+		}
+
+		mailer, err := NewSMTPMailer(conf)
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		if err := mailer.Send([]string{"alice@ucla.edu"}, []byte("Subject: test\r\n\r\nSecret  PIN: 123456\r\n")); err != nil {
+			t.Errorf("%s: failed to send: %s", test.name, err)
+			continue
+		}
+
+		mails := server.mails()
+		if len(mails) != 1 {
+			t.Errorf("%s: failed to deliver exactly one message, got %d", test.name, len(mails))
+			continue
+		}
+		mail := mails[0]
+		if mail.tls != test.wantTLS || mail.clientCert != test.clientCert {
+			t.Errorf("%s: got TLS %v and client certificate %v", test.name, mail.tls, mail.clientCert)
+		}
+		if wantMechanism := strings.ToUpper(test.auth); test.auth != AuthNone && mail.mechanism != wantMechanism {
+			t.Errorf("%s: failed to authenticate with %s, got %q", test.name, wantMechanism, mail.mechanism)
+		}
+		if mail.from != "ca@ndn.example" || len(mail.to) != 1 || mail.to[0] != "alice@ucla.edu" ||
+			!strings.Contains(mail.data, "123456") {
+			t.Errorf("%s: failed to deliver the message, got %+v", test.name, mail)
+		}
+	}
+}
+
+func TestSMTPMailerConcurrentSends(t *testing.T) {
+	pki := newTestPKI(t)
+	server := startFakeSmtpServer(t, pki, &fakeSmtpServer{implicitTLS: true, username: "user", password: "secret"})
+	// A mailer not made by NewSMTPMailer builds its TLS config on the first sends, which run
+	// concurrently as they do from the workers of a Queue.
+	mailer := &SMTPMailer{Config: server.config(TLSImplicit, AuthPlain, pki)}
+
+	const senders = 4
+	errs := make(chan error, senders)
+	for i := 0; i < senders; i++ {
+		go func() {
+			errs <- mailer.Send([]string{"alice@ucla.edu"}, []byte("Subject: test\r\n\r\nbody\r\n"))
+		}()
+	}
+	for i := 0; i < senders; i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("failed to send concurrently: %s", err)
+		}
+	}
+	if mails := server.mails(); len(mails) != senders {
+		t.Errorf("failed to deliver every message, got %d", len(mails))
+	}
+}
+
+func TestSMTPMailerRefusals(t *testing.T) {
+	pki := newTestPKI(t)
+	message := []byte("Subject: test\r\n\r\nbody\r\n")
+
+	noStartTLS := startFakeSmtpServer(t, pki, &fakeSmtpServer{username: "user", password: "secret"})
+	mailer, _ := NewSMTPMailer(noStartTLS.config(TLSStartTLS, AuthPlain, pki))
+	if err := mailer.Send([]string{"alice@ucla.edu"}, message); !errors.Is(err, ErrStartTLSUnavailable) {
+		t.Errorf("failed to refuse a server without STARTTLS, got %v", err)
+	}
+	if len(noStartTLS.mails()) != 0 {
+		t.Error("failed to withhold the message from a server without STARTTLS")
+	}
+
+	implicit := startFakeSmtpServer(t, pki, &fakeSmtpServer{implicitTLS: true, username: "user", password: "secret"})
+	untrusting := implicit.config(TLSImplicit, AuthPlain, pki)
+	untrusting.Smtp.RootCAs = newTestPKI(t).caFile
+	mailer, _ = NewSMTPMailer(untrusting)
+	if err := mailer.Send([]string{"alice@ucla.edu"}, message); err == nil {
+		t.Error("failed to reject a server certificate from an untrusted CA")
+	}
+
+	wrongPassword := implicit.config(TLSImplicit, AuthLogin, pki)
+	wrongPassword.Smtp.Password = "wrong"
+	mailer, _ = NewSMTPMailer(wrongPassword)
+	if err := mailer.Send([]string{"alice@ucla.edu"}, message); err == nil {
+		t.Error("failed to report rejected credentials")
+	}
+
+	requireCert := startFakeSmtpServer(t, pki, &fakeSmtpServer{implicitTLS: true, requireClientCert: true})
+	mailer, _ = NewSMTPMailer(requireCert.config(TLSImplicit, AuthNone, pki))
+	if err := mailer.Send([]string{"alice@ucla.edu"}, message); err == nil {
+		t.Error("failed to report a missing client certificate")
+	}
+
+	var conf SMTPAuth
+	conf.Smtp.TLS = "ssl"
+	if _, err := NewSMTPMailer(conf); err == nil {
+		t.Error("failed to reject an unknown TLS mode")
+	}
+}
@@ -0,0 +1,253 @@
+package email
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	htmltemplate "html/template"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+const (
+	subjectTemplateFile = "subject.txt"
+	textTemplateFile    = "body.txt"
+	htmlTemplateFile    = "body.html"
+	defaultLocale       = "en"
+)
+
+// localePattern accepts language tags such as en, fr-CA and zh_Hant_TW, and nothing that
+// could break out of the Content-Language header.
+var localePattern = regexp.MustCompile(`^[A-Za-z]{1,8}(?:[-_][A-Za-z0-9]{1,8})*$`)
+
+const defaultSubjectTemplate = `Your NDN certificate challenge code{{if .CaName}} from {{.CaName}}{{end}}`
+
+const defaultTextTemplate = `Hello,
+
+{{if .CaName}}The NDN certificate authority {{.CaName}}{{else}}An NDN certificate authority{{end}} received a request for a certificate{{if .CertName}} for {{.CertName}}{{end}} that uses this email address.
+
+Your secret code is: {{.Code}}
+{{if .Link}}
+Instead of entering the code, you can confirm the request at:
+{{.Link}}
+{{end}}{{if not .Expiry.IsZero}}
+The code expires at {{.Expiry.UTC.Format "2006-01-02 15:04:05 MST"}}.
+{{end}}
+If you did not request this certificate, you can ignore this email.
+`
+
+const defaultHTMLTemplate = `<!DOCTYPE html>
+<html>
+<body>
+<p>Hello,</p>
+<p>{{if .CaName}}The NDN certificate authority <b>{{.CaName}}</b>{{else}}An NDN certificate authority{{end}} received a request for a certificate{{if .CertName}} for <code>{{.CertName}}</code>{{end}} that uses this email address.</p>
+<p>Your secret code is: <strong>{{.Code}}</strong></p>
+{{if .Link}}<p>Instead of entering the code, you can <a href="{{.Link}}">confirm the request</a>.</p>{{end}}
+{{if not .Expiry.IsZero}}<p>The code expires at {{.Expiry.UTC.Format "2006-01-02 15:04:05 MST"}}.</p>{{end}}
+<p>If you did not request this certificate, you can ignore this email.</p>
+</body>
+</html>
+`
+
+// MessageDetails are the parts of a code email that describe the request rather than the challenge.
+type MessageDetails struct {
+	CaName   string
+	CertName string
+	Expiry   time.Time
+	Locale   string
+	// Link completes the challenge without the code when set.
+	Link string
+}
+
+// TemplateData is what the templates of a code email are executed with.
+type TemplateData struct {
+	MessageDetails
+	Email string
+	Code  string
+}
+
+// Templates render the subject, plain text and HTML body of a code email in one locale.
+type Templates struct {
+	Subject *texttemplate.Template
+	Text    *texttemplate.Template
+	HTML    *htmltemplate.Template
+}
+
+// TemplateSet holds the templates of every locale and the locale used when none matches.
+type TemplateSet struct {
+	locales  map[string]*Templates
+	fallback string
+}
+
+// DefaultTemplates returns the built-in English templates.
+func DefaultTemplates() *TemplateSet {
+	templates, err := parseTemplates(defaultLocale, defaultSubjectTemplate, defaultTextTemplate, defaultHTMLTemplate)
+	if err != nil {
+		panic(err.Error())
+	}
+	return &TemplateSet{locales: map[string]*Templates{defaultLocale: templates}, fallback: defaultLocale}
+}
+
+// LoadTemplates reads one directory per locale from dir, each with subject.txt, body.txt
+// and body.html, e.g. dir/en/body.txt and dir/fr/body.txt. fallback must be one of them.
+func LoadTemplates(dir string, fallback string) (*TemplateSet, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	set := &TemplateSet{locales: make(map[string]*Templates), fallback: fallback}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		locale := entry.Name()
+		var sources [3]string
+		for i, file := range []string{subjectTemplateFile, textTemplateFile, htmlTemplateFile} {
+			source, err := os.ReadFile(filepath.Join(dir, locale, file))
+			if err != nil {
+				return nil, fmt.Errorf("templates of locale %s: %w", locale, err)
+			}
+			sources[i] = string(source)
+		}
+		templates, err := parseTemplates(locale, strings.TrimSpace(sources[0]), sources[1], sources[2])
+		if err != nil {
+			return nil, err
+		}
+		set.locales[normalizeLocale(locale)] = templates
+	}
+
+	if _, ok := set.locales[normalizeLocale(fallback)]; !ok {
+		return nil, fmt.Errorf("no templates in %s for the fallback locale %s", dir, fallback)
+	}
+	return set, nil
+}
+
+func parseTemplates(locale string, subject string, text string, html string) (*Templates, error) {
+	subjectTemplate, err := texttemplate.New(locale + "/" + subjectTemplateFile).Parse(subject)
+	if err != nil {
+		return nil, err
+	}
+	textTemplate, err := texttemplate.New(locale + "/" + textTemplateFile).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	htmlTemplate, err := htmltemplate.New(locale + "/" + htmlTemplateFile).Parse(html)
+	if err != nil {
+		return nil, err
+	}
+	return &Templates{Subject: subjectTemplate, Text: textTemplate, HTML: htmlTemplate}, nil
+}
+
+// Lookup returns the templates of locale, then of its language (fr for fr-CA), then of the fallback locale.
+func (s *TemplateSet) Lookup(locale string) *Templates {
+	locale = normalizeLocale(locale)
+	if templates, ok := s.locales[locale]; ok {
+		return templates
+	}
+	if language, _, found := strings.Cut(locale, "-"); found {
+		if templates, ok := s.locales[language]; ok {
+			return templates
+		}
+	}
+	return s.locales[normalizeLocale(s.fallback)]
+}
+
+func normalizeLocale(locale string) string {
+	return strings.ToLower(strings.ReplaceAll(locale, "_", "-"))
+}
+
+// Compose renders the code email as a multipart/alternative message with a plain text and an HTML part.
+func (c CodeEmail) Compose(from string, details MessageDetails, set *TemplateSet) ([]byte, error) {
+	if !localePattern.MatchString(details.Locale) {
+		details.Locale = ""
+	}
+	templates := set.Lookup(details.Locale)
+	data := TemplateData{MessageDetails: details, Email: c.ChallengeEmail, Code: c.ChallengeCode}
+
+	var subject, text, html bytes.Buffer
+	if err := templates.Subject.Execute(&subject, data); err != nil {
+		return nil, err
+	}
+	if err := templates.Text.Execute(&text, data); err != nil {
+		return nil, err
+	}
+	if err := templates.HTML.Execute(&html, data); err != nil {
+		return nil, err
+	}
+
+	messageId, err := newMessageId(from)
+	if err != nil {
+		return nil, err
+	}
+
+	var body bytes.Buffer
+	parts := multipart.NewWriter(&body)
+	for _, part := range []struct {
+		contentType string
+		content     []byte
+	}{
+		{"text/plain; charset=utf-8", text.Bytes()},
+		{"text/html; charset=utf-8", html.Bytes()},
+	} {
+		writer, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		encoder := quotedprintable.NewWriter(writer)
+		if _, err := encoder.Write(part.content); err != nil {
+			return nil, err
+		}
+		if err := encoder.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := parts.Close(); err != nil {
+		return nil, err
+	}
+
+	var message bytes.Buffer
+	headers := [][2]string{
+		{"From", (&mail.Address{Address: from}).String()},
+		{"To", (&mail.Address{Address: c.ChallengeEmail}).String()},
+		{"Subject", mime.QEncoding.Encode("utf-8", strings.TrimSpace(subject.String()))},
+		{"Date", time.Now().Format(time.RFC1123Z)},
+		{"Message-ID", messageId},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", mime.FormatMediaType("multipart/alternative", map[string]string{"boundary": parts.Boundary()})},
+	}
+	if details.Locale != "" {
+		headers = append(headers, [2]string{"Content-Language", details.Locale})
+	}
+	for _, header := range headers {
+		fmt.Fprintf(&message, "%s: %s\r\n", header[0], header[1])
+	}
+	message.WriteString("\r\n")
+	message.Write(body.Bytes())
+	return message.Bytes(), nil
+}
+
+func newMessageId(from string) (string, error) {
+	unique := make([]byte, 16)
+	if _, err := rand.Read(unique); err != nil {
+		return "", err
+	}
+	domain := "localhost"
+	if at := strings.LastIndex(from, "@"); at >= 0 && at < len(from)-1 {
+		domain = from[at+1:]
+	}
+	return fmt.Sprintf("<%s@%s>", hex.EncodeToString(unique), domain), nil
+}
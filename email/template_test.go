@@ -0,0 +1,132 @@
+package email
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readParts parses a composed message and returns its headers and the decoded body of each part by content type.
+func readParts(t *testing.T, message []byte) (mail.Header, map[string]string) {
+	parsed, err := mail.ReadMessage(bytes.NewReader(message))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mediaType, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("failed to compose a multipart/alternative message, got %q", parsed.Header.Get("Content-Type"))
+	}
+
+	bodies := make(map[string]string)
+	reader := multipart.NewReader(parsed.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		contentType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		body, _ := io.ReadAll(part)
+		bodies[contentType] = string(body)
+	}
+	return parsed.Header, bodies
+}
+
+func TestComposeCodeEmail(t *testing.T) {
+	codeEmail, _, _ := NewCodeEmail("alice@ucla.edu", "123456")
+	details := MessageDetails{
+		CaName:   "/ndn/edu/ucla",
+		CertName: "/ndn/edu/ucla/alice/KEY/1",
+		Expiry:   time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+	message, err := codeEmail.Compose("ca@ndn.example", details, DefaultTemplates())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header, bodies := readParts(t, message)
+	if header.Get("To") != "<alice@ucla.edu>" || header.Get("From") != "<ca@ndn.example>" {
+		t.Errorf("failed to format the address headers, got To %q From %q", header.Get("To"), header.Get("From"))
+	}
+	if header.Get("MIME-Version") != "1.0" || !strings.HasSuffix(header.Get("Message-ID"), "@ndn.example>") {
+		t.Errorf("failed to set MIME-Version and Message-ID, got %q and %q", header.Get("MIME-Version"), header.Get("Message-ID"))
+	}
+	if _, err := header.Date(); err != nil {
+		t.Errorf("failed to set a valid Date: %s", err)
+	}
+	if !strings.Contains(header.Get("Subject"), "/ndn/edu/ucla") {
+		t.Errorf("failed to name the CA in the subject, got %q", header.Get("Subject"))
+	}
+
+	for _, contentType := range []string{"text/plain", "text/html"} {
+		body := bodies[contentType]
+		for _, expected := range []string{"123456", "/ndn/edu/ucla/alice/KEY/1", "2030-01-02 03:04:05 UTC"} {
+			if !strings.Contains(body, expected) {
+				t.Errorf("failed to include %q in the %s part, got %q", expected, contentType, body)
+			}
+		}
+	}
+
+	details.Link = "https://ca.ndn.example/confirm?token=abc"
+	message, err = codeEmail.Compose("ca@ndn.example", details, DefaultTemplates())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, bodies := readParts(t, message); !strings.Contains(bodies["text/plain"], details.Link) ||
+		!strings.Contains(bodies["text/html"], `href="https://ca.ndn.example/confirm?token=abc"`) {
+		t.Errorf("failed to include the magic link, got %q", bodies)
+	}
+}
+
+func TestLocalizedTemplates(t *testing.T) {
+	dir := t.TempDir()
+	for locale, greeting := range map[string]string{"en": "Hello", "fr": "Bonjour"} {
+		if err := os.MkdirAll(filepath.Join(dir, locale), 0700); err != nil {
+			t.Fatal(err)
+		}
+		files := map[string]string{
+			subjectTemplateFile: greeting + " {{.Email}}\n",
+			textTemplateFile:    greeting + ", code {{.Code}}\n",
+			htmlTemplateFile:    "<p>" + greeting + ", code {{.Code}}</p>\n",
+		}
+		for name, content := range files {
+			if err := os.WriteFile(filepath.Join(dir, locale, name), []byte(content), 0600); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	set, err := LoadTemplates(dir, "en")
+	if err != nil {
+		t.Fatal(err)
+	}
+	codeEmail, _, _ := NewCodeEmail("alice@ucla.edu", "123456")
+
+	for locale, greeting := range map[string]string{"fr_CA": "Bonjour", "FR": "Bonjour", "de": "Hello", "": "Hello"} {
+		message, err := codeEmail.Compose("ca@ndn.example", MessageDetails{Locale: locale}, set)
+		if err != nil {
+			t.Fatal(err)
+		}
+		header, bodies := readParts(t, message)
+		if header.Get("Subject") != greeting+" alice@ucla.edu" || bodies["text/plain"] != greeting+", code 123456\r\n" {
+			t.Errorf("failed to pick the %s templates for locale %q, got %q", greeting, locale, bodies["text/plain"])
+		}
+	}
+
+	message, _ := codeEmail.Compose("ca@ndn.example", MessageDetails{Locale: "fr\r\nBcc: mallory@example.com"}, set)
+	if header, _ := readParts(t, message); header.Get("Bcc") != "" || header.Get("Content-Language") != "" {
+		t.Error("failed to drop a locale that injects a header")
+	}
+
+	if _, err := LoadTemplates(dir, "es"); err == nil {
+		t.Error("failed to reject a fallback locale without templates")
+	}
+}
@@ -0,0 +1,388 @@
+// Package keychain manages the identity keys and certificates of the CA itself.
+//
+// A keychain is a directory holding one pair of files per key, named after the
+// hex-encoded key ID (the component following KEY in the key name):
+//
+//	<key-id>.key   the private key as a PKCS#8 "PRIVATE KEY" PEM block
+//	<key-id>.cert  the NDN certificate of the key, as the base64-encoded Data TLV
+//
+// The .cert file is in the same format ndnsec uses for exported certificates,
+// so a certificate issued by a parent CA can be dropped in as-is. A key
+// without a .cert file is kept but never used for signing.
+//
+// Several keys may be valid at once. The active key is the one whose
+// certificate became valid most recently, which lets a replacement key be
+// installed ahead of time and take over once its validity period begins,
+// while certificates signed by the outgoing key remain verifiable until the
+// outgoing certificate expires.
+package keychain
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	enc "github.com/zjkmxy/go-ndn/pkg/encoding"
+	"github.com/zjkmxy/go-ndn/pkg/ndn"
+	"github.com/zjkmxy/go-ndn/pkg/ndn/spec_2022"
+	"github.com/zjkmxy/go-ndn/pkg/utils"
+	ndncrypto "ndn/ndncert/challenge/crypto"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+type KeyType int
+
+const (
+	KeyTypeEcdsa KeyType = iota
+	KeyTypeEd25519
+	// KeyTypeRsa is refused for CA keys: go-ndn cannot encode a SignatureValue longer than
+	// 252 bytes, so only RSA keys of 2016 bits or less could sign, and those are too weak.
+	KeyTypeRsa
+)
+
+var ErrRsaKey = errors.New("RSA keys cannot be CA keys, since go-ndn cannot encode signatures of RSA keys of 2048 bits or more")
+
+const keyIdSizeBytes = 8
+
+const keyComponent = "KEY"
+const selfIssuerComponent = "self"
+const keyFileExtension = ".key"
+const certFileExtension = ".cert"
+const pemTypePrivateKey = "PRIVATE KEY"
+
+type Key struct {
+	Name        enc.Name
+	privateKey  crypto.Signer
+	certificate ndn.Data
+	certWire    []byte
+}
+
+type Keychain struct {
+	dir      string
+	identity enc.Name
+	keys     []*Key
+	mutex    sync.RWMutex
+}
+
+// Open loads every key and certificate stored in dir for the given CA identity, creating dir if needed.
+func Open(dir string, identity enc.Name) (*Keychain, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	kc := &Keychain{dir: dir, identity: identity}
+
+	keyFiles, err := filepath.Glob(filepath.Join(dir, "*"+keyFileExtension))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, keyFile := range keyFiles {
+		keyId := strings.TrimSuffix(filepath.Base(keyFile), keyFileExtension)
+		key, err := kc.loadKey(keyId)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load key %s: %w", keyId, err)
+		}
+		kc.keys = append(kc.keys, key)
+	}
+
+	return kc, nil
+}
+
+// Identity returns the CA identity name the keychain was opened for.
+func (kc *Keychain) Identity() enc.Name {
+	return kc.identity
+}
+
+// GenerateKey creates a new key of the given type and stores it without a certificate.
+func (kc *Keychain) GenerateKey(keyType KeyType) (*Key, error) {
+	var privateKey crypto.Signer
+	var err error
+	switch keyType {
+	case KeyTypeEcdsa:
+		privateKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case KeyTypeEd25519:
+		_, privateKey, err = ed25519.GenerateKey(rand.Reader)
+	case KeyTypeRsa:
+		return nil, ErrRsaKey
+	default:
+		return nil, fmt.Errorf("unknown key type %d", keyType)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	keyId := make([]byte, keyIdSizeBytes)
+	if _, err := rand.Read(keyId); err != nil {
+		return nil, err
+	}
+
+	keyName, err := enc.NameFromStr(fmt.Sprintf("%s/%s/%s", kc.identity, keyComponent, hex.EncodeToString(keyId)))
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: pemTypePrivateKey, Bytes: der})
+	if err := os.WriteFile(kc.keyPath(hex.EncodeToString(keyId)), keyPem, 0600); err != nil {
+		return nil, err
+	}
+
+	key := &Key{Name: keyName, privateKey: privateKey}
+
+	kc.mutex.Lock()
+	kc.keys = append(kc.keys, key)
+	kc.mutex.Unlock()
+
+	return key, nil
+}
+
+// SelfSign issues a self-signed certificate for key, making it usable as a root CA key.
+func (kc *Keychain) SelfSign(key *Key, notBefore time.Time, notAfter time.Time) error {
+	publicKey, err := x509.MarshalPKIXPublicKey(key.privateKey.Public())
+	if err != nil {
+		return err
+	}
+
+	signer, err := ndncrypto.NewSigner(key.Name, key.privateKey)
+	if err != nil {
+		return err
+	}
+
+	certName := append(enc.Name{}, key.Name...)
+	certName = append(certName,
+		enc.NewStringComponent(enc.TypeGenericNameComponent, selfIssuerComponent),
+		enc.NewVersionComponent(uint64(time.Now().UnixMilli())))
+
+	certWire, _, err := spec_2022.Spec{}.MakeData(
+		certName,
+		&ndn.DataConfig{
+			ContentType: utils.IdPtr(ndn.ContentTypeKey),
+		},
+		enc.Wire{publicKey},
+		ndncrypto.WithValidity(signer, notBefore, notAfter),
+	)
+	if err != nil {
+		return err
+	}
+
+	return kc.ImportCertificate(certWire.Join())
+}
+
+// ImportCertificate installs a certificate, typically issued by a parent CA, for a key already in the keychain.
+func (kc *Keychain) ImportCertificate(certWire []byte) error {
+	cert, _, err := spec_2022.Spec{}.ReadData(enc.NewBufferReader(certWire))
+	if err != nil {
+		return fmt.Errorf("malformed certificate: %w", err)
+	}
+
+	kc.mutex.Lock()
+	defer kc.mutex.Unlock()
+
+	for _, key := range kc.keys {
+		if !key.Name.IsPrefix(cert.Name()) {
+			continue
+		}
+
+		if err := checkCertificate(key, cert); err != nil {
+			return err
+		}
+
+		certText := base64.StdEncoding.EncodeToString(certWire)
+		if err := os.WriteFile(kc.certPath(keyIdOf(key.Name)), []byte(certText), 0644); err != nil {
+			return err
+		}
+
+		key.certificate = cert
+		key.certWire = certWire
+		return nil
+	}
+
+	return fmt.Errorf("no key in the keychain matches certificate %s", cert.Name())
+}
+
+// ScheduleRollover generates and self-signs a key that becomes active at notBefore.
+// The current key stays valid until its own certificate expires, so the two validity periods overlap.
+func (kc *Keychain) ScheduleRollover(keyType KeyType, notBefore time.Time, notAfter time.Time) (*Key, error) {
+	key, err := kc.GenerateKey(keyType)
+	if err != nil {
+		return nil, err
+	}
+	if err := kc.SelfSign(key, notBefore, notAfter); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// ActiveKey returns the certified key whose validity period began most recently among those valid at t.
+func (kc *Keychain) ActiveKey(t time.Time) (*Key, error) {
+	kc.mutex.RLock()
+	defer kc.mutex.RUnlock()
+
+	var active *Key
+	var activeNotBefore time.Time
+	for _, key := range kc.keys {
+		if key.certificate == nil {
+			continue
+		}
+		notBefore, notAfter, err := key.Validity()
+		if err != nil || t.Before(notBefore) || t.After(notAfter) {
+			continue
+		}
+		if active == nil || notBefore.After(activeNotBefore) {
+			active = key
+			activeNotBefore = notBefore
+		}
+	}
+
+	if active == nil {
+		return nil, fmt.Errorf("no valid key for %s at %s", kc.identity, t)
+	}
+	return active, nil
+}
+
+// Signer returns a signer for the key that is active now.
+func (kc *Keychain) Signer() (ndn.Signer, error) {
+	key, err := kc.ActiveKey(time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return key.Signer()
+}
+
+// Certificate returns the encoded certificate of the key that is active now.
+func (kc *Keychain) Certificate() (enc.Wire, error) {
+	key, err := kc.ActiveKey(time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return enc.Wire{key.certWire}, nil
+}
+
+func (k *Key) Signer() (ndn.Signer, error) {
+	return ndncrypto.NewSigner(k.Name, k.privateKey)
+}
+
+// Certificate returns the certificate of the key, or nil if it has not been certified yet.
+func (k *Key) Certificate() ndn.Data {
+	return k.certificate
+}
+
+// Validity returns the validity period of the certificate of the key.
+func (k *Key) Validity() (time.Time, time.Time, error) {
+	if k.certificate == nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("key %s has no certificate", k.Name)
+	}
+	notBefore, notAfter := k.certificate.Signature().Validity()
+	if notBefore == nil || notAfter == nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("certificate %s has no validity period", k.certificate.Name())
+	}
+	return *notBefore, *notAfter, nil
+}
+
+// checkCertificate verifies that cert is a certificate of key: named after it, with a
+// validity period and the public key of key. Imported and stored certificates both pass it.
+func checkCertificate(key *Key, cert ndn.Data) error {
+	// A certificate name is the key name followed by the issuer and version components.
+	if len(cert.Name()) != len(key.Name)+2 || !key.Name.IsPrefix(cert.Name()) {
+		return fmt.Errorf("certificate %s is not named after key %s", cert.Name(), key.Name)
+	}
+	if notBefore, notAfter := cert.Signature().Validity(); notBefore == nil || notAfter == nil {
+		return fmt.Errorf("certificate %s has no validity period", cert.Name())
+	}
+	certPublicKey, err := ndncrypto.CertificatePublicKey(cert)
+	if err != nil {
+		return err
+	}
+	if !publicKeyEqual(key.privateKey.Public(), certPublicKey) {
+		return fmt.Errorf("certificate %s does not match the stored key", cert.Name())
+	}
+	return nil
+}
+
+func (kc *Keychain) loadKey(keyId string) (*Key, error) {
+	keyPem, err := os.ReadFile(kc.keyPath(keyId))
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(keyPem)
+	if block == nil || block.Type != pemTypePrivateKey {
+		return nil, fmt.Errorf("%s does not contain a %s PEM block", kc.keyPath(keyId), pemTypePrivateKey)
+	}
+
+	parsedKey, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	privateKey, ok := parsedKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", parsedKey)
+	}
+	if _, isRsa := privateKey.(*rsa.PrivateKey); isRsa {
+		return nil, ErrRsaKey
+	}
+
+	keyName, err := enc.NameFromStr(fmt.Sprintf("%s/%s/%s", kc.identity, keyComponent, keyId))
+	if err != nil {
+		return nil, err
+	}
+	key := &Key{Name: keyName, privateKey: privateKey}
+
+	certText, err := os.ReadFile(kc.certPath(keyId))
+	if os.IsNotExist(err) {
+		return key, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	certWire, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(certText)))
+	if err != nil {
+		return nil, fmt.Errorf("malformed certificate file %s: %w", kc.certPath(keyId), err)
+	}
+	cert, _, err := spec_2022.Spec{}.ReadData(enc.NewBufferReader(certWire))
+	if err != nil {
+		return nil, fmt.Errorf("malformed certificate file %s: %w", kc.certPath(keyId), err)
+	}
+	if err := checkCertificate(key, cert); err != nil {
+		return nil, fmt.Errorf("certificate file %s: %w", kc.certPath(keyId), err)
+	}
+
+	key.certificate = cert
+	key.certWire = certWire
+	return key, nil
+}
+
+func (kc *Keychain) keyPath(keyId string) string {
+	return filepath.Join(kc.dir, keyId+keyFileExtension)
+}
+
+func (kc *Keychain) certPath(keyId string) string {
+	return filepath.Join(kc.dir, keyId+certFileExtension)
+}
+
+func keyIdOf(keyName enc.Name) string {
+	return string(keyName[len(keyName)-1].Val)
+}
+
+func publicKeyEqual(a crypto.PublicKey, b crypto.PublicKey) bool {
+	aDer, aErr := x509.MarshalPKIXPublicKey(a)
+	bDer, bErr := x509.MarshalPKIXPublicKey(b)
+	return aErr == nil && bErr == nil && bytes.Equal(aDer, bDer)
+}
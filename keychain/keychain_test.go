@@ -0,0 +1,155 @@
+package keychain
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	enc "github.com/zjkmxy/go-ndn/pkg/encoding"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestKeychainReload(t *testing.T) {
+	dir := t.TempDir()
+	identity, _ := enc.NameFromStr("/ndn")
+
+	kc, err := Open(dir, identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := kc.ScheduleRollover(KeyTypeEd25519, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kc.GenerateKey(KeyTypeEcdsa); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := Open(dir, identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reloaded.keys) != 2 {
+		t.Errorf("failed to reload both keys from disk, got %d", len(reloaded.keys))
+	}
+
+	active, err := reloaded.ActiveKey(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !active.Name.Equal(key.Name) {
+		t.Errorf("failed to select the only certified key %s, got %s", key.Name, active.Name)
+	}
+}
+
+func TestKeychainRollover(t *testing.T) {
+	identity, _ := enc.NameFromStr("/ndn")
+	kc, err := Open(t.TempDir(), identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	oldKey, err := kc.ScheduleRollover(KeyTypeEcdsa, now.Add(-2*time.Hour), now.Add(2*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	newKey, err := kc.ScheduleRollover(KeyTypeEcdsa, now.Add(time.Hour), now.Add(4*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	active, _ := kc.ActiveKey(now)
+	if !active.Name.Equal(oldKey.Name) {
+		t.Error("failed to keep the current key active before the rollover time")
+	}
+
+	active, _ = kc.ActiveKey(now.Add(90 * time.Minute))
+	if !active.Name.Equal(newKey.Name) {
+		t.Error("failed to switch to the new key during the overlap")
+	}
+
+	if _, err := kc.ActiveKey(now.Add(5 * time.Hour)); err == nil {
+		t.Error("failed to return error once every certificate has expired")
+	}
+}
+
+func TestImportCertificateMismatch(t *testing.T) {
+	identity, _ := enc.NameFromStr("/ndn")
+	kc, err := Open(t.TempDir(), identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := Open(t.TempDir(), identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, _ := other.ScheduleRollover(KeyTypeEcdsa, time.Now(), time.Now().Add(time.Hour))
+	if err := kc.ImportCertificate(other.keys[0].certWire); err == nil {
+		t.Errorf("failed to reject certificate %s for a key not in the keychain", key.Name)
+	}
+}
+
+func TestLoadKeyChecksCertificate(t *testing.T) {
+	identity, _ := enc.NameFromStr("/ndn")
+	dir := t.TempDir()
+	kc, err := Open(dir, identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := kc.GenerateKey(KeyTypeEcdsa)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := key.Validity(); err == nil {
+		t.Error("failed to report that an uncertified key has no validity period")
+	}
+
+	other, err := Open(t.TempDir(), identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := other.ScheduleRollover(KeyTypeEcdsa, time.Now(), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	certText, err := os.ReadFile(other.certPath(keyIdOf(otherKey.Name)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(kc.certPath(keyIdOf(key.Name)), certText, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(dir, identity); err == nil {
+		t.Error("failed to reject a stored certificate of another key")
+	}
+}
+
+func TestRsaKeyRefused(t *testing.T) {
+	identity, _ := enc.NameFromStr("/ndn")
+	dir := t.TempDir()
+	kc, err := Open(dir, identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kc.GenerateKey(KeyTypeRsa); !errors.Is(err, ErrRsaKey) {
+		t.Errorf("failed to refuse an RSA CA key, got %v", err)
+	}
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, _ := x509.MarshalPKCS8PrivateKey(privateKey)
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: pemTypePrivateKey, Bytes: der})
+	if err := os.WriteFile(kc.keyPath("0102030405060708"), keyPem, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(dir, identity); !errors.Is(err, ErrRsaKey) {
+		t.Errorf("failed to refuse a stored RSA key, got %v", err)
+	}
+}
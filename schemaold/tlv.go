@@ -16,6 +16,10 @@ type CaProfile struct {
 	MaxValidPeriod uint64 `tlv:"0x8B"`
 	//+field:wire
 	CaCert enc.Wire `tlv:"0x89"`
+	//+field:sequence:string:string
+	CryptoSuite []string `tlv:"0xC4"`
+	//+field:natural:optional
+	KeySchedule *uint64 `tlv:"0xC6"`
 }
 
 // The original definition in the spec cannot be organized into a struct
@@ -89,3 +93,22 @@ type ChallengeDataPlain struct {
 	//+field:sequence:*Param:struct:Param
 	Params []*Param `tlv:"0xC1"`
 }
+
+type ErrorMsg struct {
+	//+field:natural
+	ErrorCode uint64 `tlv:"0xAB"`
+	//+field:string
+	ErrorInfo string `tlv:"0xAD"`
+}
+
+type ProbeResponse struct {
+	//+field:name
+	Response enc.Name `tlv:"0x07"`
+	//+field:natural:optional
+	MaxSuffixLength *uint64 `tlv:"0x8F"`
+}
+
+type ProbeResData struct {
+	//+field:sequence:*ProbeResponse:struct:ProbeResponse
+	Responses []*ProbeResponse `tlv:"0x8D"`
+}
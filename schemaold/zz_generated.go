@@ -17,7 +17,9 @@ type CaProfileEncoder struct {
 	ParamKey_subencoder []struct {
 	}
 
-	CaCert_length uint
+	CaCert_length          uint
+	CryptoSuite_subencoder []struct {
+	}
 }
 
 type CaProfileParsingContext struct {
@@ -59,6 +61,27 @@ func (encoder *CaProfileEncoder) Init(value *CaProfile) {
 		}
 	}
 
+	{
+		CryptoSuite_l := len(value.CryptoSuite)
+		encoder.CryptoSuite_subencoder = make([]struct {
+		}, CryptoSuite_l)
+		for i := 0; i < CryptoSuite_l; i++ {
+			pseudoEncoder := &encoder.CryptoSuite_subencoder[i]
+			pseudoValue := struct {
+				CryptoSuite string
+			}{
+				CryptoSuite: value.CryptoSuite[i],
+			}
+			{
+				encoder := pseudoEncoder
+				value := &pseudoValue
+
+				_ = encoder
+				_ = value
+			}
+		}
+	}
+
 	l := uint(0)
 	if value.CaPrefix != nil {
 		l += 1
@@ -145,6 +168,50 @@ func (encoder *CaProfileEncoder) Init(value *CaProfile) {
 		l += encoder.CaCert_length
 	}
 
+	if value.CryptoSuite != nil {
+		for seq_i, seq_v := range value.CryptoSuite {
+			pseudoEncoder := &encoder.CryptoSuite_subencoder[seq_i]
+			pseudoValue := struct {
+				CryptoSuite string
+			}{
+				CryptoSuite: seq_v,
+			}
+			{
+				encoder := pseudoEncoder
+				value := &pseudoValue
+				l += 1
+				switch x := len(value.CryptoSuite); {
+				case x <= 0xfc:
+					l += 1
+				case x <= 0xffff:
+					l += 3
+				case x <= 0xffffffff:
+					l += 5
+				default:
+					l += 9
+				}
+				l += uint(len(value.CryptoSuite))
+
+				_ = encoder
+				_ = value
+			}
+		}
+	}
+
+	if value.KeySchedule != nil {
+		l += 1
+		switch x := *value.KeySchedule; {
+		case x <= 0xff:
+			l += 2
+		case x <= 0xffff:
+			l += 3
+		case x <= 0xffffffff:
+			l += 5
+		default:
+			l += 9
+		}
+	}
+
 	encoder.length = l
 
 }
@@ -289,6 +356,68 @@ func (encoder *CaProfileEncoder) EncodeInto(value *CaProfile, buf []byte) {
 		}
 	}
 
+	if value.CryptoSuite != nil {
+		for seq_i, seq_v := range value.CryptoSuite {
+			pseudoEncoder := &encoder.CryptoSuite_subencoder[seq_i]
+			pseudoValue := struct {
+				CryptoSuite string
+			}{
+				CryptoSuite: seq_v,
+			}
+			{
+				encoder := pseudoEncoder
+				value := &pseudoValue
+				buf[pos] = byte(196)
+				pos += 1
+				switch x := len(value.CryptoSuite); {
+				case x <= 0xfc:
+					buf[pos] = byte(x)
+					pos += 1
+				case x <= 0xffff:
+					buf[pos] = 0xfd
+					binary.BigEndian.PutUint16(buf[pos+1:], uint16(x))
+					pos += 3
+				case x <= 0xffffffff:
+					buf[pos] = 0xfe
+					binary.BigEndian.PutUint32(buf[pos+1:], uint32(x))
+					pos += 5
+				default:
+					buf[pos] = 0xff
+					binary.BigEndian.PutUint64(buf[pos+1:], uint64(x))
+					pos += 9
+				}
+				copy(buf[pos:], value.CryptoSuite)
+				pos += uint(len(value.CryptoSuite))
+
+				_ = encoder
+				_ = value
+			}
+		}
+	}
+
+	if value.KeySchedule != nil {
+		buf[pos] = byte(198)
+		pos += 1
+		switch x := *value.KeySchedule; {
+		case x <= 0xff:
+			buf[pos] = 1
+			buf[pos+1] = byte(x)
+			pos += 2
+		case x <= 0xffff:
+			buf[pos] = 2
+			binary.BigEndian.PutUint16(buf[pos+1:], uint16(x))
+			pos += 3
+		case x <= 0xffffffff:
+			buf[pos] = 4
+			binary.BigEndian.PutUint32(buf[pos+1:], uint32(x))
+			pos += 5
+		default:
+			buf[pos] = 8
+			binary.BigEndian.PutUint64(buf[pos+1:], uint64(x))
+			pos += 9
+		}
+	}
+
 }
 
 func (encoder *CaProfileEncoder) Encode(value *CaProfile) enc.Wire {
@@ -414,6 +543,56 @@ func (context *CaProfileParsingContext) Parse(reader enc.ParseReader, ignoreCrit
 					handled = true
 					value.CaCert, err = reader.ReadWire(int(l))
 
+				}
+			case 196:
+				if progress+1 == 5 {
+					handled = true
+					if value.CryptoSuite == nil {
+						value.CryptoSuite = make([]string, 0)
+					}
+					{
+						pseudoValue := struct {
+							CryptoSuite string
+						}{}
+						{
+							value := &pseudoValue
+							{
+								var builder strings.Builder
+								_, err = io.CopyN(&builder, reader, int64(l))
+								if err == nil {
+									value.CryptoSuite = builder.String()
+								}
+							}
+
+							_ = value
+						}
+						value.CryptoSuite = append(value.CryptoSuite, pseudoValue.CryptoSuite)
+					}
+					progress--
+
+				}
+			case 198:
+				if progress+1 == 6 {
+					handled = true
+					{
+						tempVal := uint64(0)
+						tempVal = uint64(0)
+						{
+							for i := 0; i < int(l); i++ {
+								x := byte(0)
+								x, err = reader.ReadByte()
+								if err != nil {
+									if err == io.EOF {
+										err = io.ErrUnexpectedEOF
+									}
+									break
+								}
+								tempVal = uint64(tempVal<<8) | uint64(x)
+							}
+						}
+						value.KeySchedule = &tempVal
+					}
+
 				}
 			default:
 				handled = true
@@ -434,6 +613,10 @@ func (context *CaProfileParsingContext) Parse(reader enc.ParseReader, ignoreCrit
 					err = enc.ErrSkipRequired{Name: "MaxValidPeriod", TypeNum: 139}
 				case 4 - 1:
 					value.CaCert = nil
+				case 5 - 1:
+
+				case 6 - 1:
+					value.KeySchedule = nil
 				}
 			}
 			if err != nil {
@@ -442,7 +625,7 @@ func (context *CaProfileParsingContext) Parse(reader enc.ParseReader, ignoreCrit
 		}
 	}
 	startPos = reader.Pos()
-	for ; progress < 5; progress++ {
+	for ; progress < 7; progress++ {
 		switch progress {
 		case 0 - 1:
 			value.CaPrefix = nil
@@ -454,6 +637,10 @@ func (context *CaProfileParsingContext) Parse(reader enc.ParseReader, ignoreCrit
 			err = enc.ErrSkipRequired{Name: "MaxValidPeriod", TypeNum: 139}
 		case 4 - 1:
 			value.CaCert = nil
+		case 5 - 1:
+
+		case 6 - 1:
+			value.KeySchedule = nil
 		}
 	}
 	if err != nil {
@@ -2873,3 +3060,683 @@ func ParseChallengeDataPlain(reader enc.ParseReader, ignoreCritical bool) (*Chal
 	context.Init()
 	return context.Parse(reader, ignoreCritical)
 }
+
+type ErrorMsgEncoder struct {
+	length uint
+}
+
+type ErrorMsgParsingContext struct {
+}
+
+func (encoder *ErrorMsgEncoder) Init(value *ErrorMsg) {
+
+	l := uint(0)
+	l += 1
+	switch x := value.ErrorCode; {
+	case x <= 0xff:
+		l += 2
+	case x <= 0xffff:
+		l += 3
+	case x <= 0xffffffff:
+		l += 5
+	default:
+		l += 9
+	}
+
+	l += 1
+	switch x := len(value.ErrorInfo); {
+	case x <= 0xfc:
+		l += 1
+	case x <= 0xffff:
+		l += 3
+	case x <= 0xffffffff:
+		l += 5
+	default:
+		l += 9
+	}
+	l += uint(len(value.ErrorInfo))
+
+	encoder.length = l
+
+}
+
+func (context *ErrorMsgParsingContext) Init() {
+
+}
+
+func (encoder *ErrorMsgEncoder) EncodeInto(value *ErrorMsg, buf []byte) {
+
+	pos := uint(0)
+	buf[pos] = byte(171)
+	pos += 1
+	switch x := value.ErrorCode; {
+	case x <= 0xff:
+		buf[pos] = 1
+		buf[pos+1] = byte(x)
+		pos += 2
+	case x <= 0xffff:
+		buf[pos] = 2
+		binary.BigEndian.PutUint16(buf[pos+1:], uint16(x))
+		pos += 3
+	case x <= 0xffffffff:
+		buf[pos] = 4
+		binary.BigEndian.PutUint32(buf[pos+1:], uint32(x))
+		pos += 5
+	default:
+		buf[pos] = 8
+		binary.BigEndian.PutUint64(buf[pos+1:], uint64(x))
+		pos += 9
+	}
+
+	buf[pos] = byte(173)
+	pos += 1
+	switch x := len(value.ErrorInfo); {
+	case x <= 0xfc:
+		buf[pos] = byte(x)
+		pos += 1
+	case x <= 0xffff:
+		buf[pos] = 0xfd
+		binary.BigEndian.PutUint16(buf[pos+1:], uint16(x))
+		pos += 3
+	case x <= 0xffffffff:
+		buf[pos] = 0xfe
+		binary.BigEndian.PutUint32(buf[pos+1:], uint32(x))
+		pos += 5
+	default:
+		buf[pos] = 0xff
+		binary.BigEndian.PutUint64(buf[pos+1:], uint64(x))
+		pos += 9
+	}
+	copy(buf[pos:], value.ErrorInfo)
+	pos += uint(len(value.ErrorInfo))
+
+}
+
+func (encoder *ErrorMsgEncoder) Encode(value *ErrorMsg) enc.Wire {
+
+	wire := make(enc.Wire, 1)
+	wire[0] = make([]byte, encoder.length)
+	buf := wire[0]
+	encoder.EncodeInto(value, buf)
+
+	return wire
+}
+
+func (context *ErrorMsgParsingContext) Parse(reader enc.ParseReader, ignoreCritical bool) (*ErrorMsg, error) {
+	if reader == nil {
+		return nil, enc.ErrBufferOverflow
+	}
+	progress := -1
+	value := &ErrorMsg{}
+	var err error
+	var startPos int
+	for {
+		startPos = reader.Pos()
+		if startPos >= reader.Length() {
+			break
+		}
+		typ := enc.TLNum(0)
+		l := enc.TLNum(0)
+		typ, err = enc.ReadTLNum(reader)
+		if err != nil {
+			return nil, enc.ErrFailToParse{TypeNum: 0, Err: err}
+		}
+		l, err = enc.ReadTLNum(reader)
+		if err != nil {
+			return nil, enc.ErrFailToParse{TypeNum: 0, Err: err}
+		}
+		err = nil
+		for handled := false; !handled; progress++ {
+			switch typ {
+			case 171:
+				if progress+1 == 0 {
+					handled = true
+					value.ErrorCode = uint64(0)
+					{
+						for i := 0; i < int(l); i++ {
+							x := byte(0)
+							x, err = reader.ReadByte()
+							if err != nil {
+								if err == io.EOF {
+									err = io.ErrUnexpectedEOF
+								}
+								break
+							}
+							value.ErrorCode = uint64(value.ErrorCode<<8) | uint64(x)
+						}
+					}
+				}
+			case 173:
+				if progress+1 == 1 {
+					handled = true
+					{
+						var builder strings.Builder
+						_, err = io.CopyN(&builder, reader, int64(l))
+						if err == nil {
+							value.ErrorInfo = builder.String()
+						}
+					}
+
+				}
+			default:
+				handled = true
+				if !ignoreCritical && ((typ <= 31) || ((typ & 1) == 1)) {
+					return nil, enc.ErrUnrecognizedField{TypeNum: typ}
+				}
+				err = reader.Skip(int(l))
+			}
+			if err == nil && !handled {
+				switch progress {
+				case 0 - 1:
+					err = enc.ErrSkipRequired{Name: "ErrorCode", TypeNum: 171}
+				case 1 - 1:
+					err = enc.ErrSkipRequired{Name: "ErrorInfo", TypeNum: 173}
+				}
+			}
+			if err != nil {
+				return nil, enc.ErrFailToParse{TypeNum: typ, Err: err}
+			}
+		}
+	}
+	startPos = reader.Pos()
+	for ; progress < 2; progress++ {
+		switch progress {
+		case 0 - 1:
+			err = enc.ErrSkipRequired{Name: "ErrorCode", TypeNum: 171}
+		case 1 - 1:
+			err = enc.ErrSkipRequired{Name: "ErrorInfo", TypeNum: 173}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+func (value *ErrorMsg) Encode() enc.Wire {
+	encoder := ErrorMsgEncoder{}
+	encoder.Init(value)
+	return encoder.Encode(value)
+}
+
+func (value *ErrorMsg) Bytes() []byte {
+	return value.Encode().Join()
+}
+
+func ParseErrorMsg(reader enc.ParseReader, ignoreCritical bool) (*ErrorMsg, error) {
+	context := ErrorMsgParsingContext{}
+	context.Init()
+	return context.Parse(reader, ignoreCritical)
+}
+
+type ProbeResponseEncoder struct {
+	length uint
+
+	Response_length uint
+}
+
+type ProbeResponseParsingContext struct {
+}
+
+func (encoder *ProbeResponseEncoder) Init(value *ProbeResponse) {
+	if value.Response != nil {
+		encoder.Response_length = 0
+		for _, c := range value.Response {
+			encoder.Response_length += uint(c.EncodingLength())
+		}
+	}
+
+	l := uint(0)
+	if value.Response != nil {
+		l += 1
+		switch x := encoder.Response_length; {
+		case x <= 0xfc:
+			l += 1
+		case x <= 0xffff:
+			l += 3
+		case x <= 0xffffffff:
+			l += 5
+		default:
+			l += 9
+		}
+		l += encoder.Response_length
+	}
+
+	if value.MaxSuffixLength != nil {
+		l += 1
+		switch x := *value.MaxSuffixLength; {
+		case x <= 0xff:
+			l += 2
+		case x <= 0xffff:
+			l += 3
+		case x <= 0xffffffff:
+			l += 5
+		default:
+			l += 9
+		}
+	}
+
+	encoder.length = l
+
+}
+
+func (context *ProbeResponseParsingContext) Init() {
+
+}
+
+func (encoder *ProbeResponseEncoder) EncodeInto(value *ProbeResponse, buf []byte) {
+
+	pos := uint(0)
+	if value.Response != nil {
+		buf[pos] = byte(7)
+		pos += 1
+		switch x := encoder.Response_length; {
+		case x <= 0xfc:
+			buf[pos] = byte(x)
+			pos += 1
+		case x <= 0xffff:
+			buf[pos] = 0xfd
+			binary.BigEndian.PutUint16(buf[pos+1:], uint16(x))
+			pos += 3
+		case x <= 0xffffffff:
+			buf[pos] = 0xfe
+			binary.BigEndian.PutUint32(buf[pos+1:], uint32(x))
+			pos += 5
+		default:
+			buf[pos] = 0xff
+			binary.BigEndian.PutUint64(buf[pos+1:], uint64(x))
+			pos += 9
+		}
+		for _, c := range value.Response {
+			pos += uint(c.EncodeInto(buf[pos:]))
+		}
+	}
+
+	if value.MaxSuffixLength != nil {
+		buf[pos] = byte(143)
+		pos += 1
+		switch x := *value.MaxSuffixLength; {
+		case x <= 0xff:
+			buf[pos] = 1
+			buf[pos+1] = byte(x)
+			pos += 2
+		case x <= 0xffff:
+			buf[pos] = 2
+			binary.BigEndian.PutUint16(buf[pos+1:], uint16(x))
+			pos += 3
+		case x <= 0xffffffff:
+			buf[pos] = 4
+			binary.BigEndian.PutUint32(buf[pos+1:], uint32(x))
+			pos += 5
+		default:
+			buf[pos] = 8
+			binary.BigEndian.PutUint64(buf[pos+1:], uint64(x))
+			pos += 9
+		}
+	}
+
+}
+
+func (encoder *ProbeResponseEncoder) Encode(value *ProbeResponse) enc.Wire {
+
+	wire := make(enc.Wire, 1)
+	wire[0] = make([]byte, encoder.length)
+	buf := wire[0]
+	encoder.EncodeInto(value, buf)
+
+	return wire
+}
+
+func (context *ProbeResponseParsingContext) Parse(reader enc.ParseReader, ignoreCritical bool) (*ProbeResponse, error) {
+	if reader == nil {
+		return nil, enc.ErrBufferOverflow
+	}
+	progress := -1
+	value := &ProbeResponse{}
+	var err error
+	var startPos int
+	for {
+		startPos = reader.Pos()
+		if startPos >= reader.Length() {
+			break
+		}
+		typ := enc.TLNum(0)
+		l := enc.TLNum(0)
+		typ, err = enc.ReadTLNum(reader)
+		if err != nil {
+			return nil, enc.ErrFailToParse{TypeNum: 0, Err: err}
+		}
+		l, err = enc.ReadTLNum(reader)
+		if err != nil {
+			return nil, enc.ErrFailToParse{TypeNum: 0, Err: err}
+		}
+		err = nil
+		for handled := false; !handled; progress++ {
+			switch typ {
+			case 7:
+				if progress+1 == 0 {
+					handled = true
+					value.Response = make(enc.Name, l/2+1)
+					startName := reader.Pos()
+					endName := startName + int(l)
+					for j := range value.Response {
+						if reader.Pos() >= endName {
+							value.Response = value.Response[:j]
+							break
+						}
+						var err1, err3 error
+						value.Response[j].Typ, err1 = enc.ReadTLNum(reader)
+						l, err2 := enc.ReadTLNum(reader)
+						value.Response[j].Val, err3 = reader.ReadBuf(int(l))
+						if err1 != nil || err2 != nil || err3 != nil {
+							err = io.ErrUnexpectedEOF
+							break
+						}
+					}
+					if err == nil && reader.Pos() != endName {
+						err = enc.ErrBufferOverflow
+					}
+
+				}
+			case 143:
+				if progress+1 == 1 {
+					handled = true
+					{
+						tempVal := uint64(0)
+						tempVal = uint64(0)
+						{
+							for i := 0; i < int(l); i++ {
+								x := byte(0)
+								x, err = reader.ReadByte()
+								if err != nil {
+									if err == io.EOF {
+										err = io.ErrUnexpectedEOF
+									}
+									break
+								}
+								tempVal = uint64(tempVal<<8) | uint64(x)
+							}
+						}
+						value.MaxSuffixLength = &tempVal
+					}
+
+				}
+			default:
+				handled = true
+				if !ignoreCritical && ((typ <= 31) || ((typ & 1) == 1)) {
+					return nil, enc.ErrUnrecognizedField{TypeNum: typ}
+				}
+				err = reader.Skip(int(l))
+			}
+			if err == nil && !handled {
+				switch progress {
+				case 0 - 1:
+					value.Response = nil
+				case 1 - 1:
+					value.MaxSuffixLength = nil
+				}
+			}
+			if err != nil {
+				return nil, enc.ErrFailToParse{TypeNum: typ, Err: err}
+			}
+		}
+	}
+	startPos = reader.Pos()
+	for ; progress < 2; progress++ {
+		switch progress {
+		case 0 - 1:
+			value.Response = nil
+		case 1 - 1:
+			value.MaxSuffixLength = nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+func (value *ProbeResponse) Encode() enc.Wire {
+	encoder := ProbeResponseEncoder{}
+	encoder.Init(value)
+	return encoder.Encode(value)
+}
+
+func (value *ProbeResponse) Bytes() []byte {
+	return value.Encode().Join()
+}
+
+func ParseProbeResponse(reader enc.ParseReader, ignoreCritical bool) (*ProbeResponse, error) {
+	context := ProbeResponseParsingContext{}
+	context.Init()
+	return context.Parse(reader, ignoreCritical)
+}
+
+type ProbeResDataEncoder struct {
+	length uint
+
+	Responses_subencoder []struct {
+		Responses_encoder ProbeResponseEncoder
+	}
+}
+
+type ProbeResDataParsingContext struct {
+	Responses_context ProbeResponseParsingContext
+}
+
+func (encoder *ProbeResDataEncoder) Init(value *ProbeResData) {
+	{
+		Responses_l := len(value.Responses)
+		encoder.Responses_subencoder = make([]struct {
+			Responses_encoder ProbeResponseEncoder
+		}, Responses_l)
+		for i := 0; i < Responses_l; i++ {
+			pseudoEncoder := &encoder.Responses_subencoder[i]
+			pseudoValue := struct {
+				Responses *ProbeResponse
+			}{
+				Responses: value.Responses[i],
+			}
+			{
+				encoder := pseudoEncoder
+				value := &pseudoValue
+				if value.Responses != nil {
+					encoder.Responses_encoder.Init(value.Responses)
+				}
+				_ = encoder
+				_ = value
+			}
+		}
+	}
+
+	l := uint(0)
+	if value.Responses != nil {
+		for seq_i, seq_v := range value.Responses {
+			pseudoEncoder := &encoder.Responses_subencoder[seq_i]
+			pseudoValue := struct {
+				Responses *ProbeResponse
+			}{
+				Responses: seq_v,
+			}
+			{
+				encoder := pseudoEncoder
+				value := &pseudoValue
+				if value.Responses != nil {
+					l += 1
+					switch x := encoder.Responses_encoder.length; {
+					case x <= 0xfc:
+						l += 1
+					case x <= 0xffff:
+						l += 3
+					case x <= 0xffffffff:
+						l += 5
+					default:
+						l += 9
+					}
+					l += encoder.Responses_encoder.length
+				}
+
+				_ = encoder
+				_ = value
+			}
+		}
+	}
+
+	encoder.length = l
+
+}
+
+func (context *ProbeResDataParsingContext) Init() {
+	context.Responses_context.Init()
+}
+
+func (encoder *ProbeResDataEncoder) EncodeInto(value *ProbeResData, buf []byte) {
+
+	pos := uint(0)
+	if value.Responses != nil {
+		for seq_i, seq_v := range value.Responses {
+			pseudoEncoder := &encoder.Responses_subencoder[seq_i]
+			pseudoValue := struct {
+				Responses *ProbeResponse
+			}{
+				Responses: seq_v,
+			}
+			{
+				encoder := pseudoEncoder
+				value := &pseudoValue
+				if value.Responses != nil {
+					buf[pos] = byte(141)
+					pos += 1
+					switch x := encoder.Responses_encoder.length; {
+					case x <= 0xfc:
+						buf[pos] = byte(x)
+						pos += 1
+					case x <= 0xffff:
+						buf[pos] = 0xfd
+						binary.BigEndian.PutUint16(buf[pos+1:], uint16(x))
+						pos += 3
+					case x <= 0xffffffff:
+						buf[pos] = 0xfe
+						binary.BigEndian.PutUint32(buf[pos+1:], uint32(x))
+						pos += 5
+					default:
+						buf[pos] = 0xff
+						binary.BigEndian.PutUint64(buf[pos+1:], uint64(x))
+						pos += 9
+					}
+					if encoder.Responses_encoder.length > 0 {
+						encoder.Responses_encoder.EncodeInto(value.Responses, buf[pos:])
+						pos += encoder.Responses_encoder.length
+					}
+				}
+
+				_ = encoder
+				_ = value
+			}
+		}
+	}
+
+}
+
+func (encoder *ProbeResDataEncoder) Encode(value *ProbeResData) enc.Wire {
+
+	wire := make(enc.Wire, 1)
+	wire[0] = make([]byte, encoder.length)
+	buf := wire[0]
+	encoder.EncodeInto(value, buf)
+
+	return wire
+}
+
+func (context *ProbeResDataParsingContext) Parse(reader enc.ParseReader, ignoreCritical bool) (*ProbeResData, error) {
+	if reader == nil {
+		return nil, enc.ErrBufferOverflow
+	}
+	progress := -1
+	value := &ProbeResData{}
+	var err error
+	var startPos int
+	for {
+		startPos = reader.Pos()
+		if startPos >= reader.Length() {
+			break
+		}
+		typ := enc.TLNum(0)
+		l := enc.TLNum(0)
+		typ, err = enc.ReadTLNum(reader)
+		if err != nil {
+			return nil, enc.ErrFailToParse{TypeNum: 0, Err: err}
+		}
+		l, err = enc.ReadTLNum(reader)
+		if err != nil {
+			return nil, enc.ErrFailToParse{TypeNum: 0, Err: err}
+		}
+		err = nil
+		for handled := false; !handled; progress++ {
+			switch typ {
+			case 141:
+				if progress+1 == 0 {
+					handled = true
+					if value.Responses == nil {
+						value.Responses = make([]*ProbeResponse, 0)
+					}
+					{
+						pseudoValue := struct {
+							Responses *ProbeResponse
+						}{}
+						{
+							value := &pseudoValue
+							value.Responses, err = context.Responses_context.Parse(reader.Delegate(int(l)), ignoreCritical)
+							_ = value
+						}
+						value.Responses = append(value.Responses, pseudoValue.Responses)
+					}
+					progress--
+
+				}
+			default:
+				handled = true
+				if !ignoreCritical && ((typ <= 31) || ((typ & 1) == 1)) {
+					return nil, enc.ErrUnrecognizedField{TypeNum: typ}
+				}
+				err = reader.Skip(int(l))
+			}
+			if err == nil && !handled {
+				switch progress {
+				case 0 - 1:
+
+				}
+			}
+			if err != nil {
+				return nil, enc.ErrFailToParse{TypeNum: typ, Err: err}
+			}
+		}
+	}
+	startPos = reader.Pos()
+	for ; progress < 1; progress++ {
+		switch progress {
+		case 0 - 1:
+
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+func (value *ProbeResData) Encode() enc.Wire {
+	encoder := ProbeResDataEncoder{}
+	encoder.Init(value)
+	return encoder.Encode(value)
+}
+
+func (value *ProbeResData) Bytes() []byte {
+	return value.Encode().Join()
+}
+
+func ParseProbeResData(reader enc.ParseReader, ignoreCritical bool) (*ProbeResData, error) {
+	context := ProbeResDataParsingContext{}
+	context.Init()
+	return context.Parse(reader, ignoreCritical)
+}